@@ -0,0 +1,266 @@
+package balancer
+
+import (
+	"errors"
+	"hash/crc32"
+	"math/rand"
+	"sort"
+	"strconv"
+	"sync"
+
+	"github.com/mizumoto-cn/TRPcG/metadata"
+	"github.com/mizumoto-cn/TRPcG/resolver"
+)
+
+var ErrNoBackend = errors.New("no backend to pick from")
+
+// Backend is a backend as seen by a Balancer
+type Backend interface {
+	Address() resolver.Address
+	InFlight() int // calls sent to the backend and not answered yet
+}
+
+// PickInfo describes the call a backend is picked for
+type PickInfo struct {
+	ServiceMethod string
+	Metadata      metadata.MD // outgoing metadata of the call
+}
+
+// Balancer picks the backend of each call among the available ones.
+// The set of backends may change from one call to the next.
+type Balancer interface {
+	Pick(info PickInfo, backends []Backend) (Backend, error)
+}
+
+// Updater is implemented by the balancers following the whole set of resolved
+// addresses, the backends picked among being the available ones only
+type Updater interface {
+	Update(addrs []resolver.Address)
+}
+
+// weight of a backend, 1 if not set
+func weight(b Backend) int {
+	return addrWeight(b.Address())
+}
+
+// addrWeight is the weight of an address, 1 if not set
+func addrWeight(a resolver.Address) int {
+	if a.Weight > 0 {
+		return a.Weight
+	}
+	return 1
+}
+
+type roundRobin struct {
+	mutex sync.Mutex
+	next  int
+}
+
+// RoundRobin picks the backends in turn
+func RoundRobin() Balancer {
+	return &roundRobin{}
+}
+
+func (r *roundRobin) Pick(_ PickInfo, backends []Backend) (Backend, error) {
+	if len(backends) == 0 {
+		return nil, ErrNoBackend
+	}
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	b := backends[r.next%len(backends)]
+	r.next = (r.next + 1) % len(backends)
+	return b, nil
+}
+
+type weightedRoundRobin struct {
+	mutex   sync.Mutex
+	current map[string]int // current weight by address
+}
+
+// WeightedRoundRobin picks the backends in turn, in proportion to their weights.
+// It is the smooth weighted round robin of nginx, which interleaves the picks
+// of heavy backends with the others instead of picking them in bursts.
+func WeightedRoundRobin() Balancer {
+	return &weightedRoundRobin{current: make(map[string]int)}
+}
+
+func (w *weightedRoundRobin) Pick(_ PickInfo, backends []Backend) (Backend, error) {
+	if len(backends) == 0 {
+		return nil, ErrNoBackend
+	}
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	total := 0
+	var best Backend
+	current := make(map[string]int, len(backends))
+	for _, b := range backends {
+		addr := b.Address().Addr
+		current[addr] = w.current[addr] + weight(b)
+		total += weight(b)
+		if best == nil || current[addr] > current[best.Address().Addr] {
+			best = b
+		}
+	}
+	current[best.Address().Addr] -= total
+	// backends gone from the set are forgotten
+	w.current = current
+	return best, nil
+}
+
+type random struct {
+	mutex sync.Mutex
+	rand  *rand.Rand
+}
+
+// Random picks a backend at random
+func Random() Balancer {
+	return &random{rand: rand.New(rand.NewSource(rand.Int63()))}
+}
+
+func (r *random) Pick(_ PickInfo, backends []Backend) (Backend, error) {
+	if len(backends) == 0 {
+		return nil, ErrNoBackend
+	}
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return backends[r.rand.Intn(len(backends))], nil
+}
+
+type p2c struct {
+	random
+}
+
+// P2C picks two backends at random, and keeps the one with fewer calls in flight
+func P2C() Balancer {
+	return &p2c{random{rand: rand.New(rand.NewSource(rand.Int63()))}}
+}
+
+func (p *p2c) Pick(_ PickInfo, backends []Backend) (Backend, error) {
+	if len(backends) < 2 {
+		return p.random.Pick(PickInfo{}, backends)
+	}
+	p.mutex.Lock()
+	i := p.rand.Intn(len(backends))
+	j := p.rand.Intn(len(backends) - 1)
+	p.mutex.Unlock()
+	if j >= i {
+		j++
+	}
+	if backends[j].InFlight() < backends[i].InFlight() {
+		return backends[j], nil
+	}
+	return backends[i], nil
+}
+
+// replicas is the number of points of a backend of weight 1 on the hash ring
+const replicas = 100
+
+// maxRing bounds the number of points on the hash ring, the points of the
+// backends being scaled down in proportion to their weights beyond
+const maxRing = 1 << 16
+
+type consistentHash struct {
+	key      string
+	fallback Balancer
+
+	mutex   sync.Mutex
+	updated bool     // whether the ring follows Update rather than the backends picked among
+	keys    []string // address and weight of the backends on the ring
+	ring    []point
+}
+
+type point struct {
+	hash uint32
+	addr string
+}
+
+// ConsistentHash routes the calls carrying the same value of the metadata key to the
+// same backend, as long as it is available. When a backend comes or goes, only the
+// calls of the values it owned move. Calls without the key are picked at random.
+// The ring holds the addresses given to Update, the calls of an unavailable
+// backend going to the next available one on the ring.
+func ConsistentHash(key string) Balancer {
+	return &consistentHash{key: key, fallback: Random()}
+}
+
+// Update implements Updater
+func (c *consistentHash) Update(addrs []resolver.Address) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.updated = true
+	c.build(addrs)
+}
+
+func (c *consistentHash) Pick(info PickInfo, backends []Backend) (Backend, error) {
+	value := info.Metadata.Get(c.key)
+	if value == "" || len(backends) == 0 {
+		return c.fallback.Pick(info, backends)
+	}
+	byAddr := make(map[string]Backend, len(backends))
+	for _, b := range backends {
+		byAddr[b.Address().Addr] = b
+	}
+
+	c.mutex.Lock()
+	if !c.updated {
+		addrs := make([]resolver.Address, 0, len(backends))
+		for _, b := range backends {
+			addrs = append(addrs, b.Address())
+		}
+		c.build(addrs)
+	}
+	h := crc32.ChecksumIEEE([]byte(value))
+	i := sort.Search(len(c.ring), func(i int) bool { return c.ring[i].hash >= h })
+	// the first available backend from the owner of the value on
+	var picked Backend
+	for n := 0; n < len(c.ring) && picked == nil; n++ {
+		picked = byAddr[c.ring[(i+n)%len(c.ring)].addr]
+	}
+	c.mutex.Unlock()
+	if picked == nil {
+		// none of the backends is on the ring
+		return c.fallback.Pick(info, backends)
+	}
+	return picked, nil
+}
+
+// build rebuilds the ring if the set of addresses or their weights changed
+func (c *consistentHash) build(addrs []resolver.Address) {
+	keys := make([]string, 0, len(addrs))
+	total := 0
+	for _, a := range addrs {
+		keys = append(keys, a.Addr+"/"+strconv.Itoa(a.Weight))
+		total += replicas * addrWeight(a)
+	}
+	sort.Strings(keys)
+	if equal(keys, c.keys) {
+		return
+	}
+	c.keys = keys
+	c.ring = c.ring[:0]
+	for _, a := range addrs {
+		points := replicas * addrWeight(a)
+		if total > maxRing {
+			points = int(float64(points) * maxRing / float64(total))
+			if points == 0 {
+				points = 1
+			}
+		}
+		for i := 0; i < points; i++ {
+			c.ring = append(c.ring, point{crc32.ChecksumIEEE([]byte(a.Addr + "#" + strconv.Itoa(i))), a.Addr})
+		}
+	}
+	sort.Slice(c.ring, func(i, j int) bool { return c.ring[i].hash < c.ring[j].hash })
+}
+
+func equal(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
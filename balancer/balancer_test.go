@@ -0,0 +1,172 @@
+package balancer
+
+import (
+	"strconv"
+	"testing"
+
+	"github.com/mizumoto-cn/TRPcG/metadata"
+	"github.com/mizumoto-cn/TRPcG/resolver"
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeBackend struct {
+	addr     string
+	weight   int
+	inflight int
+}
+
+func (f *fakeBackend) Address() resolver.Address {
+	return resolver.Address{Addr: f.addr, Weight: f.weight}
+}
+
+func (f *fakeBackend) InFlight() int {
+	return f.inflight
+}
+
+func backends(weights ...int) []Backend {
+	var bs []Backend
+	for i, w := range weights {
+		bs = append(bs, &fakeBackend{addr: "10.0.0." + strconv.Itoa(i+1) + ":8082", weight: w})
+	}
+	return bs
+}
+
+// picks returns the addresses of n picks of b
+func picks(t *testing.T, b Balancer, info PickInfo, bs []Backend, n int) []string {
+	var addrs []string
+	for i := 0; i < n; i++ {
+		picked, err := b.Pick(info, bs)
+		assert.Equal(t, nil, err)
+		addrs = append(addrs, picked.Address().Addr)
+	}
+	return addrs
+}
+
+func TestBalancers_NoBackend(t *testing.T) {
+	for _, b := range []Balancer{RoundRobin(), WeightedRoundRobin(), Random(), P2C(), ConsistentHash("user")} {
+		_, err := b.Pick(PickInfo{Metadata: metadata.Pairs("user", "alice")}, nil)
+		assert.Equal(t, ErrNoBackend, err)
+	}
+}
+
+func TestRoundRobin(t *testing.T) {
+	bs := backends(1, 1, 1)
+	assert.Equal(t, []string{"10.0.0.1:8082", "10.0.0.2:8082", "10.0.0.3:8082", "10.0.0.1:8082"},
+		picks(t, RoundRobin(), PickInfo{}, bs, 4))
+}
+
+func TestWeightedRoundRobin(t *testing.T) {
+	bs := backends(5, 1, 1)
+	a, b, c := "10.0.0.1:8082", "10.0.0.2:8082", "10.0.0.3:8082"
+	// the picks of the heavy backend are interleaved with the others
+	assert.Equal(t, []string{a, a, b, a, c, a, a, a, a, b, a, c, a, a},
+		picks(t, WeightedRoundRobin(), PickInfo{}, bs, 14))
+}
+
+func TestRandom(t *testing.T) {
+	bs := backends(1, 1, 1)
+	counts := make(map[string]int)
+	for _, addr := range picks(t, Random(), PickInfo{}, bs, 300) {
+		counts[addr]++
+	}
+	assert.Equal(t, 3, len(counts))
+}
+
+func TestP2C(t *testing.T) {
+	bs := backends(1, 1)
+	bs[0].(*fakeBackend).inflight = 10
+	// of two backends, the one with fewer calls in flight always wins
+	for _, addr := range picks(t, P2C(), PickInfo{}, bs, 20) {
+		assert.Equal(t, "10.0.0.2:8082", addr)
+	}
+
+	bs = backends(1, 1, 1)
+	bs[0].(*fakeBackend).inflight = 10
+	bs[1].(*fakeBackend).inflight = 10
+	counts := make(map[string]int)
+	for _, addr := range picks(t, P2C(), PickInfo{}, bs, 300) {
+		counts[addr]++
+	}
+	// the idle backend wins every pick it takes part in, two thirds of them
+	assert.Greater(t, counts["10.0.0.3:8082"], 150)
+}
+
+func TestConsistentHash(t *testing.T) {
+	b := ConsistentHash("user")
+	bs := backends(1, 1, 1, 1)
+	owners := make(map[string]string)
+	for i := 0; i < 100; i++ {
+		user := "user" + strconv.Itoa(i)
+		info := PickInfo{Metadata: metadata.Pairs("user", user)}
+		addrs := picks(t, b, info, bs, 3)
+		// the calls of a user stick to a backend
+		assert.Equal(t, []string{addrs[0], addrs[0], addrs[0]}, addrs)
+		owners[user] = addrs[0]
+	}
+	assert.Equal(t, 4, len(distinct(owners)))
+
+	// removing a backend only moves the users it owned
+	removed := bs[1].Address().Addr
+	bs = append(bs[:1:1], bs[2:]...)
+	for user, owner := range owners {
+		addr := picks(t, b, PickInfo{Metadata: metadata.Pairs("user", user)}, bs, 1)[0]
+		if owner == removed {
+			assert.NotEqual(t, removed, addr)
+		} else {
+			assert.Equal(t, owner, addr)
+		}
+	}
+
+	// calls without the key are spread at random
+	counts := make(map[string]int)
+	for _, addr := range picks(t, b, PickInfo{}, bs, 300) {
+		counts[addr]++
+	}
+	assert.Equal(t, 3, len(counts))
+}
+
+func TestConsistentHash_Update(t *testing.T) {
+	b := ConsistentHash("user")
+	bs := backends(1, 1, 1, 1)
+	addrs := make([]resolver.Address, 0, len(bs))
+	for _, backend := range bs {
+		addrs = append(addrs, backend.Address())
+	}
+	b.(Updater).Update(addrs)
+	owners := make(map[string]string)
+	for i := 0; i < 100; i++ {
+		user := "user" + strconv.Itoa(i)
+		owners[user] = picks(t, b, PickInfo{Metadata: metadata.Pairs("user", user)}, bs, 1)[0]
+	}
+
+	// the users of an unavailable backend go to the next one on the ring, and come back
+	unavailable := bs[1].Address().Addr
+	available := append(bs[:1:1], bs[2:]...)
+	for user, owner := range owners {
+		addr := picks(t, b, PickInfo{Metadata: metadata.Pairs("user", user)}, available, 1)[0]
+		if owner == unavailable {
+			assert.NotEqual(t, unavailable, addr)
+		} else {
+			assert.Equal(t, owner, addr)
+		}
+	}
+	for user, owner := range owners {
+		assert.Equal(t, owner, picks(t, b, PickInfo{Metadata: metadata.Pairs("user", user)}, bs, 1)[0])
+	}
+
+	// heavy weights are scaled down
+	b.(Updater).Update([]resolver.Address{{Addr: "10.0.0.1:8082", Weight: 1 << 30}, {Addr: "10.0.0.2:8082"}})
+	points := make(map[string]int)
+	for _, p := range b.(*consistentHash).ring {
+		points[p.addr]++
+	}
+	assert.Equal(t, map[string]int{"10.0.0.1:8082": maxRing - 1, "10.0.0.2:8082": 1}, points)
+}
+
+func distinct(m map[string]string) map[string]bool {
+	set := make(map[string]bool)
+	for _, v := range m {
+		set[v] = true
+	}
+	return set
+}
@@ -0,0 +1,248 @@
+package breaker
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/mizumoto-cn/TRPcG/status"
+)
+
+// ErrOpen is returned without making the call while the circuit breaker is open
+var ErrOpen = errors.New("circuit breaker is open")
+
+// State of a circuit breaker
+type State int
+
+const (
+	Closed   State = iota // calls go through, failures are counted
+	Open                  // calls fail fast with ErrOpen until the cool-down is over
+	HalfOpen              // a few trial calls decide whether to close or open again
+)
+
+func (s State) String() string {
+	switch s {
+	case Closed:
+		return "closed"
+	case Open:
+		return "open"
+	case HalfOpen:
+		return "half-open"
+	}
+	return fmt.Sprintf("State(%d)", int(s))
+}
+
+// Config of the circuit breakers of a Group
+type Config struct {
+	// The breaker opens once FailureRatio of at least MinRequests calls failed
+	// within Window, or after ConsecutiveFailures failures in a row.
+	// A zero FailureRatio or ConsecutiveFailures disables the respective threshold.
+	FailureRatio        float64
+	MinRequests         int
+	ConsecutiveFailures int
+	Window              time.Duration // counts of the closed state are reset every Window, never if 0
+
+	CoolDown         time.Duration // time spent open before going half-open
+	HalfOpenRequests int           // successful trial calls needed to close again, 1 if not set
+
+	PerMethod bool // keep a breaker per target and method instead of per target
+
+	// IsFailure reports whether the error of a call counts as a failure. By default
+	// Unavailable, DeadlineExceeded, Internal and DataLoss do, errors of the service
+	// itself do not.
+	IsFailure func(err error) bool
+
+	// OnStateChange is called on every state transition of the breaker of key
+	OnStateChange func(key string, from, to State)
+}
+
+// DefaultIsFailure is the default of Config.IsFailure
+func DefaultIsFailure(err error) bool {
+	switch status.CodeOf(err) {
+	case status.Unavailable, status.DeadlineExceeded, status.Internal, status.DataLoss:
+		return true
+	}
+	return false
+}
+
+// Breaker is a circuit breaker
+type Breaker struct {
+	mutex  sync.Mutex
+	key    string
+	config *Config
+	now    func() time.Time
+
+	state       State
+	generation  int // incremented on every transition
+	windowStart time.Time
+	openedAt    time.Time
+	requests    int
+	failures    int
+	consecutive int
+	trials      int        // calls allowed in the half-open state
+	successes   int        // of the trial calls
+	transitions [][2]State // to report once the mutex is released
+}
+
+// State returns the current state of the breaker
+func (b *Breaker) State() State {
+	b.mutex.Lock()
+	defer b.unlock()
+	b.advance(b.now())
+	return b.state
+}
+
+// Allow asks the breaker for permission to make a call. It returns ErrOpen if
+// the call must not be made, otherwise done must be called with the result of the call.
+func (b *Breaker) Allow() (done func(err error), err error) {
+	b.mutex.Lock()
+	defer b.unlock()
+	now := b.now()
+	b.advance(now)
+	switch b.state {
+	case Open:
+		return nil, ErrOpen
+	case HalfOpen:
+		if b.trials >= b.halfOpenRequests() {
+			return nil, ErrOpen
+		}
+		b.trials++
+	}
+	generation := b.generation
+	return func(err error) { b.done(generation, err) }, nil
+}
+
+func (b *Breaker) done(generation int, err error) {
+	b.mutex.Lock()
+	defer b.unlock()
+	now := b.now()
+	b.advance(now)
+	// the outcome of a call started in an earlier state says nothing about the current one
+	if b.generation != generation {
+		return
+	}
+	isFailure := b.config.IsFailure
+	if isFailure == nil {
+		isFailure = DefaultIsFailure
+	}
+	failed := err != nil && isFailure(err)
+
+	switch b.state {
+	case Closed:
+		b.requests++
+		if failed {
+			b.failures++
+			b.consecutive++
+		} else {
+			b.consecutive = 0
+		}
+		if b.tripped() {
+			b.setState(Open, now)
+		}
+	case HalfOpen:
+		if failed {
+			b.setState(Open, now)
+			return
+		}
+		b.successes++
+		if b.successes >= b.halfOpenRequests() {
+			b.setState(Closed, now)
+		}
+	}
+}
+
+func (b *Breaker) tripped() bool {
+	c := b.config
+	if c.ConsecutiveFailures > 0 && b.consecutive >= c.ConsecutiveFailures {
+		return true
+	}
+	return c.FailureRatio > 0 && b.requests >= c.MinRequests &&
+		float64(b.failures) >= c.FailureRatio*float64(b.requests)
+}
+
+// advance makes the time based transitions
+func (b *Breaker) advance(now time.Time) {
+	switch b.state {
+	case Closed:
+		if b.config.Window > 0 && now.Sub(b.windowStart) >= b.config.Window {
+			b.resetCounts(now)
+		}
+	case Open:
+		if now.Sub(b.openedAt) >= b.config.CoolDown {
+			b.setState(HalfOpen, now)
+		}
+	}
+}
+
+func (b *Breaker) setState(state State, now time.Time) {
+	from := b.state
+	b.state = state
+	b.generation++
+	b.resetCounts(now)
+	if state == Open {
+		b.openedAt = now
+	}
+	b.transitions = append(b.transitions, [2]State{from, state})
+}
+
+// unlock releases the mutex and reports the transitions, so that
+// OnStateChange is free to use the breaker.
+func (b *Breaker) unlock() {
+	transitions := b.transitions
+	b.transitions = nil
+	b.mutex.Unlock()
+	if b.config.OnStateChange == nil {
+		return
+	}
+	for _, t := range transitions {
+		b.config.OnStateChange(b.key, t[0], t[1])
+	}
+}
+
+func (b *Breaker) resetCounts(now time.Time) {
+	b.windowStart = now
+	b.requests, b.failures, b.consecutive = 0, 0, 0
+	b.trials, b.successes = 0, 0
+}
+
+func (b *Breaker) halfOpenRequests() int {
+	if b.config.HalfOpenRequests <= 0 {
+		return 1
+	}
+	return b.config.HalfOpenRequests
+}
+
+// Group holds the breakers of the targets, and of their methods if Config.PerMethod is set.
+// A Group can be shared by the clients of one target.
+type Group struct {
+	mutex    sync.Mutex
+	config   Config
+	breakers map[string]*Breaker
+	now      func() time.Time
+}
+
+// NewGroup returns a Group creating its breakers with config
+func NewGroup(config Config) *Group {
+	return &Group{
+		config:   config,
+		breakers: make(map[string]*Breaker),
+		now:      time.Now,
+	}
+}
+
+// Get returns the breaker of a call of serviceMethod to target
+func (g *Group) Get(target, serviceMethod string) *Breaker {
+	key := target
+	if g.config.PerMethod {
+		key += "/" + serviceMethod
+	}
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	b, ok := g.breakers[key]
+	if !ok {
+		b = &Breaker{key: key, config: &g.config, now: g.now, windowStart: g.now()}
+		g.breakers[key] = b
+	}
+	return b
+}
@@ -0,0 +1,98 @@
+package breaker
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/mizumoto-cn/TRPcG/status"
+	"github.com/stretchr/testify/assert"
+)
+
+var errDown = status.Error(status.Unavailable, "down")
+
+// TestBreaker_ConsecutiveFailures tests the transitions of a breaker
+// tripped by consecutive failures
+func TestBreaker_ConsecutiveFailures(t *testing.T) {
+	now := time.Unix(0, 0)
+	var transitions []string
+	g := NewGroup(Config{
+		ConsecutiveFailures: 2,
+		CoolDown:            time.Second,
+		OnStateChange: func(key string, from, to State) {
+			transitions = append(transitions, key+": "+from.String()+" -> "+to.String())
+		},
+	})
+	g.now = func() time.Time { return now }
+	b := g.Get("backend", "Arith.Add")
+
+	call := func(err error) error {
+		done, e := b.Allow()
+		if e != nil {
+			return e
+		}
+		done(err)
+		return nil
+	}
+
+	assert.Equal(t, nil, call(errDown))
+	assert.Equal(t, nil, call(nil))
+	// errors of the service do not count
+	assert.Equal(t, nil, call(errors.New("divided by zero")))
+	assert.Equal(t, nil, call(errDown))
+	assert.Equal(t, nil, call(errDown))
+	assert.Equal(t, Open, b.State())
+	assert.Equal(t, ErrOpen, call(nil))
+
+	now = now.Add(time.Second)
+	assert.Equal(t, HalfOpen, b.State())
+	done, err := b.Allow()
+	assert.Equal(t, nil, err)
+	// a single trial call at a time
+	_, err = b.Allow()
+	assert.Equal(t, ErrOpen, err)
+	done(errDown)
+	assert.Equal(t, Open, b.State())
+
+	now = now.Add(time.Second)
+	assert.Equal(t, nil, call(nil))
+	assert.Equal(t, Closed, b.State())
+
+	assert.Equal(t, []string{
+		"backend: closed -> open",
+		"backend: open -> half-open",
+		"backend: half-open -> open",
+		"backend: open -> half-open",
+		"backend: half-open -> closed",
+	}, transitions)
+}
+
+// TestBreaker_FailureRatio tests a breaker tripped by its failure ratio
+func TestBreaker_FailureRatio(t *testing.T) {
+	now := time.Unix(0, 0)
+	g := NewGroup(Config{
+		FailureRatio: 0.5,
+		MinRequests:  4,
+		Window:       time.Minute,
+		CoolDown:     time.Second,
+		PerMethod:    true,
+	})
+	g.now = func() time.Time { return now }
+	b := g.Get("backend", "Arith.Add")
+
+	for _, err := range []error{errDown, nil, errDown} {
+		done, _ := b.Allow()
+		done(err)
+	}
+	assert.Equal(t, Closed, b.State())
+	// other methods have breakers of their own
+	assert.Equal(t, false, b == g.Get("backend", "Arith.Sub"))
+
+	// a new window starts over
+	now = now.Add(time.Minute)
+	for _, err := range []error{errDown, nil, nil, errDown} {
+		done, _ := b.Allow()
+		done(err)
+	}
+	assert.Equal(t, Open, b.State())
+}
@@ -0,0 +1,268 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"text/tabwriter"
+	"time"
+
+	"github.com/mizumoto-cn/TRPcG"
+	"github.com/mizumoto-cn/TRPcG/metadata"
+	"github.com/mizumoto-cn/TRPcG/status"
+)
+
+// benchResult is the result of a benchmark run with a compressor
+type benchResult struct {
+	Compressor string         `json:"compressor"`
+	Duration   float64        `json:"duration_s"`
+	Requests   int            `json:"requests"`
+	Errors     map[string]int `json:"errors,omitempty"` // by status code
+	Throughput float64        `json:"throughput"`       // calls per second
+	Latency    latency        `json:"latency_ms"`
+	BytesSent  int64          `json:"bytes_sent"`
+	BytesRecv  int64          `json:"bytes_received"`
+	BytesCall  float64        `json:"bytes_per_call"` // sent and received
+}
+
+type latency struct {
+	Min  float64 `json:"min"`
+	Mean float64 `json:"mean"`
+	P50  float64 `json:"p50"`
+	P90  float64 `json:"p90"`
+	P99  float64 `json:"p99"`
+	Max  float64 `json:"max"`
+}
+
+// countingConn counts the bytes on the wire
+type countingConn struct {
+	net.Conn
+	sent, received int64
+}
+
+func (c *countingConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	atomic.AddInt64(&c.received, int64(n))
+	return n, err
+}
+
+func (c *countingConn) Write(b []byte) (int, error) {
+	n, err := c.Conn.Write(b)
+	atomic.AddInt64(&c.sent, int64(n))
+	return n, err
+}
+
+// bench drives a method at a fixed rate or concurrency, once per compressor
+func (c *cli) bench(args []string) error {
+	flags := flag.NewFlagSet("bench", flag.ContinueOnError)
+	flags.SetOutput(c.stderr)
+	qps := flags.Float64("qps", 0, "calls per second, as fast as the workers go if not set")
+	concurrency := flags.Int("c", 10, "workers, each making one call at a time")
+	duration := flags.Duration("d", 10*time.Second, "of each run")
+	names := flags.String("compressors", c.compress, "comma separated compressors, one run each")
+	output := flags.String("json", "", "write the results as JSON to this file, - for the standard output")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	args = flags.Args()
+	if len(args) < 1 || len(args) > 2 || *concurrency < 1 {
+		return fmt.Errorf("usage: trpcg [flags] <address> bench [-qps n] [-c n] [-d duration] " +
+			"[-compressors raw,gzip,snappy,zlib] [-json file] <Service.Method> [<JSON request> | -]")
+	}
+	input, err := c.input(args[1:])
+	if err != nil {
+		return err
+	}
+	request, newReply, err := c.request(args[0], input)
+	if err != nil {
+		return err
+	}
+
+	var results []*benchResult
+	for _, name := range strings.Split(*names, ",") {
+		compressType, ok := compressors[name]
+		if !ok {
+			return fmt.Errorf("unknown compressor %q", name)
+		}
+		conn, err := net.DialTimeout("tcp", c.addr, c.timeout)
+		if err != nil {
+			return err
+		}
+		counter := &countingConn{Conn: conn}
+		client := TRPcG.NewClient(counter, TRPcG.WithCompress(compressType), TRPcG.WithSerializer(c.serializer))
+		result := c.run(client, args[0], request, newReply, *qps, *concurrency, *duration)
+		client.Close()
+		result.Compressor = name
+		result.BytesSent, result.BytesRecv = atomic.LoadInt64(&counter.sent), atomic.LoadInt64(&counter.received)
+		if result.Requests > 0 {
+			result.BytesCall = float64(result.BytesSent+result.BytesRecv) / float64(result.Requests)
+		}
+		results = append(results, result)
+	}
+
+	switch *output {
+	case "":
+		report(c.stdout, results)
+		return nil
+	case "-":
+		return writeJSON(c.stdout, results)
+	default:
+		report(c.stdout, results)
+		f, err := os.Create(*output)
+		if err != nil {
+			return err
+		}
+		if err = writeJSON(f, results); err != nil {
+			f.Close()
+			return err
+		}
+		return f.Close()
+	}
+}
+
+// run makes calls for duration with concurrency workers, paced at qps if set
+func (c *cli) run(client *TRPcG.Client, serviceMethod string, request any, newReply func() any,
+	qps float64, concurrency int, duration time.Duration) *benchResult {
+
+	ctx, cancel := context.WithTimeout(context.Background(), duration)
+	defer cancel()
+	ctx = metadata.NewOutgoingContext(ctx, c.md)
+
+	// with a rate, the workers wait for a token before each call, which holds the time
+	// the call is scheduled at: latencies are measured from it, so that the calls
+	// queued behind slow ones are not reported faster than they were (coordinated omission)
+	var tokens chan time.Time
+	if qps > 0 {
+		tokens = make(chan time.Time)
+		go func() {
+			interval := time.Duration(float64(time.Second) / qps)
+			start := time.Now()
+			for i := 0; ; i++ {
+				scheduled := start.Add(time.Duration(i) * interval)
+				select {
+				case <-time.After(time.Until(scheduled)):
+				case <-ctx.Done():
+					return
+				}
+				select {
+				case tokens <- scheduled:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}()
+	}
+
+	var (
+		mutex     sync.Mutex
+		latencies []time.Duration
+		errors    = make(map[string]int)
+		wg        sync.WaitGroup
+	)
+	start := time.Now()
+	for w := 0; w < concurrency; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			var mine []time.Duration
+			defer func() {
+				mutex.Lock()
+				latencies = append(latencies, mine...)
+				mutex.Unlock()
+			}()
+			for {
+				begin := time.Now()
+				if tokens != nil {
+					select {
+					case begin = <-tokens:
+					case <-ctx.Done():
+						return
+					}
+				}
+				if ctx.Err() != nil {
+					return
+				}
+				callCtx, cancel := context.WithTimeout(ctx, c.timeout)
+				err := client.CallContext(callCtx, serviceMethod, request, newReply())
+				elapsed := time.Since(begin)
+				cancel()
+				if err != nil && ctx.Err() != nil {
+					continue // cut by the end of the run
+				}
+				mine = append(mine, elapsed)
+				if err != nil {
+					mutex.Lock()
+					errors[status.CodeOf(err).String()]++
+					mutex.Unlock()
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	elapsed := time.Since(start)
+
+	result := &benchResult{Duration: elapsed.Seconds(), Requests: len(latencies), Errors: errors}
+	result.Throughput = float64(len(latencies)) / elapsed.Seconds()
+	result.Latency = percentiles(latencies)
+	return result
+}
+
+func percentiles(latencies []time.Duration) latency {
+	if len(latencies) == 0 {
+		return latency{}
+	}
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+	ms := func(d time.Duration) float64 { return float64(d) / float64(time.Millisecond) }
+	at := func(p float64) float64 { return ms(latencies[int(p*float64(len(latencies)-1))]) }
+	var total time.Duration
+	for _, l := range latencies {
+		total += l
+	}
+	return latency{
+		Min:  ms(latencies[0]),
+		Mean: ms(total / time.Duration(len(latencies))),
+		P50:  at(0.5),
+		P90:  at(0.9),
+		P99:  at(0.99),
+		Max:  ms(latencies[len(latencies)-1]),
+	}
+}
+
+// report prints the results as a table
+func report(w io.Writer, results []*benchResult) {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "compressor\trequests\terrors\tcalls/s\tp50 ms\tp90 ms\tp99 ms\tmax ms\tsent\treceived\tbytes/call")
+	for _, r := range results {
+		errors := 0
+		for _, n := range r.Errors {
+			errors += n
+		}
+		fmt.Fprintf(tw, "%s\t%d\t%d\t%.1f\t%.3f\t%.3f\t%.3f\t%.3f\t%d\t%d\t%.1f\n", r.Compressor, r.Requests, errors,
+			r.Throughput, r.Latency.P50, r.Latency.P90, r.Latency.P99, r.Latency.Max, r.BytesSent, r.BytesRecv, r.BytesCall)
+	}
+	tw.Flush()
+	for _, r := range results {
+		codes := make([]string, 0, len(r.Errors))
+		for code := range r.Errors {
+			codes = append(codes, code)
+		}
+		sort.Strings(codes)
+		for _, code := range codes {
+			fmt.Fprintf(w, "%s: %d %s\n", r.Compressor, r.Errors[code], code)
+		}
+	}
+}
+
+func writeJSON(w io.Writer, results []*benchResult) error {
+	e := json.NewEncoder(w)
+	e.SetIndent("", "  ")
+	return e.Encode(results)
+}
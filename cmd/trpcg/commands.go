@@ -0,0 +1,237 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/mizumoto-cn/TRPcG/reflection"
+	"github.com/mizumoto-cn/TRPcG/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// services returns the services of the server
+func (c *cli) services() ([]*reflection.ServiceInfo, error) {
+	ctx, cancel := c.context()
+	defer cancel()
+	services, err := reflection.ListServices(ctx, c)
+	return services, plain(err)
+}
+
+// plain returns the message of the status carried by err, as the errors
+// of the reflection service are printed without their code
+func plain(err error) error {
+	if s, ok := status.FromError(err); ok && s != nil {
+		return errors.New(s.Message())
+	}
+	return err
+}
+
+// list prints the services of the server, or the methods of a service
+func (c *cli) list(args []string) error {
+	services, err := c.services()
+	if err != nil {
+		return err
+	}
+	for _, s := range services {
+		if len(args) == 0 {
+			fmt.Fprintln(c.stdout, s.Name)
+			continue
+		}
+		if s.Name == args[0] {
+			for _, m := range s.Methods {
+				fmt.Fprintln(c.stdout, s.Name+"."+m.Name)
+			}
+			return nil
+		}
+	}
+	if len(args) > 0 {
+		return fmt.Errorf("unknown service %s", args[0])
+	}
+	return nil
+}
+
+// describe prints a service, a method or a protobuf message
+func (c *cli) describe(symbol string) error {
+	services, err := c.services()
+	if err != nil {
+		return err
+	}
+	for _, s := range services {
+		if s.Name == symbol {
+			fmt.Fprintf(c.stdout, "service %s {\n", s.Name)
+			for _, m := range s.Methods {
+				fmt.Fprintf(c.stdout, "  %s\n", signature(m))
+			}
+			fmt.Fprintln(c.stdout, "}")
+			return nil
+		}
+		for _, m := range s.Methods {
+			if s.Name+"."+m.Name != symbol {
+				continue
+			}
+			fmt.Fprintln(c.stdout, signature(m))
+			for _, name := range []string{m.ArgsProto, m.ReplyProto} {
+				if name == "" {
+					continue
+				}
+				fmt.Fprintln(c.stdout)
+				if err := c.describeMessage(name); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+	}
+	return c.describeMessage(symbol)
+}
+
+// signature of a method, with the protobuf names of its types when known
+func signature(m *reflection.MethodInfo) string {
+	args, reply := m.ArgsProto, m.ReplyProto
+	if args == "" {
+		args = m.ArgsType
+	}
+	if reply == "" {
+		reply = m.ReplyType
+	}
+	return fmt.Sprintf("rpc %s(%s) returns (%s);", m.Name, args, reply)
+}
+
+func (c *cli) describeMessage(name string) error {
+	ctx, cancel := c.context()
+	defer cancel()
+	d, err := c.types.Descriptor(ctx, name)
+	if err != nil {
+		return plain(err)
+	}
+	switch d := d.(type) {
+	case protoreflect.MessageDescriptor:
+		printMessage(c.stdout, d)
+	case protoreflect.EnumDescriptor:
+		printEnum(c.stdout, d)
+	default:
+		return fmt.Errorf("%s is not a message", name)
+	}
+	return nil
+}
+
+func printMessage(w io.Writer, d protoreflect.MessageDescriptor) {
+	fmt.Fprintf(w, "message %s {\n", d.FullName())
+	fields := d.Fields()
+	for i := 0; i < fields.Len(); i++ {
+		f := fields.Get(i)
+		fmt.Fprintf(w, "  %s %s = %d;\n", fieldType(f), f.Name(), f.Number())
+	}
+	fmt.Fprintln(w, "}")
+}
+
+func printEnum(w io.Writer, d protoreflect.EnumDescriptor) {
+	fmt.Fprintf(w, "enum %s {\n", d.FullName())
+	values := d.Values()
+	for i := 0; i < values.Len(); i++ {
+		fmt.Fprintf(w, "  %s = %d;\n", values.Get(i).Name(), values.Get(i).Number())
+	}
+	fmt.Fprintln(w, "}")
+}
+
+func fieldType(f protoreflect.FieldDescriptor) string {
+	if f.IsMap() {
+		return fmt.Sprintf("map<%s, %s>", kindName(f.MapKey()), kindName(f.MapValue()))
+	}
+	if f.IsList() {
+		return "repeated " + kindName(f)
+	}
+	return kindName(f)
+}
+
+func kindName(f protoreflect.FieldDescriptor) string {
+	switch f.Kind() {
+	case protoreflect.MessageKind, protoreflect.GroupKind:
+		return string(f.Message().FullName())
+	case protoreflect.EnumKind:
+		return string(f.Enum().FullName())
+	default:
+		return f.Kind().String()
+	}
+}
+
+// call calls a method with a JSON request and prints the JSON reply
+func (c *cli) call(args []string) error {
+	input, err := c.input(args[1:])
+	if err != nil {
+		return err
+	}
+	request, newReply, err := c.request(args[0], input)
+	if err != nil {
+		return err
+	}
+	reply := newReply()
+	if err = c.invoke(args[0], request, reply); err != nil {
+		return err
+	}
+	return c.print(reply)
+}
+
+// input returns the JSON request given on the command line, "-" reading it
+// from the standard input, "{}" if not given
+func (c *cli) input(args []string) ([]byte, error) {
+	if len(args) == 0 {
+		return []byte("{}"), nil
+	}
+	if args[0] == "-" {
+		return io.ReadAll(c.stdin)
+	}
+	return []byte(args[0]), nil
+}
+
+// request returns the arguments of serviceMethod decoded from the JSON input,
+// and a function returning new replies of serviceMethod
+func (c *cli) request(serviceMethod string, input []byte) (any, func() any, error) {
+	if !c.proto {
+		// the JSON serializer passes raw JSON through
+		if !json.Valid(input) {
+			return nil, nil, fmt.Errorf("invalid JSON request")
+		}
+		return json.RawMessage(input), func() any { return new(json.RawMessage) }, nil
+	}
+	ctx, cancel := c.context()
+	defer cancel()
+	args, reply, err := c.types.Method(ctx, serviceMethod)
+	if status.CodeOf(err) == status.Unimplemented {
+		return nil, nil, fmt.Errorf("%v, try -serializer json", plain(err))
+	}
+	if err != nil {
+		return nil, nil, plain(err)
+	}
+	request := args.New().Interface()
+	if err = protojson.Unmarshal(input, request); err != nil {
+		return nil, nil, fmt.Errorf("invalid %s request: %w", args.Descriptor().FullName(), err)
+	}
+	return request, func() any { return reply.New().Interface() }, nil
+}
+
+// print prints a reply as indented JSON
+func (c *cli) print(reply any) error {
+	var data []byte
+	if raw, ok := reply.(*json.RawMessage); ok {
+		data = *raw
+	} else {
+		var err error
+		// protojson randomizes its spacing, Indent lays it out again
+		data, err = protojson.MarshalOptions{EmitUnpopulated: true}.Marshal(reply.(proto.Message))
+		if err != nil {
+			return err
+		}
+	}
+	var out bytes.Buffer
+	if err := json.Indent(&out, data, "", "  "); err != nil {
+		return err
+	}
+	fmt.Fprintln(c.stdout, out.String())
+	return nil
+}
@@ -0,0 +1,196 @@
+// trpcg calls the services of a TRPcG server from the command line, describing
+// them with its reflection service (see TRPcG.WithReflection):
+//
+//	trpcg [flags] <address> list [<Service>]
+//	trpcg [flags] <address> describe <Service | Service.Method | proto.Message>
+//	trpcg [flags] <address> call <Service.Method> [<JSON request> | -]
+//	trpcg [flags] <address> bench [bench flags] <Service.Method> [<JSON request> | -]
+//	trpcg [flags] <address> proxy [-listen address] [-record file] [-q]
+//	trpcg [flags] <address> replay [-speed n] [-q] <file>
+//
+// Requests and replies are JSON, converted from and to protobuf with the file
+// descriptors of the server unless -serializer json is given. "-" reads the
+// request from the standard input. bench drives a method at a fixed rate or
+// concurrency, and reports the throughput, the latency, the errors and the
+// bytes on the wire of each compressor given by -compressors. proxy forwards
+// the connections it accepts to the server unchanged, printing every request
+// and response decoded on the way, and recording them to a file with -record.
+// replay sends the requests of such a file to the server again, at the recorded
+// pace scaled by -speed, and prints how the responses differ from the recorded ones.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"net/rpc"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/mizumoto-cn/TRPcG"
+	"github.com/mizumoto-cn/TRPcG/codec"
+	"github.com/mizumoto-cn/TRPcG/compressor"
+	"github.com/mizumoto-cn/TRPcG/metadata"
+	"github.com/mizumoto-cn/TRPcG/reflection"
+	"github.com/mizumoto-cn/TRPcG/serializer"
+)
+
+var errUsage = errors.New(`usage: trpcg [flags] <address> list [<Service>]
+       trpcg [flags] <address> describe <Service | Service.Method | proto.Message>
+       trpcg [flags] <address> call <Service.Method> [<JSON request> | -]
+       trpcg [flags] <address> bench [bench flags] <Service.Method> [<JSON request> | -]
+       trpcg [flags] <address> proxy [-listen address] [-record file] [-q]
+       trpcg [flags] <address> replay [-speed n] [-q] <file>`)
+
+var compressors = map[string]compressor.CompressType{
+	"raw":    compressor.Raw,
+	"gzip":   compressor.Gzip,
+	"snappy": compressor.Snappy,
+	"zlib":   compressor.Zlib,
+}
+
+var serializers = map[string]serializer.Serializer{
+	"proto": serializer.Proto,
+	"json":  serializer.Json,
+}
+
+// headers collects the -H flags
+type headers []string
+
+func (h *headers) String() string {
+	return strings.Join(*h, ",")
+}
+
+func (h *headers) Set(v string) error {
+	if !strings.Contains(v, "=") {
+		return fmt.Errorf("header %q is not key=value", v)
+	}
+	*h = append(*h, v)
+	return nil
+}
+
+func main() {
+	if err := run(os.Args[1:], os.Stdin, os.Stdout, os.Stderr); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+// cli holds the connection and the settings of a command
+type cli struct {
+	addr       string
+	compress   string // name of the compressor
+	serializer serializer.Serializer
+	client     *TRPcG.Client
+	proto      bool // whether requests and replies are protobuf messages
+	timeout    time.Duration
+	md         metadata.MD
+	verbose    bool
+	stdin      io.Reader
+	stdout     io.Writer
+	stderr     io.Writer
+	types      *reflection.Types
+}
+
+func run(args []string, stdin io.Reader, stdout, stderr io.Writer) error {
+	flags := flag.NewFlagSet("trpcg", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	compress := flags.String("compress", "raw", "compressor of the requests: raw, gzip, snappy or zlib")
+	serial := flags.String("serializer", "proto", "serializer of the server: proto or json")
+	timeout := flags.Duration("timeout", 10*time.Second, "of the connection and of each call")
+	verbose := flags.Bool("v", false, "print the response metadata on the standard error")
+	var h headers
+	flags.Var(&h, "H", "request metadata as key=value, may be repeated")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	args = flags.Args()
+	if len(args) < 2 {
+		return errUsage
+	}
+	compressType, ok := compressors[*compress]
+	if !ok {
+		return fmt.Errorf("unknown compressor %q", *compress)
+	}
+	s, ok := serializers[*serial]
+	if !ok {
+		return fmt.Errorf("unknown serializer %q", *serial)
+	}
+	md := metadata.New(nil)
+	for _, kv := range h {
+		i := strings.Index(kv, "=")
+		md.Set(kv[:i], kv[i+1:])
+	}
+
+	conn, err := net.DialTimeout("tcp", args[0], *timeout)
+	if err != nil {
+		return err
+	}
+	c := &cli{
+		addr:       args[0],
+		compress:   *compress,
+		serializer: s,
+		client:     TRPcG.NewClient(conn, TRPcG.WithCompress(compressType), TRPcG.WithSerializer(s)),
+		proto:      *serial == "proto",
+		timeout:    *timeout,
+		md:         md,
+		verbose:    *verbose,
+		stdin:      stdin,
+		stdout:     stdout,
+		stderr:     stderr,
+	}
+	defer c.client.Close()
+	c.types = reflection.NewTypes(c)
+
+	switch command, rest := args[1], args[2:]; {
+	case command == "list" && len(rest) <= 1:
+		return c.list(rest)
+	case command == "describe" && len(rest) == 1:
+		return c.describe(rest[0])
+	case command == "call" && (len(rest) == 1 || len(rest) == 2):
+		return c.call(rest)
+	case command == "bench":
+		return c.bench(rest)
+	case command == "proxy":
+		return c.proxy(rest)
+	case command == "replay":
+		return c.replay(rest)
+	default:
+		return errUsage
+	}
+}
+
+// context returns the context of a call, carrying the metadata and the timeout of the command
+func (c *cli) context() (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithTimeout(context.Background(), c.timeout)
+	return metadata.NewOutgoingContext(ctx, c.md), cancel
+}
+
+// AsyncCall sends a call with the metadata of the command, for the reflection lookups
+func (c *cli) AsyncCall(serviceMethod string, args any, reply any) chan *rpc.Call {
+	return c.client.AsyncCall(serviceMethod, &codec.Envelope{Args: args, Metadata: c.md}, reply)
+}
+
+// invoke calls serviceMethod with the metadata and the timeout of the command
+func (c *cli) invoke(serviceMethod string, args any, reply any) error {
+	ctx, cancel := c.context()
+	defer cancel()
+	var header metadata.MD
+	err := c.client.CallContext(ctx, serviceMethod, args, reply, TRPcG.Header(&header))
+	if c.verbose {
+		keys := make([]string, 0, len(header))
+		for k := range header {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			fmt.Fprintf(c.stderr, "< %s: %s\n", k, header[k])
+		}
+	}
+	return err
+}
@@ -0,0 +1,247 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mizumoto-cn/TRPcG"
+	"github.com/mizumoto-cn/TRPcG/inspect"
+	"github.com/mizumoto-cn/TRPcG/serializer"
+	jsonp "github.com/mizumoto-cn/TRPcG/testing/json"
+	message "github.com/mizumoto-cn/TRPcG/testing/message"
+	"github.com/stretchr/testify/assert"
+)
+
+// serve starts a server with the reflection service and returns its address
+func serve(t *testing.T, rcvr any, opts ...TRPcG.Option) string {
+	return serveName(t, "", rcvr, opts...)
+}
+
+// serveName is serve registering rcvr as name, or as its type name if ""
+func serveName(t *testing.T, name string, rcvr any, opts ...TRPcG.Option) string {
+	listen, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal("listen error:", err)
+	}
+	t.Cleanup(func() { listen.Close() })
+	server := TRPcG.NewServer(append(opts, TRPcG.WithReflection())...)
+	if name == "" {
+		err = server.Register(rcvr)
+	} else {
+		err = server.RegisterName(name, rcvr)
+	}
+	if err != nil {
+		t.Fatal("register error:", err)
+	}
+	go server.Serve(listen)
+	return listen.Addr().String()
+}
+
+// trpcg runs the command line and returns its output
+func trpcg(t *testing.T, stdin string, args ...string) (string, error) {
+	var stdout, stderr bytes.Buffer
+	err := run(args, strings.NewReader(stdin), &stdout, &stderr)
+	return stdout.String(), err
+}
+
+func TestRun_Proto(t *testing.T) {
+	addr := serve(t, new(message.ArithService))
+
+	cases := []struct {
+		name   string
+		stdin  string
+		args   []string
+		expect string
+	}{
+		{"list", "", []string{addr, "list"}, "ArithService\nHealth\nReflection\n"},
+		{"list-service", "", []string{addr, "list", "ArithService"},
+			"ArithService.Add\nArithService.Div\nArithService.Mul\nArithService.Sub\n"},
+		{"describe-service", "", []string{addr, "describe", "Health"}, `service Health {
+  rpc Check(health.HealthCheckRequest) returns (health.HealthCheckResponse);
+  rpc Watch(health.HealthWatchRequest) returns (health.HealthWatchResponse);
+}
+`},
+		{"describe-method", "", []string{addr, "describe", "ArithService.Add"}, `rpc Add(message.ArithRequest) returns (message.ArithResponse);
+
+message message.ArithRequest {
+  double a = 1;
+  double b = 2;
+}
+
+message message.ArithResponse {
+  double c = 1;
+}
+`},
+		{"describe-enum", "", []string{addr, "describe", "health.ServingStatus"}, `enum health.ServingStatus {
+  UNKNOWN = 0;
+  SERVING = 1;
+  NOT_SERVING = 2;
+  SERVICE_UNKNOWN = 3;
+}
+`},
+		{"call", "", []string{addr, "call", "ArithService.Add", `{"a": 1, "b": 2}`}, "{\n  \"c\": 3\n}\n"},
+		{"call-stdin", `{"a": 6, "b": 2}`, []string{"-compress", "gzip", addr, "call", "ArithService.Div", "-"}, "{\n  \"c\": 3\n}\n"},
+		{"call-empty", "", []string{addr, "call", "ArithService.Mul"}, "{\n  \"c\": 0\n}\n"},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			out, err := trpcg(t, c.stdin, c.args...)
+			assert.Equal(t, nil, err)
+			assert.Equal(t, c.expect, out)
+		})
+	}
+
+	_, err := trpcg(t, "", addr, "call", "ArithService.Div", `{"a": 1}`)
+	assert.Equal(t, "divided by zero", err.Error())
+	_, err = trpcg(t, "", addr, "call", "ArithService.Add", `{"x": 1}`)
+	assert.Contains(t, err.Error(), "invalid message.ArithRequest request")
+	_, err = trpcg(t, "", addr, "call", "ArithService.Nope")
+	assert.Equal(t, "unknown method ArithService.Nope", err.Error())
+	_, err = trpcg(t, "", addr, "describe", "message.Nope")
+	assert.Equal(t, "unknown symbol message.Nope", err.Error())
+	_, err = trpcg(t, "", addr, "frobnicate")
+	assert.Equal(t, errUsage, err)
+}
+
+func TestRun_Json(t *testing.T) {
+	addr := serve(t, new(jsonp.TestService), TRPcG.WithSerializer(serializer.Json))
+
+	out, err := trpcg(t, "", "-serializer", "json", addr, "call", "TestService.Sub", `{"a": 1, "b": 2}`)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "{\n  \"c\": -1\n}\n", out)
+
+	out, err = trpcg(t, "", "-serializer", "json", addr, "describe", "TestService.Add")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "rpc Add(*json.Request) returns (*json.Response);\n", out)
+
+	out, err = trpcg(t, "", "-serializer", "json", "-compress", "snappy", addr, "list", "TestService")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "TestService.Add\nTestService.Div\nTestService.Mul\nTestService.Sub\n", out)
+}
+
+func TestRun_Bench(t *testing.T) {
+	addr := serve(t, new(message.ArithService))
+
+	out, err := trpcg(t, "", addr, "bench", "-d", "200ms", "-c", "2", "-compressors", "raw,gzip",
+		"-json", "-", "ArithService.Div", `{"a": 1}`)
+	assert.Equal(t, nil, err)
+	var results []benchResult
+	assert.Equal(t, nil, json.Unmarshal([]byte(out), &results))
+	assert.Equal(t, 2, len(results))
+	for i, name := range []string{"raw", "gzip"} {
+		r := results[i]
+		assert.Equal(t, name, r.Compressor)
+		assert.Greater(t, r.Requests, 0)
+		// every call divides by zero
+		assert.Equal(t, map[string]int{"Unknown": r.Requests}, r.Errors)
+		assert.Greater(t, r.BytesSent, int64(0))
+		assert.Greater(t, r.BytesRecv, int64(0))
+		assert.LessOrEqual(t, r.Latency.P50, r.Latency.P99)
+	}
+
+	// paced at 50 calls per second, whatever the number of workers
+	out, err = trpcg(t, "", addr, "bench", "-qps", "50", "-d", "300ms", "-json", "-", "ArithService.Add", `{"a": 1}`)
+	assert.Equal(t, nil, err)
+	results = nil
+	assert.Equal(t, nil, json.Unmarshal([]byte(out), &results))
+	assert.Equal(t, 0, len(results[0].Errors))
+	assert.GreaterOrEqual(t, results[0].Requests, 10)
+	assert.LessOrEqual(t, results[0].Requests, 16)
+
+	// latencies count the time the calls queued behind the slow ones
+	slow := serveName(t, "ArithService", &slowArith{delay: 40 * time.Millisecond})
+	out, err = trpcg(t, "", slow, "bench", "-qps", "100", "-c", "1", "-d", "300ms", "-json", "-", "ArithService.Add", `{"a": 1}`)
+	assert.Equal(t, nil, err)
+	results = nil
+	assert.Equal(t, nil, json.Unmarshal([]byte(out), &results))
+	assert.Greater(t, results[0].Latency.Max, 100.0)
+
+	out, err = trpcg(t, "", addr, "bench", "-d", "50ms", "ArithService.Add")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, true, strings.HasPrefix(out, "compressor  requests  errors"), out)
+}
+
+func TestRun_Proxy(t *testing.T) {
+	addr := serve(t, new(message.ArithService))
+	stop := make(chan os.Signal)
+	defer func(i func() <-chan os.Signal) { interrupted = i }(interrupted)
+	interrupted = func() <-chan os.Signal { return stop }
+	record := filepath.Join(t.TempDir(), "session.jsonl")
+
+	var stdout bytes.Buffer
+	stderr, w := io.Pipe()
+	done := make(chan error)
+	go func() {
+		done <- run([]string{addr, "proxy", "-record", record}, strings.NewReader(""), &stdout, w)
+	}()
+	line, err := bufio.NewReader(stderr).ReadString('\n')
+	assert.Equal(t, nil, err)
+	proxied := strings.Fields(line)[1]
+
+	out, err := trpcg(t, "", "-H", "client-id=alice", proxied, "call", "ArithService.Add", `{"a": 1, "b": 2}`)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "{\n  \"c\": 3\n}\n", out)
+	close(stop)
+	assert.Equal(t, nil, <-done)
+
+	// the reflection calls of the client go through the proxy too
+	printed := stdout.String()
+	assert.Contains(t, printed, "ArithService.Add id=")
+	assert.Contains(t, printed, "> client-id: alice\n{\n  \"a\": 1,\n  \"b\": 2\n}\n")
+	assert.Contains(t, printed, "{\n  \"c\": 3\n}\n")
+
+	f, err := os.Open(record)
+	assert.Equal(t, nil, err)
+	defer f.Close()
+	events, err := inspect.ReadRecording(f)
+	assert.Equal(t, nil, err)
+	last := events[len(events)-1]
+	assert.Equal(t, true, last.Response)
+	assert.Equal(t, "ArithService.Add", last.Method)
+	assert.Equal(t, "{\n  \"c\": 3\n}", last.Decoded)
+
+	// the recording replays as is against the same server
+	out, err = trpcg(t, "", addr, "replay", "-speed", "0", record)
+	assert.Equal(t, nil, err)
+	assert.Contains(t, out, "ok   #")
+	assert.Contains(t, out, " ArithService.Add id=")
+	// with the reflection calls finding the types of Add
+	assert.Equal(t, true, strings.HasSuffix(out, "4 requests: 4 matched, 0 differed, 0 failed\n"), out)
+
+	// and differs against an upgrade changing Add
+	upgradedAddr := serveName(t, "ArithService", new(upgradedArith))
+	out, err = trpcg(t, "", upgradedAddr, "replay", "-q", record)
+	assert.Equal(t, "1 of 4 responses differ from the recording", err.Error())
+	assert.Contains(t, out, " ArithService.Add id=")
+	assert.Contains(t, out, "  {\n-   \"c\": 3\n+   \"c\": 4\n  }\n4 requests: 3 matched, 1 differed, 0 failed\n")
+	assert.NotContains(t, out, "ok   ")
+}
+
+// upgradedArith is an ArithService whose Add changed
+type upgradedArith struct {
+	message.ArithService
+}
+
+func (u *upgradedArith) Add(args *message.ArithRequest, reply *message.ArithResponse) error {
+	reply.C = args.A + args.B + 1
+	return nil
+}
+
+// slowArith is an ArithService whose Add takes delay
+type slowArith struct {
+	message.ArithService
+	delay time.Duration
+}
+
+func (s *slowArith) Add(args *message.ArithRequest, reply *message.ArithResponse) error {
+	time.Sleep(s.delay)
+	return s.ArithService.Add(args, reply)
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"os/signal"
+
+	"github.com/mizumoto-cn/TRPcG/inspect"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// interrupted notifies the end of a proxy, replaced by the tests
+var interrupted = func() <-chan os.Signal {
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, os.Interrupt)
+	return c
+}
+
+// proxy forwards the connections it accepts to the server, printing the
+// requests and the responses decoded on the way
+func (c *cli) proxy(args []string) error {
+	flags := flag.NewFlagSet("proxy", flag.ContinueOnError)
+	flags.SetOutput(c.stderr)
+	addr := flags.String("listen", "127.0.0.1:0", "address to listen on")
+	record := flags.String("record", "", "also write the requests and the responses to this file, as JSON lines")
+	quiet := flags.Bool("q", false, "do not print the requests and the responses")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() != 0 {
+		return fmt.Errorf("usage: trpcg [flags] <address> proxy [-listen address] [-record file] [-q]")
+	}
+
+	var recorder *inspect.Recorder
+	if *record != "" {
+		f, err := os.Create(*record)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		recorder = inspect.NewRecorder(f)
+	}
+	p := &inspect.Proxy{Target: c.addr, Decode: c.decoder(), OnEvent: func(e *inspect.Event) {
+		if !*quiet {
+			inspect.Print(c.stdout, e)
+		}
+		if recorder != nil {
+			if err := recorder.Record(e); err != nil {
+				fmt.Fprintln(c.stderr, "record:", err)
+			}
+		}
+	}}
+
+	l, err := net.Listen("tcp", *addr)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(c.stderr, "proxying %s to %s\n", l.Addr(), c.addr)
+	done := interrupted()
+	go func() {
+		<-done
+		l.Close()
+		p.Close()
+	}()
+	p.Serve(l)
+	return nil
+}
+
+// decoder returns the decoder of the bodies of the serializer of the server,
+// protobuf bodies being decoded with the types found through the reflection
+// service of the server, or by field number for the methods without
+func (c *cli) decoder() inspect.Decoder {
+	if !c.proto {
+		return inspect.JSON
+	}
+	return inspect.Proto(func(serviceMethod string) (protoreflect.MessageType, protoreflect.MessageType) {
+		ctx, cancel := c.context()
+		defer cancel()
+		args, reply, _ := c.types.Method(ctx, serviceMethod)
+		return args, reply
+	})
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/mizumoto-cn/TRPcG/inspect"
+)
+
+// replay sends the requests of a recording to the server again, printing how
+// the responses differ from the recorded ones
+func (c *cli) replay(args []string) error {
+	flags := flag.NewFlagSet("replay", flag.ContinueOnError)
+	flags.SetOutput(c.stderr)
+	speed := flags.Float64("speed", 1, "of the recorded timing, 2 replaying twice as fast, 0 as fast as possible")
+	quiet := flags.Bool("q", false, "only print the responses that differ")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() != 1 {
+		return fmt.Errorf("usage: trpcg [flags] <address> replay [-speed n] [-q] <file>")
+	}
+	f, err := os.Open(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	events, err := inspect.ReadRecording(f)
+	f.Close()
+	if err != nil {
+		return err
+	}
+
+	r := &inspect.Replayer{Target: c.addr, Speed: *speed, Decode: c.decoder(), Timeout: c.timeout}
+	results := r.Replay(context.Background(), events)
+	differed, failed := 0, 0
+	for _, result := range results {
+		e := result.Request
+		switch {
+		case result.Err != nil:
+			failed++
+			fmt.Fprintf(c.stdout, "FAIL #%d %s id=%d: %v\n", e.Conn, e.Method, e.ID, result.Err)
+		case !result.Match():
+			differed++
+			fmt.Fprintf(c.stdout, "DIFF #%d %s id=%d %v\n%s", e.Conn, e.Method, e.ID, result.Latency, result.Diff())
+		case !*quiet:
+			fmt.Fprintf(c.stdout, "ok   #%d %s id=%d %v\n", e.Conn, e.Method, e.ID, result.Latency)
+		}
+	}
+	fmt.Fprintf(c.stdout, "%d requests: %d matched, %d differed, %d failed\n",
+		len(results), len(results)-differed-failed, differed, failed)
+	if differed+failed > 0 {
+		return fmt.Errorf("%d of %d responses differ from the recording", differed+failed, len(results))
+	}
+	return nil
+}
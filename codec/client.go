@@ -11,6 +11,7 @@ import (
 	// cSpell:ignore mizumoto
 	"github.com/mizumoto-cn/TRPcG/compressor"
 	"github.com/mizumoto-cn/TRPcG/header"
+	"github.com/mizumoto-cn/TRPcG/metadata"
 	"github.com/mizumoto-cn/TRPcG/serializer"
 )
 
@@ -40,6 +41,14 @@ import (
 // 	next          *Response
 // }
 
+// Envelope wraps the args of a call together with the metadata carried in its headers.
+// It can be passed as args to rpc.Client.Go, WriteRequest unwraps it.
+type Envelope struct {
+	Args     any
+	Metadata metadata.MD  // sent in the request header
+	Header   *metadata.MD // if not nil, receives the metadata of the response header
+}
+
 type clientCodec struct {
 	r io.Reader
 	w io.Writer
@@ -47,9 +56,11 @@ type clientCodec struct {
 
 	compressor compressor.CompressType
 	serializer serializer.Serializer
+	handshake  bool // whether Magic was sent
 	response   header.ResponseHeader
-	mutex      sync.Mutex // protect pending map
+	mutex      sync.Mutex // protect pending and headers map
 	pending    map[uint64]string
+	headers    map[uint64]*metadata.MD
 }
 
 func (client *clientCodec) Close() error {
@@ -58,6 +69,15 @@ func (client *clientCodec) Close() error {
 
 // WriteRequest Write the rpc request header and body to the io stream
 func (client *clientCodec) WriteRequest(r *rpc.Request, param any) error {
+	var meta metadata.MD
+	if e, ok := param.(*Envelope); ok {
+		param, meta = e.Args, e.Metadata
+		if e.Header != nil {
+			client.mutex.Lock()
+			client.headers[r.Seq] = e.Header
+			client.mutex.Unlock()
+		}
+	}
 	client.mutex.Lock()
 	client.pending[r.Seq] /*sequence number chosen by client*/ = r.ServiceMethod // format service.method
 	client.mutex.Unlock()
@@ -87,13 +107,16 @@ func (client *clientCodec) WriteRequest(r *rpc.Request, param any) error {
 	h.RequestLen = uint32(len(c_reqBody))
 	h.CompressType = compressor.CompressType(client.compressor)
 	h.Checksum = crc32.ChecksumIEEE(c_reqBody)
-	// send Req Header
-	err = sendFrame(client.w, h.Marshal())
-	if err != nil {
-		return err
+	h.Meta = meta
+	// net/rpc writes the requests one at a time, the first one after Magic
+	if !client.handshake {
+		client.handshake = true
+		if err = write(client.w, []byte(Magic)); err != nil {
+			return err
+		}
 	}
-	// send req body
-	if err = write(client.w, c_reqBody); err != nil {
+	// send Req Header and body
+	if err = writeMessage(client.w, h.Marshal(), c_reqBody); err != nil {
 		return err
 	}
 
@@ -108,7 +131,7 @@ func (client *clientCodec) ReadResponseHeader(r *rpc.Response) error {
 	//receive header
 	data, err := receiveFrame(client.r)
 	if err != nil {
-		return nil
+		return err
 	}
 	err = client.response.Unmarshal(data)
 	if err != nil {
@@ -120,6 +143,11 @@ func (client *clientCodec) ReadResponseHeader(r *rpc.Response) error {
 	r.Error = client.response.Error
 	// infer service method from seqID
 	r.ServiceMethod = client.pending[r.Seq]
+	// hand the response metadata over to the caller
+	if dst, ok := client.headers[r.Seq]; ok {
+		*dst = metadata.New(client.response.Meta)
+		delete(client.headers, r.Seq)
+	}
 	// delete seqID
 	delete(client.pending, r.Seq)
 	client.mutex.Unlock()
@@ -179,5 +207,6 @@ func NewClientCodec(conn io.ReadWriteCloser, compressType compressor.CompressTyp
 		compressor: compressType,
 		serializer: serializer,
 		pending:    make(map[uint64]string),
+		headers:    make(map[uint64]*metadata.MD),
 	}
 }
@@ -0,0 +1,66 @@
+package codec
+
+import (
+	"bufio"
+	"hash/crc32"
+	"io"
+
+	"github.com/mizumoto-cn/TRPcG/compressor"
+	"github.com/mizumoto-cn/TRPcG/header"
+)
+
+// ReadRequest reads a request as written by the client codec off a stream,
+// returning its header and its body as sent, compressed
+func ReadRequest(r *bufio.Reader) (*header.RequestHeader, []byte, error) {
+	data, err := receiveFrame(r)
+	if err != nil {
+		return nil, nil, err
+	}
+	h := &header.RequestHeader{}
+	if err = h.UnMarshal(data); err != nil {
+		return nil, nil, err
+	}
+	body := make([]byte, h.RequestLen)
+	if err = read(r, body); err != nil {
+		return nil, nil, err
+	}
+	return h, body, nil
+}
+
+// ReadResponse reads a response as written by the server codec off a stream,
+// returning its header and its body as sent, compressed
+func ReadResponse(r *bufio.Reader) (*header.ResponseHeader, []byte, error) {
+	data, err := receiveFrame(r)
+	if err != nil {
+		return nil, nil, err
+	}
+	h := &header.ResponseHeader{}
+	if err = h.Unmarshal(data); err != nil {
+		return nil, nil, err
+	}
+	body := make([]byte, h.ResponseLen)
+	if err = read(r, body); err != nil {
+		return nil, nil, err
+	}
+	return h, body, nil
+}
+
+// WriteRequest writes a request as the client codec does, its body as given,
+// compressed with the compress type and the checksum of its header
+func WriteRequest(w io.Writer, h *header.RequestHeader, body []byte) error {
+	h.RequestLen = uint32(len(body))
+	return writeMessage(w, h.Marshal(), body)
+}
+
+// Decompress checks the checksum of a body read by ReadRequest or ReadResponse,
+// a zero checksum being ignored, and decompresses it
+func Decompress(compressType compressor.CompressType, checksum uint32, body []byte) ([]byte, error) {
+	if checksum != 0 && crc32.ChecksumIEEE(body) != checksum {
+		return nil, ErrUnexpectedChecksum
+	}
+	c, ok := compressor.Compressors[compressType]
+	if !ok {
+		return nil, ErrCompressorNotFound
+	}
+	return c.Unzip(body)
+}
@@ -1,6 +1,7 @@
 package codec
 
 import (
+	"bytes"
 	"encoding/binary"
 	"io"
 	"net"
@@ -31,6 +32,17 @@ func sendFrame(w io.Writer, data []byte) (err error) {
 	return
 }
 
+// writeMessage writes the frame of header and body with a single Write of w, so
+// that the connections sending each Write as a message, such as the WebSocket
+// ones, send them as one message however large
+func writeMessage(w io.Writer, header []byte, body []byte) error {
+	var buf bytes.Buffer
+	buf.Grow(binary.MaxVarintLen64 + len(header) + len(body))
+	sendFrame(&buf, header)
+	buf.Write(body)
+	return write(w, buf.Bytes())
+}
+
 func write(w io.Writer, data []byte) error {
 	for i := 0; i < len(data); {
 		// returns the number of bytes written from data (0 <= n <= len(data))
@@ -0,0 +1,37 @@
+package codec
+
+import (
+	"bufio"
+	"errors"
+	"io"
+)
+
+// Magic is sent by the client codecs before their first request, so that a
+// server serving other protocols on the same port, such as HTTP, tells the
+// TRPcG connections apart. Its first byte, zero, never starts a request, as
+// the length of a request header is never zero: the server codecs also serve
+// the clients which do not send it.
+const Magic = "\x00TRPcG"
+
+// ErrInvalidMagic is returned by the server codecs for the connections
+// starting with a zero byte but not with Magic
+var ErrInvalidMagic = errors.New("invalid handshake magic")
+
+// SkipMagic reads Magic off r if the stream starts with it, and nothing otherwise
+func SkipMagic(r *bufio.Reader) error {
+	first, err := r.Peek(1)
+	if err != nil {
+		return err
+	}
+	if first[0] != Magic[0] {
+		return nil
+	}
+	var magic [len(Magic)]byte
+	if _, err = io.ReadFull(r, magic[:]); err != nil {
+		return err
+	}
+	if string(magic[:]) != Magic {
+		return ErrInvalidMagic
+	}
+	return nil
+}
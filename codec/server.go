@@ -9,6 +9,7 @@ import (
 
 	"github.com/mizumoto-cn/TRPcG/compressor"
 	"github.com/mizumoto-cn/TRPcG/header"
+	"github.com/mizumoto-cn/TRPcG/metadata"
 	"github.com/mizumoto-cn/TRPcG/serializer"
 )
 
@@ -23,6 +24,8 @@ import (
 type reqContext struct {
 	id             uint64
 	compressorType compressor.CompressType
+	meta           metadata.MD // received with the request
+	header         metadata.MD // sent back with the response
 }
 
 type serverCodec struct {
@@ -31,6 +34,7 @@ type serverCodec struct {
 	c io.Closer
 
 	request    header.RequestHeader
+	handshake  bool // whether the connection was checked for Magic
 	serializer serializer.Serializer
 	mutex      sync.Mutex
 	seq        uint64
@@ -40,6 +44,12 @@ type serverCodec struct {
 // ServerCodec::ReadRequestHeader()
 func (server *serverCodec) ReadRequestHeader(r *rpc.Request) error {
 	server.request.ResetHeader()
+	if !server.handshake {
+		server.handshake = true
+		if err := SkipMagic(server.r.(*bufio.Reader)); err != nil {
+			return err
+		}
+	}
 	data, err := receiveFrame(server.r)
 	if err != nil {
 		return err
@@ -50,7 +60,11 @@ func (server *serverCodec) ReadRequestHeader(r *rpc.Request) error {
 	}
 	server.mutex.Lock()
 	server.seq++ // add one to seqID
-	server.pending[server.seq] = &reqContext{server.request.ID, server.request.GetCompressType()}
+	server.pending[server.seq] = &reqContext{
+		id:             server.request.ID,
+		compressorType: server.request.GetCompressType(),
+		meta:           server.request.Meta,
+	}
 	r.ServiceMethod = server.request.Method
 	r.Seq = server.seq
 	server.mutex.Unlock()
@@ -145,12 +159,9 @@ func (server *serverCodec) WriteResponse(r *rpc.Response, param any) error {
 	h.ResponseLen = uint32(len(compressedResBody))
 	h.CheckSum = crc32.ChecksumIEEE(compressedResBody)
 	h.CompressType = reqContext.compressorType
+	h.Meta = reqContext.header
 
-	err = sendFrame(server.w, h.Marshal())
-	if err != nil {
-		return err
-	}
-	err = write(server.w, compressedResBody)
+	err = writeMessage(server.w, h.Marshal(), compressedResBody)
 	if err != nil {
 		return err
 	}
@@ -158,6 +169,25 @@ func (server *serverCodec) WriteResponse(r *rpc.Response, param any) error {
 	return nil
 }
 
+// Metadata returns the metadata received with the request of sequence number seq
+func (server *serverCodec) Metadata(seq uint64) metadata.MD {
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+	if ctx, ok := server.pending[seq]; ok {
+		return ctx.meta
+	}
+	return nil
+}
+
+// SetHeader sets the metadata sent back with the response of sequence number seq
+func (server *serverCodec) SetHeader(seq uint64, md metadata.MD) {
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+	if ctx, ok := server.pending[seq]; ok {
+		ctx.header = md
+	}
+}
+
 func (server *serverCodec) Close() error {
 	return server.c.Close()
 }
@@ -1,16 +1,43 @@
 package TRPcG
 
 import (
+	"bufio"
+	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
 	"log"
 	"net"
+	"net/http"
+	"net/http/httptest"
 	"net/rpc"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync/atomic"
 	"testing"
+	"time"
 
+	"github.com/mizumoto-cn/TRPcG/balancer"
+	"github.com/mizumoto-cn/TRPcG/breaker"
+	"github.com/mizumoto-cn/TRPcG/codec"
 	"github.com/mizumoto-cn/TRPcG/compressor"
+	"github.com/mizumoto-cn/TRPcG/header"
+	"github.com/mizumoto-cn/TRPcG/health"
+	"github.com/mizumoto-cn/TRPcG/metadata"
+	"github.com/mizumoto-cn/TRPcG/ratelimit"
+	"github.com/mizumoto-cn/TRPcG/reflection"
+	"github.com/mizumoto-cn/TRPcG/registry"
+	"github.com/mizumoto-cn/TRPcG/resolver"
+	"github.com/mizumoto-cn/TRPcG/serializer"
+	"github.com/mizumoto-cn/TRPcG/status"
 	jsonp "github.com/mizumoto-cn/TRPcG/testing/json"
 	message "github.com/mizumoto-cn/TRPcG/testing/message"
+	"github.com/mizumoto-cn/TRPcG/websocket"
 	"github.com/stretchr/testify/assert"
+	"google.golang.org/protobuf/proto"
 )
 
 // Json
@@ -26,36 +53,29 @@ func (j *Json) Unmarshal(data []byte, message any) error {
 	return json.Unmarshal(data, message)
 }
 
-func init() {
-	listen, err := net.Listen("tcp", ":8008")
-	if err != nil {
-		log.Fatal("listen error:", err)
-	}
+// arithListener and jsonListener are the in-memory listeners of the servers shared by the tests
+var arithListener, jsonListener = ListenPipe(), ListenPipe()
 
+func init() {
 	server := NewServer()
-	err = server.Register(new(message.ArithService))
+	err := server.Register(new(message.ArithService))
 	if err != nil {
 		log.Fatal("register error:", err)
 	}
-	go server.Serve(listen)
-
-	listen, err = net.Listen("tcp", ":8009")
-	if err != nil {
-		log.Fatal("listen error:", err)
-	}
+	go server.Serve(arithListener)
 
 	server = NewServer(WithSerializer(&Json{}))
 	err = server.Register(new(jsonp.TestService))
 	if err != nil {
 		log.Fatal("register error:", err)
 	}
-	go server.Serve(listen)
+	go server.Serve(jsonListener)
 }
 
 // Test_Client_Call tests the synchronous call of the client.
 func Test_Client_Call(t *testing.T) {
 	compressType := compressor.Gzip
-	conn, err := net.Dial("tcp", ":8008")
+	conn, err := arithListener.Dial()
 	if err != nil {
 		log.Fatal("dial error:", err)
 		// t.Fatal("dial error:", err)
@@ -137,3 +157,1022 @@ func Test_Client_Call(t *testing.T) {
 		})
 	}
 }
+
+// Test_Server_RateLimit tests the rejection of calls over the rate limit
+func Test_Server_RateLimit(t *testing.T) {
+	listen, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal("listen error:", err)
+	}
+	defer listen.Close()
+
+	limiter := ratelimit.NewLimiter().
+		SetRule("ArithService.Div", ratelimit.Rule{Rate: 0.001, Burst: 1, Key: ratelimit.ByMetadata("client-id")})
+	server := NewServer(WithRateLimiter(limiter))
+	err = server.Register(new(message.ArithService))
+	if err != nil {
+		t.Fatal("register error:", err)
+	}
+	go server.Serve(listen)
+
+	conn, err := net.Dial("tcp", listen.Addr().String())
+	if err != nil {
+		t.Fatal("dial error:", err)
+	}
+	client := NewClient(conn)
+	defer client.Close()
+
+	ctx := metadata.NewOutgoingContext(context.Background(), metadata.Pairs("client-id", "alice"))
+	args := &message.ArithRequest{A: 6, B: 2}
+
+	reply := &message.ArithResponse{}
+	err = client.CallContext(ctx, "ArithService.Div", args, reply)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, float64(3), reply.C)
+
+	// not limited
+	err = client.CallContext(ctx, "ArithService.Add", args, reply)
+	assert.Equal(t, nil, err)
+
+	var header metadata.MD
+	err = client.CallContext(ctx, "ArithService.Div", args, reply, Header(&header))
+	assert.Equal(t, status.ResourceExhausted, status.CodeOf(err))
+	wait, ok := ratelimit.RetryAfter(header)
+	assert.Equal(t, true, ok)
+	assert.Equal(t, true, wait > 0)
+
+	// another client id has its own bucket
+	ctx = metadata.NewOutgoingContext(context.Background(), metadata.Pairs("client-id", "bob"))
+	err = client.CallContext(ctx, "ArithService.Div", args, reply)
+	assert.Equal(t, nil, err)
+}
+
+// Test_Server_HTTP tests TRPcG, HTTP and the health probes served on the same listener
+func Test_Server_HTTP(t *testing.T) {
+	listen, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal("listen error:", err)
+	}
+	mux := http.NewServeMux()
+	server := NewServer(WithHTTP(mux))
+	defer server.Close()
+	mux.Handle("/rpc", server.JSONRPCHandler())
+	assert.Equal(t, nil, server.Register(new(message.ArithService)))
+	go server.Serve(listen)
+
+	conn, err := net.Dial("tcp", listen.Addr().String())
+	if err != nil {
+		t.Fatal("dial error:", err)
+	}
+	client := NewClient(conn)
+	defer client.Close()
+	reply := &message.ArithResponse{}
+	assert.Equal(t, nil, client.Call("ArithService.Add", &message.ArithRequest{A: 1, B: 2}, reply))
+	assert.Equal(t, float64(3), reply.C)
+
+	url := "http://" + listen.Addr().String()
+	resp, err := http.Get(url + HealthPath + "?service=ArithService")
+	if err != nil {
+		t.Fatal("get error:", err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "SERVING\n", string(body))
+
+	resp, err = http.Post(url+"/rpc", "application/json",
+		strings.NewReader(`{"jsonrpc": "2.0", "method": "ArithService.Mul", "params": {"a": 2, "b": 3}, "id": 1}`))
+	if err != nil {
+		t.Fatal("post error:", err)
+	}
+	body, _ = io.ReadAll(resp.Body)
+	resp.Body.Close()
+	assert.JSONEq(t, `{"jsonrpc": "2.0", "result": {"c": 6}, "id": 1}`, string(body))
+
+	resp, err = http.Get(url + "/unknown")
+	if err != nil {
+		t.Fatal("get error:", err)
+	}
+	resp.Body.Close()
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+
+	// the TRPcG connection still works
+	assert.Equal(t, nil, client.Call("ArithService.Sub", &message.ArithRequest{A: 1, B: 2}, reply))
+	assert.Equal(t, float64(-1), reply.C)
+
+	// the idle HTTP connections are closed once Serve returns
+	idle, err := net.Dial("tcp", listen.Addr().String())
+	if err != nil {
+		t.Fatal("dial error:", err)
+	}
+	defer idle.Close()
+	fmt.Fprint(idle, "GET "+HealthPath+" HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	r := bufio.NewReader(idle)
+	resp, err = http.ReadResponse(r, nil)
+	if err != nil {
+		t.Fatal("read error:", err)
+	}
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, nil, server.Close())
+	idle.SetReadDeadline(time.Now().Add(5 * time.Second))
+	_, err = r.ReadByte()
+	assert.Equal(t, io.EOF, err)
+}
+
+// Test_Server_Magic tests that the clients send the handshake magic, and that the
+// servers also serve the clients which do not
+func Test_Server_Magic(t *testing.T) {
+	client, server := net.Pipe()
+	c := NewClient(client)
+	defer c.Close()
+	// the writes of a pipe wait for their reads
+	go c.Go("ArithService.Add", &message.ArithRequest{A: 1, B: 2}, &message.ArithResponse{}, nil)
+	magic := make([]byte, len(codec.Magic))
+	_, err := io.ReadFull(server, magic)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, codec.Magic, string(magic))
+	server.Close()
+
+	listen, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal("listen error:", err)
+	}
+	s := NewServer(WithHTTP(nil))
+	defer s.Close()
+	assert.Equal(t, nil, s.Register(new(message.ArithService)))
+	go s.Serve(listen)
+
+	// a client starting with its first frame
+	conn, err := net.Dial("tcp", listen.Addr().String())
+	if err != nil {
+		t.Fatal("dial error:", err)
+	}
+	defer conn.Close()
+	body, _ := proto.Marshal(&message.ArithRequest{A: 1, B: 2})
+	assert.Equal(t, nil, codec.WriteRequest(conn, &header.RequestHeader{Method: "ArithService.Add", ID: 1}, body))
+	h, body, err := codec.ReadResponse(bufio.NewReader(conn))
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "", h.Error)
+	reply := &message.ArithResponse{}
+	assert.Equal(t, nil, proto.Unmarshal(body, reply))
+	assert.Equal(t, float64(3), reply.C)
+
+	// a connection starting with a zero byte but not with the magic is closed
+	bad, err := net.Dial("tcp", listen.Addr().String())
+	if err != nil {
+		t.Fatal("dial error:", err)
+	}
+	defer bad.Close()
+	fmt.Fprint(bad, "\x00HTTP/1.1")
+	bad.SetReadDeadline(time.Now().Add(5 * time.Second))
+	_, err = bad.Read(make([]byte, 1))
+	assert.Equal(t, io.EOF, err)
+}
+
+// Test_Server_WebSocket tests the calls over WebSocket connections, of a client and of a cluster client
+func Test_Server_WebSocket(t *testing.T) {
+	server := NewServer()
+	assert.Equal(t, nil, server.Register(new(message.ArithService)))
+	ws := httptest.NewServer(server.WebSocketHandler())
+	defer ws.Close()
+	addr := strings.TrimPrefix(ws.URL, "http://")
+
+	conn, err := websocket.Dial(context.Background(), "ws://"+addr+"/")
+	if err != nil {
+		t.Fatal("dial error:", err)
+	}
+	client := NewClient(conn, WithCompress(compressor.Gzip))
+	defer client.Close()
+	reply := &message.ArithResponse{}
+	assert.Equal(t, nil, client.Call("ArithService.Mul", &message.ArithRequest{A: 6, B: 7}, reply))
+	assert.Equal(t, float64(42), reply.C)
+	err = client.Call("ArithService.Div", &message.ArithRequest{A: 1}, reply)
+	assert.Equal(t, "divided by zero", status.Convert(err).Message())
+
+	cc, err := Dial(addr, WithDialer(websocket.Dialer("ws", "/")))
+	if err != nil {
+		t.Fatal("dial error:", err)
+	}
+	defer cc.Close()
+	assert.Equal(t, nil, cc.CallContext(context.Background(), "ArithService.Add", &message.ArithRequest{A: 1, B: 2}, reply))
+	assert.Equal(t, float64(3), reply.C)
+}
+
+// Test_Server_Unix tests the calls over unix sockets, in the file system and in the abstract namespace
+func Test_Server_Unix(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "arith.sock")
+	// a stale socket file, left by a listener which did not close
+	stale, err := net.ListenUnix("unix", &net.UnixAddr{Name: path, Net: "unix"})
+	if err != nil {
+		t.Fatal("listen error:", err)
+	}
+	stale.SetUnlinkOnClose(false)
+	stale.Close()
+
+	listen, err := ListenUnix(path, WithFileMode(0600))
+	if err != nil {
+		t.Fatal("listen error:", err)
+	}
+	info, err := os.Stat(path)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+	_, err = ListenUnix(path)
+	assert.NotEqual(t, nil, err, "in use")
+	_, err = ListenUnix(path, WithFileMode(0600))
+	assert.NotEqual(t, nil, err, "in use")
+	// the private directory of the socket is gone
+	entries, err := os.ReadDir(filepath.Dir(path))
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 1, len(entries))
+
+	server := NewServer()
+	defer server.Close()
+	assert.Equal(t, nil, server.Register(new(message.ArithService)))
+	go server.Serve(listen)
+
+	conn, err := DialUnix(context.Background(), path)
+	if err != nil {
+		t.Fatal("dial error:", err)
+	}
+	client := NewClient(conn)
+	defer client.Close()
+	reply := &message.ArithResponse{}
+	assert.Equal(t, nil, client.Call("ArithService.Add", &message.ArithRequest{A: 1, B: 2}, reply))
+	assert.Equal(t, float64(3), reply.C)
+
+	cc, err := Dial(path, WithDialer(UnixDialer()))
+	if err != nil {
+		t.Fatal("dial error:", err)
+	}
+	defer cc.Close()
+	assert.Equal(t, nil, cc.CallContext(context.Background(), "ArithService.Mul", &message.ArithRequest{A: 2, B: 3}, reply))
+	assert.Equal(t, float64(6), reply.C)
+
+	// the socket file is removed on close
+	assert.Equal(t, nil, listen.Close())
+	_, err = os.Stat(path)
+	assert.Equal(t, true, os.IsNotExist(err))
+
+	if runtime.GOOS != "linux" {
+		return
+	}
+	name := fmt.Sprintf("trpcg-test-%d", os.Getpid())
+	listen, err = ListenUnix(name, WithAbstractNamespace())
+	if err != nil {
+		t.Fatal("listen error:", err)
+	}
+	go server.Serve(listen)
+	_, err = os.Stat(name)
+	assert.Equal(t, true, os.IsNotExist(err))
+	_, err = DialUnix(context.Background(), name)
+	assert.NotEqual(t, nil, err, "not in the file system")
+	conn, err = DialUnix(context.Background(), name, WithAbstractNamespace())
+	if err != nil {
+		t.Fatal("dial error:", err)
+	}
+	abstract := NewClient(conn)
+	defer abstract.Close()
+	assert.Equal(t, nil, abstract.Call("ArithService.Sub", &message.ArithRequest{A: 2, B: 3}, reply))
+	assert.Equal(t, float64(-1), reply.C)
+}
+
+// Test_PipeListener tests the in-memory listener
+func Test_PipeListener(t *testing.T) {
+	listen := ListenPipe()
+	server := NewServer()
+	assert.Equal(t, nil, server.Register(new(message.ArithService)))
+	go server.Serve(listen)
+
+	cc, err := Dial("pipe", WithDialer(listen.DialContext))
+	if err != nil {
+		t.Fatal("dial error:", err)
+	}
+	defer cc.Close()
+	reply := &message.ArithResponse{}
+	assert.Equal(t, nil, cc.CallContext(context.Background(), "ArithService.Add", &message.ArithRequest{A: 1, B: 2}, reply))
+	assert.Equal(t, float64(3), reply.C)
+
+	idle := ListenPipe()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err = idle.DialContext(ctx, "")
+	assert.Equal(t, context.DeadlineExceeded, err)
+
+	server.Close()
+	_, err = listen.Dial()
+	assert.Equal(t, true, errors.Is(err, net.ErrClosed))
+	assert.Equal(t, status.Unavailable, status.CodeOf(err))
+}
+
+// FlakyService fails with Unavailable until it has been called Failures times
+type FlakyService struct {
+	Failures int32
+	calls    int32
+}
+
+// Echo echoes args after the failures
+func (f *FlakyService) Echo(args *jsonp.Request, reply *jsonp.Response) error {
+	if atomic.AddInt32(&f.calls, 1) <= f.Failures {
+		return status.Error(status.Unavailable, "try again")
+	}
+	reply.C = args.A
+	return nil
+}
+
+// Test_Client_Retry tests the retry policies of the client
+func Test_Client_Retry(t *testing.T) {
+	listen, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal("listen error:", err)
+	}
+	defer listen.Close()
+
+	server := NewServer(WithSerializer(&Json{}))
+	go server.Serve(listen)
+
+	policy := RetryPolicy{
+		MaxAttempts:    3,
+		InitialBackoff: time.Millisecond,
+		Multiplier:     2,
+		RetryableCodes: []status.Code{status.Unavailable},
+	}
+	idempotent := policy
+	idempotent.Idempotent = true
+
+	cases := []struct {
+		name     string
+		failures int32
+		expect   status.Code
+		calls    int32
+	}{
+		{"idempotent-recovers", 2, status.OK, 3},
+		{"idempotent-gives-up", 5, status.Unavailable, 3},
+		{"not-idempotent", 1, status.Unavailable, 1},
+		{"no-policy", 1, status.Unavailable, 1},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			flaky := &FlakyService{Failures: c.failures}
+			err := server.RegisterName(c.name, flaky)
+			if err != nil {
+				t.Fatal("register error:", err)
+			}
+			conn, err := net.Dial("tcp", listen.Addr().String())
+			if err != nil {
+				t.Fatal("dial error:", err)
+			}
+			client := NewClient(conn, WithSerializer(&Json{}),
+				WithRetryPolicy("idempotent-recovers.*", idempotent),
+				WithRetryPolicy("idempotent-gives-up.Echo", idempotent),
+				WithRetryPolicy("not-idempotent.*", policy))
+			defer client.Close()
+
+			reply := &jsonp.Response{}
+			err = client.Call(c.name+".Echo", &jsonp.Request{A: 1}, reply)
+			assert.Equal(t, c.expect, status.CodeOf(err))
+			assert.Equal(t, c.calls, atomic.LoadInt32(&flaky.calls))
+		})
+	}
+}
+
+// TestRetryPolicy_retryable tests RetryPolicy::retryable
+func TestRetryPolicy_retryable(t *testing.T) {
+	policy := RetryPolicy{RetryableCodes: []status.Code{status.Unavailable, status.ResourceExhausted}}
+	limited := status.Error(status.ResourceExhausted, "slow down")
+
+	ok, _ := policy.retryable(status.Error(status.Unavailable, "down"), nil, true)
+	assert.Equal(t, false, ok)
+	ok, _ = policy.retryable(rpc.ErrShutdown, nil, true)
+	assert.Equal(t, true, ok)
+	// the connection is not dialed again
+	ok, _ = policy.retryable(rpc.ErrShutdown, nil, false)
+	assert.Equal(t, false, ok)
+	policy.Idempotent = true
+	ok, _ = policy.retryable(io.ErrUnexpectedEOF, nil, false)
+	assert.Equal(t, false, ok)
+	ok, _ = policy.retryable(status.Error(status.Unavailable, "down"), nil, false)
+	assert.Equal(t, true, ok)
+	ok, wait := policy.retryable(limited, metadata.Pairs(ratelimit.RetryAfterKey, "1s"), false)
+	assert.Equal(t, true, ok)
+	assert.Equal(t, time.Second, wait)
+	ok, _ = policy.retryable(status.Error(status.Internal, "boom"), nil, false)
+	assert.Equal(t, false, ok)
+}
+
+// Test_Client_CircuitBreaker tests that calls fail fast once the breaker is open
+func Test_Client_CircuitBreaker(t *testing.T) {
+	listen, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal("listen error:", err)
+	}
+	defer listen.Close()
+
+	server := NewServer(WithSerializer(&Json{}))
+	flaky := &FlakyService{Failures: 2}
+	err = server.Register(flaky)
+	if err != nil {
+		t.Fatal("register error:", err)
+	}
+	go server.Serve(listen)
+
+	conn, err := net.Dial("tcp", listen.Addr().String())
+	if err != nil {
+		t.Fatal("dial error:", err)
+	}
+	var opened string
+	breakers := breaker.NewGroup(breaker.Config{
+		ConsecutiveFailures: 2,
+		CoolDown:            time.Hour,
+		OnStateChange: func(key string, _, to breaker.State) {
+			if to == breaker.Open {
+				opened = key
+			}
+		},
+	})
+	client := NewClient(conn, WithSerializer(&Json{}), WithTarget("flaky"), WithCircuitBreaker(breakers))
+	defer client.Close()
+
+	reply := &jsonp.Response{}
+	for i := 0; i < 2; i++ {
+		err = client.Call("FlakyService.Echo", &jsonp.Request{A: 1}, reply)
+		assert.Equal(t, status.Unavailable, status.CodeOf(err))
+	}
+	assert.Equal(t, "flaky", opened)
+
+	err = client.Call("FlakyService.Echo", &jsonp.Request{A: 1}, reply)
+	assert.Equal(t, breaker.ErrOpen, err)
+	call := <-client.AsyncCall("FlakyService.Echo", &jsonp.Request{A: 1}, reply)
+	assert.Equal(t, breaker.ErrOpen, call.Error)
+	assert.Equal(t, int32(2), atomic.LoadInt32(&flaky.calls))
+}
+
+// SleepyService answers after sleeping Sleep, unless the call is canceled
+type SleepyService struct {
+	Sleep    time.Duration
+	canceled chan struct{}
+}
+
+// Echo echoes args after the sleep
+func (s *SleepyService) Echo(args *jsonp.Request, reply *jsonp.Response) error {
+	select {
+	case <-time.After(s.Sleep):
+	case <-ContextOf(args).Done():
+		s.canceled <- struct{}{}
+		return ContextOf(args).Err()
+	}
+	reply.C = args.A
+	return nil
+}
+
+// Test_Client_Hedging tests that the first response of a hedged call wins
+func Test_Client_Hedging(t *testing.T) {
+	canceled := make(chan struct{}, 1)
+	dial := func(sleep time.Duration, opts ...Option) *Client {
+		listen, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal("listen error:", err)
+		}
+		t.Cleanup(func() { listen.Close() })
+		server := NewServer(WithSerializer(&Json{}))
+		err = server.RegisterName("Sleepy", &SleepyService{Sleep: sleep, canceled: canceled})
+		if err != nil {
+			t.Fatal("register error:", err)
+		}
+		go server.Serve(listen)
+
+		conn, err := net.Dial("tcp", listen.Addr().String())
+		if err != nil {
+			t.Fatal("dial error:", err)
+		}
+		client := NewClient(conn, append(opts, WithSerializer(&Json{}))...)
+		t.Cleanup(func() { client.Close() })
+		return client
+	}
+
+	fast := dial(0, WithTarget("fast"))
+	policy := HedgePolicy{Delay: 20 * time.Millisecond, Alternates: []*Client{fast}}
+	slow := dial(time.Second, WithTarget("slow"), WithHedging("Sleepy.*", policy))
+
+	reply := &jsonp.Response{}
+	call := <-slow.AsyncHedgedCall("Sleepy.Echo", &jsonp.Request{A: 1}, reply)
+	assert.Equal(t, nil, call.Error)
+	assert.Equal(t, 1, call.Attempt)
+	assert.Equal(t, 2, call.Attempts)
+	assert.Equal(t, "fast", call.Target)
+	assert.Equal(t, float64(1), reply.C)
+	// the slow attempt lost
+	select {
+	case <-canceled:
+	case <-time.After(time.Second / 2):
+		t.Fatal("the losing attempt was not canceled")
+	}
+
+	// answered before the delay
+	policy.Alternates = []*Client{slow}
+	first := dial(0, WithTarget("first"), WithHedging("Sleepy.Echo", policy))
+	call = <-first.AsyncHedgedCall("Sleepy.Echo", &jsonp.Request{A: 2}, reply)
+	assert.Equal(t, nil, call.Error)
+	assert.Equal(t, 0, call.Attempt)
+	assert.Equal(t, 1, call.Attempts)
+	assert.Equal(t, float64(2), reply.C)
+
+	// Call is hedged as well
+	reply = &jsonp.Response{}
+	err := slow.Call("Sleepy.Echo", &jsonp.Request{A: 3}, reply)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, float64(3), reply.C)
+}
+
+// IdService tells which server answered
+type IdService struct {
+	ID float64
+}
+
+// Who replies the ID of the server
+func (s *IdService) Who(args *jsonp.Request, reply *jsonp.Response) error {
+	reply.C = s.ID
+	return nil
+}
+
+// serveJson starts a server with the Json serializer on a random port and returns its address
+func serveJson(t *testing.T, name string, rcvr any) string {
+	_, addr := serveJsonServer(t, name, rcvr)
+	return addr
+}
+
+// serveJsonServer is like serveJson, also returning the server
+func serveJsonServer(t *testing.T, name string, rcvr any) (*Server, string) {
+	listen, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal("listen error:", err)
+	}
+	t.Cleanup(func() { listen.Close() })
+	server := NewServer(WithSerializer(&Json{}))
+	err = server.RegisterName(name, rcvr)
+	if err != nil {
+		t.Fatal("register error:", err)
+	}
+	go server.Serve(listen)
+	return server, listen.Addr().String()
+}
+
+// chanResolver sends the address sets written to it
+type chanResolver chan []resolver.Address
+
+func (r chanResolver) Watch(ctx context.Context, target string) (<-chan []resolver.Address, error) {
+	return r, nil
+}
+
+// Test_ClusterClient tests that the cluster client follows the resolver
+func Test_ClusterClient(t *testing.T) {
+	addr1 := serveJson(t, "Id", &IdService{ID: 1})
+	addr2 := serveJson(t, "Id", &IdService{ID: 2})
+
+	r := make(chanResolver, 1)
+	r <- []resolver.Address{{Addr: addr1}, {Addr: addr2}}
+	cc, err := Dial("arith", WithResolver(r), WithSerializer(&Json{}))
+	if err != nil {
+		t.Fatal("dial error:", err)
+	}
+	defer cc.Close()
+
+	who := func() float64 {
+		reply := &jsonp.Response{}
+		err := cc.Call("Id.Who", &jsonp.Request{}, reply)
+		assert.Equal(t, nil, err)
+		return reply.C
+	}
+	count := map[float64]int{}
+	for i := 0; i < 4; i++ {
+		count[who()]++
+	}
+	assert.Equal(t, map[float64]int{1: 2, 2: 2}, count)
+
+	r <- []resolver.Address{{Addr: addr2}}
+	for len(cc.Backends()) != 1 {
+		time.Sleep(time.Millisecond)
+	}
+	for i := 0; i < 3; i++ {
+		assert.Equal(t, float64(2), who())
+	}
+
+	// a static target through the registered resolver
+	static, err := Dial("static://"+addr1, WithSerializer(&Json{}))
+	if err != nil {
+		t.Fatal("dial error:", err)
+	}
+	defer static.Close()
+	reply := &jsonp.Response{}
+	err = static.Call("Id.Who", &jsonp.Request{}, reply)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, float64(1), reply.C)
+
+	// no backend at all
+	r <- nil
+	for len(cc.Backends()) != 0 {
+		time.Sleep(time.Millisecond)
+	}
+	err = cc.Call("Id.Who", &jsonp.Request{}, reply)
+	assert.Equal(t, status.Unavailable, status.CodeOf(err))
+}
+
+// Test_ClusterClient_Dial tests that a backend whose dial hangs holds up no call to the others
+func Test_ClusterClient_Dial(t *testing.T) {
+	addr := serveJson(t, "Id", &IdService{ID: 1})
+	dialing := make(chan struct{})
+	dialer := func(ctx context.Context, addr string) (net.Conn, error) {
+		if addr == "blackhole" {
+			close(dialing)
+			<-ctx.Done()
+			return nil, ctx.Err()
+		}
+		return net.Dial("tcp", addr)
+	}
+	r := make(chanResolver, 1)
+	r <- []resolver.Address{{Addr: "blackhole"}, {Addr: addr}}
+	cc, err := Dial("arith", WithResolver(r), WithSerializer(&Json{}), WithDialer(dialer))
+	if err != nil {
+		t.Fatal("dial error:", err)
+	}
+
+	// calls until one waits for the dial of the black hole
+	hung := make(chan error, 1)
+	go func() {
+		for {
+			if err := cc.Call("Id.Who", &jsonp.Request{}, &jsonp.Response{}); err != nil {
+				hung <- err
+				return
+			}
+		}
+	}()
+	<-dialing
+	for i := 0; i < 3; i++ {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		reply := &jsonp.Response{}
+		err := cc.CallContext(ctx, "Id.Who", &jsonp.Request{}, reply)
+		cancel()
+		assert.Equal(t, nil, err)
+		assert.Equal(t, float64(1), reply.C)
+	}
+
+	// closing the client cancels the dial
+	assert.Equal(t, nil, cc.Close())
+	assert.Equal(t, status.Unavailable, status.CodeOf(<-hung))
+}
+
+// Test_ClusterClient_Balancer tests that calls carrying the same key stick to a backend
+func Test_ClusterClient_Balancer(t *testing.T) {
+	addr1 := serveJson(t, "Id", &IdService{ID: 1})
+	addr2 := serveJson(t, "Id", &IdService{ID: 2})
+
+	r := make(chanResolver, 1)
+	r <- []resolver.Address{{Addr: addr1}, {Addr: addr2}}
+	cc, err := Dial("arith", WithResolver(r), WithSerializer(&Json{}), WithBalancer(balancer.ConsistentHash("user")))
+	if err != nil {
+		t.Fatal("dial error:", err)
+	}
+	defer cc.Close()
+
+	owners := map[float64]bool{}
+	for i := 0; i < 20; i++ {
+		ctx := metadata.NewOutgoingContext(context.Background(), metadata.Pairs("user", fmt.Sprint("user", i)))
+		var first float64
+		for j := 0; j < 3; j++ {
+			reply := &jsonp.Response{}
+			err := cc.CallContext(ctx, "Id.Who", &jsonp.Request{}, reply)
+			assert.Equal(t, nil, err)
+			if j == 0 {
+				first = reply.C
+			}
+			assert.Equal(t, first, reply.C)
+		}
+		owners[first] = true
+	}
+	assert.Equal(t, 2, len(owners))
+}
+
+// hangingRegistry hangs in Register until released
+type hangingRegistry struct {
+	registry.Registry
+	release chan struct{}
+}
+
+func (r hangingRegistry) Register(ctx context.Context, inst registry.Instance) error {
+	select {
+	case <-r.release:
+		return r.Registry.Register(ctx, inst)
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Test_Server_RegistryHang tests that a server accepts connections while it registers its services
+func Test_Server_RegistryHang(t *testing.T) {
+	listen, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal("listen error:", err)
+	}
+	r := hangingRegistry{Registry: registry.NewMemory(), release: make(chan struct{})}
+	server := NewServer(WithSerializer(&Json{}), WithRegistry(r, ""))
+	err = server.RegisterName("Id", &IdService{ID: 7})
+	if err != nil {
+		t.Fatal("register error:", err)
+	}
+	go server.Serve(listen)
+	defer server.Close()
+
+	conn, err := net.Dial("tcp", listen.Addr().String())
+	if err != nil {
+		t.Fatal("dial error:", err)
+	}
+	client := NewClient(conn, WithSerializer(&Json{}))
+	defer client.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	reply := &jsonp.Response{}
+	assert.Equal(t, nil, client.CallContext(ctx, "Id.Who", &jsonp.Request{}, reply))
+	assert.Equal(t, float64(7), reply.C)
+
+	// registered once the registry answers
+	close(r.release)
+	watch, err := r.Watch(ctx, "Id")
+	if err != nil {
+		t.Fatal("watch error:", err)
+	}
+	for instances := range watch {
+		if len(instances) == 1 {
+			break
+		}
+	}
+	assert.Equal(t, nil, ctx.Err())
+}
+
+// Test_Server_Registry tests that a server registers its services in a remote registry while it serves
+func Test_Server_Registry(t *testing.T) {
+	listen, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal("listen error:", err)
+	}
+	service := registry.NewService(300 * time.Millisecond)
+	defer service.Close()
+	registryServer := NewServer(WithSerializer(serializer.Json))
+	err = registryServer.RegisterName(registry.ServiceName, service)
+	if err != nil {
+		t.Fatal("register error:", err)
+	}
+	go registryServer.Serve(listen)
+	defer registryServer.Close()
+
+	conn, err := net.Dial("tcp", listen.Addr().String())
+	if err != nil {
+		t.Fatal("dial error:", err)
+	}
+	registryClient := NewClient(conn, WithSerializer(serializer.Json))
+	defer registryClient.Close()
+
+	listen, err = net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal("listen error:", err)
+	}
+	server := NewServer(WithSerializer(&Json{}), WithRegistry(registry.NewRemote(registryClient, 300*time.Millisecond), ""))
+	err = server.RegisterName("Id", &IdService{ID: 7})
+	if err != nil {
+		t.Fatal("register error:", err)
+	}
+	go server.Serve(listen)
+
+	cc, err := Dial("Id", WithSerializer(&Json{}),
+		WithResolver(registry.NewResolver(registry.NewRemote(registryClient, 0))))
+	if err != nil {
+		t.Fatal("dial error:", err)
+	}
+	defer cc.Close()
+	for len(cc.Backends()) != 1 {
+		time.Sleep(time.Millisecond)
+	}
+	assert.Equal(t, listen.Addr().String(), cc.Backends()[0].Addr)
+
+	// heartbeats keep the registration beyond its TTL
+	time.Sleep(time.Second)
+	reply := &jsonp.Response{}
+	err = cc.Call("Id.Who", &jsonp.Request{}, reply)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, float64(7), reply.C)
+
+	// services registered while serving are registered too
+	err = server.RegisterName("Late", &IdService{ID: 8})
+	if err != nil {
+		t.Fatal("register error:", err)
+	}
+	late, err := Dial("Late", WithSerializer(&Json{}),
+		WithResolver(registry.NewResolver(registry.NewRemote(registryClient, 0))))
+	if err != nil {
+		t.Fatal("dial error:", err)
+	}
+	defer late.Close()
+	for len(late.Backends()) != 1 {
+		time.Sleep(time.Millisecond)
+	}
+	assert.Equal(t, listen.Addr().String(), late.Backends()[0].Addr)
+
+	assert.Equal(t, nil, server.Close())
+	for len(cc.Backends()) != 0 || len(late.Backends()) != 0 {
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// firstBalancer picks the first backend
+type firstBalancer struct{}
+
+func (firstBalancer) Pick(_ balancer.PickInfo, backends []balancer.Backend) (balancer.Backend, error) {
+	if len(backends) == 0 {
+		return nil, balancer.ErrNoBackend
+	}
+	return backends[0], nil
+}
+
+// Test_ClusterClient_Hedging tests that each attempt of a hedged call counts on, and drops, its own backend
+func Test_ClusterClient_Hedging(t *testing.T) {
+	canceled := make(chan struct{}, 1)
+	addr := serveJson(t, "Sleepy", &SleepyService{Sleep: time.Second, canceled: canceled})
+	// the alternate breaks the connections
+	listen, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal("listen error:", err)
+	}
+	defer listen.Close()
+	go func() {
+		for {
+			conn, err := listen.Accept()
+			if err != nil {
+				return
+			}
+			conn.Close()
+		}
+	}()
+
+	r := make(chanResolver, 1)
+	r <- []resolver.Address{{Addr: addr}, {Addr: listen.Addr().String()}}
+	cc, err := Dial("sleepy", WithResolver(r), WithSerializer(&Json{}), WithBalancer(firstBalancer{}),
+		WithHedging("Sleepy.Echo", HedgePolicy{Delay: 10 * time.Millisecond}))
+	if err != nil {
+		t.Fatal("dial error:", err)
+	}
+	defer cc.Close()
+
+	err = cc.Call("Sleepy.Echo", &jsonp.Request{A: 1}, &jsonp.Response{})
+	assert.Equal(t, true, broken(err))
+	// the first attempt lost
+	<-canceled
+	cc.mutex.Lock()
+	first, alternate := cc.backends[0], cc.backends[1]
+	cc.mutex.Unlock()
+	for first.InFlight() != 0 || alternate.InFlight() != 0 {
+		time.Sleep(time.Millisecond)
+	}
+	first.mutex.Lock()
+	assert.NotEqual(t, (*Client)(nil), first.client)
+	first.mutex.Unlock()
+	alternate.mutex.Lock()
+	assert.Equal(t, (*Client)(nil), alternate.client)
+	alternate.mutex.Unlock()
+}
+
+// Test_ClusterClient_HealthCheck tests that backends not serving are taken out of rotation
+func Test_ClusterClient_HealthCheck(t *testing.T) {
+	server1, addr1 := serveJsonServer(t, "Id", &IdService{ID: 1})
+	_, addr2 := serveJsonServer(t, "Id", &IdService{ID: 2})
+
+	r := make(chanResolver, 1)
+	r <- []resolver.Address{{Addr: addr1}, {Addr: addr2}}
+	cc, err := Dial("arith", WithResolver(r), WithSerializer(&Json{}), WithHealthCheck("Id"))
+	if err != nil {
+		t.Fatal("dial error:", err)
+	}
+	defer cc.Close()
+
+	// count returns the number of calls answered by each backend out of 20
+	count := func() map[float64]int {
+		count := map[float64]int{}
+		for i := 0; i < 20; i++ {
+			reply := &jsonp.Response{}
+			err := cc.Call("Id.Who", &jsonp.Request{}, reply)
+			assert.Equal(t, nil, err)
+			count[reply.C]++
+		}
+		return count
+	}
+	// eventually waits for the status changes to reach the client
+	eventually := func(expect map[float64]int) {
+		deadline := time.Now().Add(5 * time.Second)
+		for {
+			c := count()
+			if assert.ObjectsAreEqual(expect, c) || time.Now().After(deadline) {
+				assert.Equal(t, expect, c)
+				return
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+	eventually(map[float64]int{1: 10, 2: 10})
+
+	server1.Health().SetServingStatus("Id", health.ServingStatus_NOT_SERVING)
+	eventually(map[float64]int{2: 20})
+	server1.Health().SetServingStatus("Id", health.ServingStatus_SERVING)
+	eventually(map[float64]int{1: 10, 2: 10})
+
+	// the services of a closed server are not serving, even on open connections
+	assert.Equal(t, nil, server1.Close())
+	eventually(map[float64]int{2: 20})
+}
+
+// Test_ClusterClient_HealthCheckHang tests that a backend which never answers its health check
+// holds up no call to the others
+func Test_ClusterClient_HealthCheckHang(t *testing.T) {
+	_, addr := serveJsonServer(t, "Id", &IdService{ID: 1})
+	// accepts the connections, and never answers
+	silent, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal("listen error:", err)
+	}
+	defer silent.Close()
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		for {
+			conn, err := silent.Accept()
+			if err != nil {
+				return
+			}
+			accepted <- conn
+		}
+	}()
+
+	r := make(chanResolver, 1)
+	r <- []resolver.Address{{Addr: silent.Addr().String()}, {Addr: addr}}
+	cc, err := Dial("arith", WithResolver(r), WithSerializer(&Json{}), WithHealthCheck("Id"))
+	if err != nil {
+		t.Fatal("dial error:", err)
+	}
+	defer cc.Close()
+
+	// calls until one waits for the health check of the silent backend, until cc is closed
+	go func() {
+		for cc.Call("Id.Who", &jsonp.Request{}, &jsonp.Response{}) == nil {
+		}
+	}()
+	conn := <-accepted
+	defer conn.Close()
+	for i := 0; i < 3; i++ {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		reply := &jsonp.Response{}
+		err := cc.CallContext(ctx, "Id.Who", &jsonp.Request{}, reply)
+		cancel()
+		assert.Equal(t, nil, err)
+		assert.Equal(t, float64(1), reply.C)
+	}
+}
+
+// Test_Server_Reflection tests that the reflection service lists the services of the server
+func Test_Server_Reflection(t *testing.T) {
+	listen, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal("listen error:", err)
+	}
+	defer listen.Close()
+	server := NewServer(WithReflection())
+	err = server.Register(new(message.ArithService))
+	if err != nil {
+		t.Fatal("register error:", err)
+	}
+	// the names of the built-in services are reserved
+	assert.NotEqual(t, nil, server.RegisterName(health.ServiceName, new(message.ArithService)))
+	assert.NotEqual(t, nil, server.RegisterName(reflection.ServiceName, new(message.ArithService)))
+	go server.Serve(listen)
+
+	conn, err := net.Dial("tcp", listen.Addr().String())
+	if err != nil {
+		t.Fatal("dial error:", err)
+	}
+	client := NewClient(conn)
+	defer client.Close()
+
+	reply := &reflection.ListServicesResponse{}
+	err = client.Call("Reflection.ListServices", &reflection.ListServicesRequest{}, reply)
+	assert.Equal(t, nil, err)
+	var names []string
+	for _, s := range reply.Services {
+		names = append(names, s.Name)
+	}
+	assert.Equal(t, []string{"ArithService", "Health", "Reflection"}, names)
+	add := reply.Services[0].Methods[0]
+	assert.Equal(t, "Add", add.Name)
+	assert.Equal(t, "message.ArithRequest", add.ArgsProto)
+	assert.Equal(t, "message.ArithResponse", add.ReplyProto)
+
+	files := &reflection.FileResponse{}
+	err = client.Call("Reflection.FileContainingSymbol", &reflection.FileRequest{Symbol: add.ArgsProto}, files)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 1, len(files.FileDescriptors))
+}
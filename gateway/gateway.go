@@ -0,0 +1,164 @@
+// Package gateway exposes the methods of a TRPcG server to HTTP clients, each
+// as POST /Service/Method taking and returning the JSON mapping of its protobuf
+// messages. It needs no generated code: the messages are resolved through the
+// reflection service of the server (see TRPcG.WithReflection), the unknown
+// methods being remembered for reflection.MissTTL.
+package gateway
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/mizumoto-cn/TRPcG"
+	"github.com/mizumoto-cn/TRPcG/metadata"
+	"github.com/mizumoto-cn/TRPcG/reflection"
+	"github.com/mizumoto-cn/TRPcG/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+)
+
+// maxBodySize bounds the JSON requests
+const maxBodySize = 4 << 20
+
+// Gateway is an http.Handler forwarding the calls it receives to a TRPcG server
+type Gateway struct {
+	invoker TRPcG.Invoker
+	types   *reflection.Types
+	headers []string // forwarded as metadata, and back as HTTP headers
+}
+
+// Option configures a Gateway
+type Option func(g *Gateway)
+
+// WithHeaders forwards the HTTP headers named as request metadata, under
+// their lower case names, and the response metadata named as HTTP headers.
+// The other headers and response metadata are not forwarded.
+func WithHeaders(names ...string) Option {
+	return func(g *Gateway) {
+		g.headers = append(g.headers, names...)
+	}
+}
+
+// New returns a gateway calling the server of c, a *TRPcG.Client or a *TRPcG.ClusterClient
+func New(c TRPcG.Invoker, opts ...Option) *Gateway {
+	g := &Gateway{invoker: c, types: reflection.NewTypes(c)}
+	for _, opt := range opts {
+		opt(g)
+	}
+	return g
+}
+
+// ServeHTTP calls the method named by the path of r with the JSON body of r,
+// an empty body standing for the empty message. The response metadata named
+// by WithHeaders are sent back as HTTP headers, errors as {"code": ..., "message": ...}
+// with the HTTP status of their code.
+func (g *Gateway) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		writeError(w, http.StatusMethodNotAllowed, status.New(status.Unimplemented, "method "+r.Method+" not allowed"))
+		return
+	}
+	path := strings.TrimPrefix(r.URL.Path, "/")
+	slash := strings.LastIndex(path, "/")
+	if slash <= 0 || slash == len(path)-1 {
+		fail(w, status.Errorf(status.NotFound, "%s is not a /Service/Method path", r.URL.Path))
+		return
+	}
+	serviceMethod := path[:slash] + "." + path[slash+1:]
+
+	argsType, replyType, err := g.types.Method(r.Context(), serviceMethod)
+	if err != nil {
+		fail(w, err)
+		return
+	}
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxBodySize+1))
+	if err != nil {
+		fail(w, status.Errorf(status.InvalidArgument, "reading the request: %v", err))
+		return
+	}
+	if len(body) > maxBodySize {
+		writeError(w, http.StatusRequestEntityTooLarge,
+			status.New(status.InvalidArgument, fmt.Sprintf("request body larger than %d bytes", maxBodySize)))
+		return
+	}
+	args := argsType.New().Interface()
+	if len(body) > 0 {
+		if err = protojson.Unmarshal(body, args); err != nil {
+			fail(w, status.Errorf(status.InvalidArgument, "invalid %s: %v", args.ProtoReflect().Descriptor().FullName(), err))
+			return
+		}
+	}
+
+	md := metadata.MD{}
+	for _, name := range g.headers {
+		if v := r.Header.Get(name); v != "" {
+			md.Set(name, v)
+		}
+	}
+	ctx := metadata.NewOutgoingContext(r.Context(), md)
+	reply := replyType.New().Interface()
+	var header metadata.MD
+	err = g.invoker.CallContext(ctx, serviceMethod, args, reply, TRPcG.Header(&header))
+	for _, name := range g.headers {
+		if v := header.Get(name); v != "" {
+			w.Header().Set(name, v)
+		}
+	}
+	if err != nil {
+		fail(w, err)
+		return
+	}
+	data, err := protojson.MarshalOptions{EmitUnpopulated: true}.Marshal(reply.(proto.Message))
+	if err != nil {
+		fail(w, status.Errorf(status.Internal, "encoding the reply: %v", err))
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(data)
+}
+
+// fail writes err with the HTTP status of its code
+func fail(w http.ResponseWriter, err error) {
+	s := status.Convert(err)
+	writeError(w, HTTPStatus(s.Code()), s)
+}
+
+func writeError(w http.ResponseWriter, code int, s *status.Status) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(struct {
+		Code    string `json:"code"`
+		Message string `json:"message"`
+	}{s.Code().String(), s.Message()})
+}
+
+var httpStatus = [...]int{
+	status.OK:                 http.StatusOK,
+	status.Canceled:           499, // Client Closed Request, as nginx
+	status.Unknown:            http.StatusInternalServerError,
+	status.InvalidArgument:    http.StatusBadRequest,
+	status.DeadlineExceeded:   http.StatusGatewayTimeout,
+	status.NotFound:           http.StatusNotFound,
+	status.AlreadyExists:      http.StatusConflict,
+	status.PermissionDenied:   http.StatusForbidden,
+	status.ResourceExhausted:  http.StatusTooManyRequests,
+	status.FailedPrecondition: http.StatusBadRequest,
+	status.Aborted:            http.StatusConflict,
+	status.OutOfRange:         http.StatusBadRequest,
+	status.Unimplemented:      http.StatusNotImplemented,
+	status.Internal:           http.StatusInternalServerError,
+	status.Unavailable:        http.StatusServiceUnavailable,
+	status.DataLoss:           http.StatusInternalServerError,
+	status.Unauthenticated:    http.StatusUnauthorized,
+}
+
+// HTTPStatus returns the HTTP status of the responses failing with c
+func HTTPStatus(c status.Code) int {
+	if int(c) < len(httpStatus) {
+		return httpStatus[c]
+	}
+	return http.StatusInternalServerError
+}
@@ -0,0 +1,184 @@
+package gateway_test
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/rpc"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/mizumoto-cn/TRPcG"
+	"github.com/mizumoto-cn/TRPcG/gateway"
+	"github.com/mizumoto-cn/TRPcG/status"
+	jsonp "github.com/mizumoto-cn/TRPcG/testing/json"
+	message "github.com/mizumoto-cn/TRPcG/testing/message"
+	"github.com/stretchr/testify/assert"
+)
+
+// serve serves the arith service, and returns a gateway in front of it
+func serve(t *testing.T, opts ...gateway.Option) *httptest.Server {
+	server := TRPcG.NewServer(TRPcG.WithReflection(), TRPcG.WithInterceptor(func(info *TRPcG.CallInfo, _ any) error {
+		if user := info.Metadata.Get("x-user"); user != "" {
+			info.Header.Set("x-greeting", "hello "+user)
+			info.Header.Set("x-internal", "secret")
+		}
+		return nil
+	}))
+	server.RegisterName("ArithService", new(message.ArithService))
+	server.RegisterName("TestService", new(jsonp.TestService))
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal("listen error:", err)
+	}
+	go server.Serve(l)
+	t.Cleanup(func() { server.Close() })
+
+	conn, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal("dial error:", err)
+	}
+	client := TRPcG.NewClient(conn)
+	t.Cleanup(func() { client.Close() })
+	gw := httptest.NewServer(gateway.New(client, opts...))
+	t.Cleanup(gw.Close)
+	return gw
+}
+
+func post(t *testing.T, url string, body string, header http.Header) (*http.Response, string) {
+	req, _ := http.NewRequest(http.MethodPost, url, strings.NewReader(body))
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal("post error:", err)
+	}
+	defer resp.Body.Close()
+	data, _ := io.ReadAll(resp.Body)
+	return resp, string(data)
+}
+
+func TestGateway(t *testing.T) {
+	gw := serve(t, gateway.WithHeaders("X-User", "X-Greeting"))
+
+	resp, body := post(t, gw.URL+"/ArithService/Add", `{"a": 20, "b": 5}`, http.Header{"X-User": {"ann"}})
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "application/json", resp.Header.Get("Content-Type"))
+	assert.JSONEq(t, `{"c": 25}`, body)
+	assert.Equal(t, "hello ann", resp.Header.Get("X-Greeting"))
+	// the response metadata not named are not forwarded
+	assert.Equal(t, "", resp.Header.Get("X-Internal"))
+
+	resp, body = post(t, gw.URL+"/ArithService/Mul", "", nil)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.JSONEq(t, `{"c": 0}`, body)
+	assert.Equal(t, "", resp.Header.Get("X-Greeting"))
+
+	resp, body = post(t, gw.URL+"/ArithService/Div", `{"a": 1}`, nil)
+	assert.Equal(t, http.StatusInternalServerError, resp.StatusCode)
+	assert.JSONEq(t, `{"code": "Unknown", "message": "divided by zero"}`, body)
+}
+
+func TestGateway_Errors(t *testing.T) {
+	gw := serve(t)
+
+	for path, want := range map[string]int{
+		"/ArithService/Pow":   http.StatusNotFound,
+		"/Unknown/Add":        http.StatusNotFound,
+		"/ArithService":       http.StatusNotFound,
+		"/ArithService/":      http.StatusNotFound,
+		"/TestService/Add":    http.StatusNotImplemented,
+		"/Reflection/Unknown": http.StatusNotFound,
+	} {
+		resp, _ := post(t, gw.URL+path, "{}", nil)
+		assert.Equal(t, want, resp.StatusCode, path)
+	}
+
+	resp, body := post(t, gw.URL+"/ArithService/Add", `{"a": "one"}`, nil)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	assert.Contains(t, body, `"code":"InvalidArgument"`)
+
+	resp, body = post(t, gw.URL+"/ArithService/Add", `{"a": 1, "b": 2}`+strings.Repeat(" ", 4<<20), nil)
+	assert.Equal(t, http.StatusRequestEntityTooLarge, resp.StatusCode)
+	assert.Contains(t, body, `"code":"InvalidArgument"`)
+
+	resp, err := http.Get(gw.URL + "/ArithService/Add")
+	if err != nil {
+		t.Fatal("get error:", err)
+	}
+	resp.Body.Close()
+	assert.Equal(t, http.StatusMethodNotAllowed, resp.StatusCode)
+	assert.Equal(t, "POST", resp.Header.Get("Allow"))
+}
+
+// countingClient counts the calls to the reflection service
+type countingClient struct {
+	*TRPcG.Client
+	lookups int32
+}
+
+func (c *countingClient) AsyncCall(serviceMethod string, args any, reply any) chan *rpc.Call {
+	if strings.HasPrefix(serviceMethod, "Reflection.") {
+		atomic.AddInt32(&c.lookups, 1)
+	}
+	return c.Client.AsyncCall(serviceMethod, args, reply)
+}
+
+func TestGateway_Misses(t *testing.T) {
+	server := TRPcG.NewServer(TRPcG.WithReflection())
+	server.RegisterName("ArithService", new(message.ArithService))
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal("listen error:", err)
+	}
+	go server.Serve(l)
+	defer server.Close()
+	conn, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal("dial error:", err)
+	}
+	client := &countingClient{Client: TRPcG.NewClient(conn)}
+	defer client.Close()
+	gw := httptest.NewServer(gateway.New(client))
+	defer gw.Close()
+
+	// the failed lookups of unknown methods are remembered
+	for i := 0; i < 3; i++ {
+		resp, _ := post(t, gw.URL+"/ArithService/Pow", "{}", nil)
+		assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+	}
+	assert.Equal(t, int32(1), atomic.LoadInt32(&client.lookups))
+}
+
+func TestGateway_NoReflection(t *testing.T) {
+	server := TRPcG.NewServer()
+	server.RegisterName("ArithService", new(message.ArithService))
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal("listen error:", err)
+	}
+	go server.Serve(l)
+	defer server.Close()
+	conn, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal("dial error:", err)
+	}
+	client := TRPcG.NewClient(conn)
+	defer client.Close()
+	gw := httptest.NewServer(gateway.New(client))
+	defer gw.Close()
+
+	resp, body := post(t, gw.URL+"/ArithService/Add", "{}", nil)
+	assert.Equal(t, http.StatusNotImplemented, resp.StatusCode)
+	assert.Contains(t, body, "the server has no reflection service")
+}
+
+func TestHTTPStatus(t *testing.T) {
+	assert.Equal(t, http.StatusOK, gateway.HTTPStatus(status.OK))
+	assert.Equal(t, http.StatusTooManyRequests, gateway.HTTPStatus(status.ResourceExhausted))
+	assert.Equal(t, http.StatusUnauthorized, gateway.HTTPStatus(status.Unauthenticated))
+	assert.Equal(t, http.StatusInternalServerError, gateway.HTTPStatus(status.Code(100)))
+}
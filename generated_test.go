@@ -0,0 +1,195 @@
+package TRPcG_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/mizumoto-cn/TRPcG"
+	"github.com/mizumoto-cn/TRPcG/compressor"
+	"github.com/mizumoto-cn/TRPcG/jsonrpc"
+	"github.com/mizumoto-cn/TRPcG/metadata"
+	"github.com/mizumoto-cn/TRPcG/status"
+	"github.com/mizumoto-cn/TRPcG/testing/shop"
+	"github.com/mizumoto-cn/TRPcG/validate"
+	"github.com/stretchr/testify/assert"
+)
+
+// The code generated by protoc-gen-trpcg imports TRPcG, so its tests are external
+
+// serveOrders serves impl as the Orders service and returns a client of it
+func serveOrders(t *testing.T, impl shop.OrdersServer, opts ...TRPcG.Option) shop.OrdersClient {
+	listen, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal("listen error:", err)
+	}
+	t.Cleanup(func() { listen.Close() })
+	server := TRPcG.NewServer()
+	assert.Equal(t, nil, shop.RegisterOrdersServer(server, impl))
+	go server.Serve(listen)
+
+	conn, err := net.Dial("tcp", listen.Addr().String())
+	if err != nil {
+		t.Fatal("dial error:", err)
+	}
+	client := TRPcG.NewClient(conn, opts...)
+	t.Cleanup(func() { client.Close() })
+	return shop.NewOrdersClient(client)
+}
+
+// Test_Client_Generated tests the typed client generated by protoc-gen-trpcg
+func Test_Client_Generated(t *testing.T) {
+	c := serveOrders(t, &orders{}, TRPcG.WithCompress(compressor.Snappy))
+
+	var header metadata.MD
+	reply, err := c.Place(context.Background(), &shop.Order{CustomerId: 7}, TRPcG.Header(&header))
+	assert.Equal(t, nil, err)
+	assert.Equal(t, int64(7), reply.Order.CustomerId)
+
+	async := &shop.Receipt{}
+	call := <-c.PlaceAsync(&shop.Order{CustomerId: 8}, async)
+	assert.Equal(t, nil, call.Error)
+	assert.Equal(t, int64(8), async.Order.CustomerId)
+}
+
+// Test_Server_Generated tests the registration of the server interfaces generated by protoc-gen-trpcg
+func Test_Server_Generated(t *testing.T) {
+	c := serveOrders(t, shop.UnimplementedOrdersServer{})
+
+	reply, err := c.Place(context.Background(), &shop.Order{CustomerId: 7})
+	assert.Equal(t, status.Unimplemented, status.CodeOf(err))
+	assert.Equal(t, "method Place not implemented", status.Convert(err).Message())
+	assert.Equal(t, (*shop.Receipt)(nil), reply)
+}
+
+// orders places every order, counting them
+type orders struct {
+	shop.UnimplementedOrdersServer
+	placed int32
+}
+
+func (o *orders) Place(args *shop.Order, reply *shop.Receipt) error {
+	atomic.AddInt32(&o.placed, 1)
+	reply.Order = args
+	return nil
+}
+
+// Test_Server_Validation tests the rejection of the requests failing their rules
+func Test_Server_Validation(t *testing.T) {
+	listen, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal("listen error:", err)
+	}
+	defer listen.Close()
+	server := TRPcG.NewServer(TRPcG.WithValidation())
+	impl := &orders{}
+	assert.Equal(t, nil, shop.RegisterOrdersServer(server, impl))
+	go server.Serve(listen)
+
+	conn, err := net.Dial("tcp", listen.Addr().String())
+	if err != nil {
+		t.Fatal("dial error:", err)
+	}
+	client := TRPcG.NewClient(conn)
+	defer client.Close()
+	c := shop.NewOrdersClient(client)
+
+	order := &shop.Order{
+		Items:      []*shop.Order_Item{{Sku: "ABC-1", Quantity: 2}},
+		CustomerId: 7,
+		Coupon:     "SPRING",
+	}
+	reply, err := c.Place(context.Background(), order)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, int64(7), reply.Order.CustomerId)
+
+	var header metadata.MD
+	order.Items[0].Quantity = 100
+	order.Tags = []string{"gift", "Rush"}
+	_, err = c.Place(context.Background(), order, TRPcG.Header(&header))
+	assert.Equal(t, status.InvalidArgument, status.CodeOf(err))
+	assert.Equal(t, "items[0].quantity must be at most 99; tags[1] must match the pattern ^[a-z]+$",
+		status.Convert(err).Message())
+	assert.Equal(t, []validate.FieldViolation{
+		{Field: "items[0].quantity", Description: "must be at most 99"},
+		{Field: "tags[1]", Description: "must match the pattern ^[a-z]+$"},
+	}, validate.FromHeader(header))
+	assert.Equal(t, int32(1), atomic.LoadInt32(&impl.placed))
+}
+
+// Test_Server_JSONRPC tests the services served over JSON-RPC 2.0, on a listener and over HTTP
+func Test_Server_JSONRPC(t *testing.T) {
+	listen, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal("listen error:", err)
+	}
+	var users, auths []string
+	server := TRPcG.NewServer(TRPcG.WithInterceptor(func(info *TRPcG.CallInfo, _ any) error {
+		users = append(users, info.Metadata.Get("x-user"))
+		auths = append(auths, info.Metadata.Get("authorization"))
+		return nil
+	}), TRPcG.WithValidation())
+	defer server.Close()
+	assert.Equal(t, nil, shop.RegisterOrdersServer(server, &orders{}))
+	go server.ServeJSONRPC(listen)
+
+	conn, err := net.Dial("tcp", listen.Addr().String())
+	if err != nil {
+		t.Fatal("dial error:", err)
+	}
+	defer conn.Close()
+	fmt.Fprintln(conn, `{"jsonrpc": "2.0", "method": "Orders.Place", "params": {"customerId": 7, "items": [{"sku": "ABC-1", "quantity": 1}], "coupon": "SPRING"}, "id": 1}`)
+	var resp struct {
+		Result json.RawMessage `json:"result"`
+		Error  *jsonrpc.Error  `json:"error"`
+		ID     json.RawMessage `json:"id"`
+	}
+	dec := json.NewDecoder(conn)
+	assert.Equal(t, nil, dec.Decode(&resp))
+	assert.Equal(t, "1", string(resp.ID))
+	assert.Equal(t, (*jsonrpc.Error)(nil), resp.Error)
+	assert.Contains(t, string(resp.Result), `"customerId":"7"`)
+
+	fmt.Fprintln(conn, `{"jsonrpc": "2.0", "method": "Orders.Place", "params": {"customerId": 7, "coupon": "SPRING"}, "id": 2}`)
+	assert.Equal(t, nil, dec.Decode(&resp))
+	assert.Equal(t, jsonrpc.ErrorCode(status.InvalidArgument), resp.Error.Code)
+	assert.Equal(t, "items must have at least 1 items", resp.Error.Message)
+	assert.Equal(t, "InvalidArgument", resp.Error.Data.Status)
+	assert.Equal(t, []validate.FieldViolation{{Field: "items", Description: "must have at least 1 items"}},
+		validate.FromHeader(resp.Error.Data.Metadata))
+
+	gw := httptest.NewServer(server.JSONRPCHandler("X-User"))
+	defer gw.Close()
+	req, _ := http.NewRequest(http.MethodPost, gw.URL, strings.NewReader(`[
+		{"jsonrpc": "2.0", "method": "Orders.Place", "params": {"customerId": 7, "coupon": "SPRING"}, "id": 3},
+		{"jsonrpc": "2.0", "method": "Orders.Place", "params": {"customerId": 7, "coupon": "SPRING"}}
+	]`))
+	req.Header.Set("X-User", "ann")
+	req.Header.Set("Authorization", "Bearer secret")
+	httpResp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal("post error:", err)
+	}
+	var batch []map[string]any
+	assert.Equal(t, nil, json.NewDecoder(httpResp.Body).Decode(&batch))
+	httpResp.Body.Close()
+	assert.Equal(t, http.StatusOK, httpResp.StatusCode)
+	assert.Equal(t, 1, len(batch))
+	assert.Equal(t, float64(3), batch[0]["id"])
+	assert.Equal(t, []string{"", "", "ann", "ann"}, users)
+	// the headers not named are not forwarded
+	assert.Equal(t, []string{"", "", "", ""}, auths)
+
+	httpResp, err = http.Post(gw.URL, "application/json", strings.NewReader(`{"jsonrpc": "2.0", "method": "Orders.Place"}`))
+	if err != nil {
+		t.Fatal("post error:", err)
+	}
+	httpResp.Body.Close()
+	assert.Equal(t, http.StatusNoContent, httpResp.StatusCode)
+}
@@ -7,10 +7,10 @@ require google.golang.org/protobuf v1.28.0
 require (
 	github.com/golang/snappy v0.0.4
 	github.com/stretchr/testify v1.7.4
+	gopkg.in/yaml.v3 v3.0.1
 )
 
 require (
 	github.com/davecgh/go-spew v1.1.1 // indirect
 	github.com/pmezard/go-difflib v1.0.0 // indirect
-	gopkg.in/yaml.v3 v3.0.1 // indirect
 )
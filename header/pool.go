@@ -20,6 +20,7 @@ func (r *RequestHeader) ResetHeader() error {
 	r.Method = ""
 	r.CompressType = 0
 	r.RequestLen = 0
+	r.Meta = nil
 	return nil
 }
 
@@ -30,5 +31,6 @@ func (r *ResponseHeader) ResetHeader() error {
 	r.CompressType = 0
 	r.ResponseLen = 0
 	r.CheckSum = 0
+	r.Meta = nil
 	return nil
 }
@@ -3,6 +3,7 @@ package header
 import (
 	"encoding/binary"
 	"errors"
+	"sort"
 	"sync"
 
 	"github.com/mizumoto-cn/TRPcG/compressor"
@@ -32,6 +33,7 @@ type RequestHeader struct {
 	ID           uint64                  // ID of the request
 	RequestLen   uint32                  // Length of the request body
 	Checksum     uint32                  // CRC32 hashed value for checksum
+	Meta         map[string]string       // optional metadata, appended after Checksum only when not empty
 }
 
 // Marshal is somewhat a encoder
@@ -40,11 +42,11 @@ func (r *RequestHeader) Marshal() []byte {
 	r.RLock()
 	defer r.RUnlock()
 	itor := 0
-	// 2 + 10 * 3 + 4 + string length
-	header := make([]byte, MaxHeaderSize+len(r.Method))
+	// 2 + 10 * 3 + 4 + string length + metadata length
+	header := make([]byte, MaxHeaderSize+len(r.Method)+metaSize(r.Meta))
 
-	// | CompressType |      Method    |    ID    | RequestLen | Checksum |
-	// |    uint16    | uvarint+string |  uvarint |   uvarint  |  uint32  |
+	// | CompressType |      Method    |    ID    | RequestLen | Checksum |     Meta (optional)     |
+	// |    uint16    | uvarint+string |  uvarint |   uvarint  |  uint32  | uvarint+(string,string)* |
 	// write uint16 compressType
 	// LittleEndian PutType functions encode Type into buf and returns the number of bytes written
 	// Here it writes uint16 type info into header
@@ -60,6 +62,9 @@ func (r *RequestHeader) Marshal() []byte {
 	binary.LittleEndian.PutUint32(header[itor:], r.Checksum)
 	itor += Uint32Size
 
+	// Metadata goes last so that peers unaware of it simply ignore the trailing bytes
+	itor += writeMeta(header[itor:], r.Meta)
+
 	return header[:itor]
 }
 
@@ -90,6 +95,9 @@ func (r *RequestHeader) UnMarshal(data []byte) (err error) {
 	itor += size
 
 	r.Checksum = binary.LittleEndian.Uint32(data[itor:])
+	itor += Uint32Size
+
+	r.Meta, err = readMeta(data[itor:])
 
 	return
 }
@@ -114,6 +122,66 @@ func readString(data []byte) (string, int) {
 	return str, itor
 }
 
+// metaSize returns the maximum number of bytes writeMeta needs for meta
+func metaSize(meta map[string]string) int {
+	if len(meta) == 0 {
+		return 0
+	}
+	size := binary.MaxVarintLen64
+	for k, v := range meta {
+		size += 2*binary.MaxVarintLen64 + len(k) + len(v)
+	}
+	return size
+}
+
+// writeMeta writes the pair count followed by the key/value strings sorted by key.
+// Nothing is written for empty metadata.
+func writeMeta(data []byte, meta map[string]string) int {
+	if len(meta) == 0 {
+		return 0
+	}
+	keys := make([]string, 0, len(meta))
+	for k := range meta {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	itor := binary.PutUvarint(data, uint64(len(keys)))
+	for _, k := range keys {
+		itor += writeString(data[itor:], k)
+		itor += writeString(data[itor:], meta[k])
+	}
+	return itor
+}
+
+// readMeta reads the metadata written by writeMeta, a nil map is returned when data is empty
+func readMeta(data []byte) (map[string]string, error) {
+	if len(data) == 0 {
+		return nil, nil
+	}
+	itor := 0
+	count, size := binary.Uvarint(data)
+	if size <= 0 {
+		return nil, ErrUnmarshalFail
+	}
+	itor += size
+	meta := make(map[string]string)
+	for i := uint64(0); i < count; i++ {
+		var kv [2]string
+		for j := range kv {
+			// a truncated string would otherwise be read as ""
+			length, n := binary.Uvarint(data[itor:])
+			if n <= 0 || uint64(len(data)-itor-n) < length {
+				return nil, ErrUnmarshalFail
+			}
+			kv[j], size = readString(data[itor:])
+			itor += size
+		}
+		meta[kv[0]] = kv[1]
+	}
+	return meta, nil
+}
+
 func (r *RequestHeader) GetCompressType() compressor.CompressType {
 	r.RLock()
 	defer r.RUnlock()
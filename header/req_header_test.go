@@ -91,3 +91,26 @@ func TestRequestHeader_GetCompressType(t *testing.T) {
 	}
 	assert.Equal(t, compressor.CompressType(compressor.Raw), header.GetCompressType())
 }
+
+// TestRequestHeader_Meta tests the metadata trailer of RequestHeader
+func TestRequestHeader_Meta(t *testing.T) {
+	header := &RequestHeader{
+		CompressType: compressor.Gzip,
+		Method:       "Add",
+		ID:           12345,
+		RequestLen:   123,
+		Checksum:     12345,
+		Meta:         map[string]string{"b": "2", "a": "1"},
+	}
+	data := header.Marshal()
+	assert.Equal(t, []byte{0x1, 0x0, 0x3, 0x41, 0x64, 0x64, 0xb9, 0x60, 0x7b, 0x39, 0x30, 0x0, 0x0,
+		0x2, 0x1, 0x61, 0x1, 0x31, 0x1, 0x62, 0x1, 0x32}, data)
+
+	h := &RequestHeader{}
+	assert.Equal(t, nil, h.UnMarshal(data))
+	assert.Equal(t, true, reflect.DeepEqual(header, h))
+
+	// a truncated trailer must not be accepted
+	h = &RequestHeader{}
+	assert.Equal(t, ErrUnmarshalFail, h.UnMarshal(data[:len(data)-2]))
+}
@@ -20,8 +20,8 @@ import (
 
 // type CompressType uint16
 
-// | CompressType |    ID   |      Error     | ResponseLen | CheckSum |
-// |    uint16    | uvarint | uvarint+string |    uvarint  |  uint32  |
+// | CompressType |    ID   |      Error     | ResponseLen | CheckSum |     Meta (optional)     |
+// |    uint16    | uvarint | uvarint+string |    uvarint  |  uint32  | uvarint+(string,string)* |
 type ResponseHeader struct {
 	sync.RWMutex
 	CompressType compressor.CompressType // uint16
@@ -29,6 +29,7 @@ type ResponseHeader struct {
 	Error        string                  // error info
 	ResponseLen  uint32                  // Length of the response body
 	CheckSum     uint32                  // for check
+	Meta         map[string]string       // optional metadata sent back to the client
 }
 
 // Marshal() encode response header into byte slice
@@ -36,8 +37,8 @@ func (r *ResponseHeader) Marshal() []byte {
 	r.RLock()
 	defer r.RUnlock()
 	itor := 0
-	// 36 + errstr length
-	header := make([]byte, MaxHeaderSize+len(r.Error))
+	// 36 + errstr length + metadata length
+	header := make([]byte, MaxHeaderSize+len(r.Error)+metaSize(r.Meta))
 	// putin cType
 	binary.LittleEndian.PutUint16(header[itor:], uint16(r.CompressType))
 	itor += Uint16Size
@@ -48,6 +49,8 @@ func (r *ResponseHeader) Marshal() []byte {
 	// putin checksum
 	binary.LittleEndian.PutUint32(header[itor:], r.CheckSum)
 	itor += Uint32Size
+	// putin metadata, if any
+	itor += writeMeta(header[itor:], r.Meta)
 	return header[:itor]
 }
 
@@ -79,6 +82,9 @@ func (r *ResponseHeader) Unmarshal(data []byte) (err error) {
 	itor += size
 
 	r.CheckSum = binary.LittleEndian.Uint32(data[itor:])
+	itor += Uint32Size
+
+	r.Meta, err = readMeta(data[itor:])
 	return
 }
 
@@ -91,3 +91,22 @@ func TestResponseHeader_GetCompressType(t *testing.T) {
 	}
 	assert.Equal(t, true, reflect.DeepEqual(compressor.Raw, header.GetCompressType()))
 }
+
+// TestResponseHeader_Meta tests the metadata trailer of ResponseHeader
+func TestResponseHeader_Meta(t *testing.T) {
+	header := &ResponseHeader{
+		CompressType: compressor.Raw,
+		Error:        "error",
+		ID:           12345,
+		ResponseLen:  123,
+		CheckSum:     12345,
+		Meta:         map[string]string{"k": "v"},
+	}
+	data := header.Marshal()
+	assert.Equal(t, []byte{0x0, 0x0, 0xb9, 0x60, 0x5, 0x65, 0x72, 0x72, 0x6f,
+		0x72, 0x7b, 0x39, 0x30, 0x0, 0x0, 0x1, 0x1, 0x6b, 0x1, 0x76}, data)
+
+	h := &ResponseHeader{}
+	assert.Equal(t, nil, h.Unmarshal(data))
+	assert.Equal(t, true, reflect.DeepEqual(header, h))
+}
@@ -0,0 +1,50 @@
+package health
+
+import (
+	"context"
+	"net/rpc"
+	"time"
+)
+
+// Caller sends calls to a Health service, such as a TRPcG.Client
+type Caller interface {
+	AsyncCall(serviceMethod string, args any, reply any) chan *rpc.Call
+}
+
+// watchWait is the longest wait of the calls of Watch
+const watchWait = 30 * time.Second
+
+func call(ctx context.Context, c Caller, method string, args any, reply any) error {
+	select {
+	case call := <-c.AsyncCall(ServiceName+"."+method, args, reply):
+		return call.Error
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Check returns the status of service
+func Check(ctx context.Context, c Caller, service string) (ServingStatus, error) {
+	reply := &HealthCheckResponse{}
+	if err := call(ctx, c, "Check", &HealthCheckRequest{Service: service}, reply); err != nil {
+		return ServingStatus_UNKNOWN, err
+	}
+	return reply.Status, nil
+}
+
+// Watch calls update with the status of service, then again each time it changes.
+// It returns ctx.Err() once ctx is done, or the error of a failed call.
+func Watch(ctx context.Context, c Caller, service string, update func(ServingStatus)) error {
+	var revision uint64
+	for {
+		reply := &HealthWatchResponse{}
+		args := &HealthWatchRequest{Service: service, Revision: revision, WaitMs: watchWait.Milliseconds()}
+		if err := call(ctx, c, "Watch", args, reply); err != nil {
+			return err
+		}
+		if reply.Revision != revision {
+			revision = reply.Revision
+			update(reply.Status)
+		}
+	}
+}
@@ -0,0 +1,149 @@
+package health
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/rpc"
+	"testing"
+	"time"
+
+	"github.com/mizumoto-cn/TRPcG/status"
+	"github.com/stretchr/testify/assert"
+)
+
+// localCaller calls the methods of a health server in process
+type localCaller struct {
+	server *Server
+}
+
+func (c localCaller) AsyncCall(serviceMethod string, args any, reply any) chan *rpc.Call {
+	done := make(chan *rpc.Call, 1)
+	go func() {
+		call := &rpc.Call{ServiceMethod: serviceMethod, Args: args, Reply: reply, Done: done}
+		switch serviceMethod {
+		case "Health.Check":
+			call.Error = c.server.Check(args.(*HealthCheckRequest), reply.(*HealthCheckResponse))
+		case "Health.Watch":
+			call.Error = c.server.Watch(args.(*HealthWatchRequest), reply.(*HealthWatchResponse))
+		}
+		done <- call
+	}()
+	return done
+}
+
+func TestServer_Check(t *testing.T) {
+	s := NewServer()
+	c := localCaller{s}
+	ctx := context.Background()
+
+	st, err := Check(ctx, c, "")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, ServingStatus_SERVING, st)
+
+	_, err = Check(ctx, c, "Arith")
+	assert.Equal(t, status.NotFound, status.CodeOf(err))
+
+	s.SetServingStatus("Arith", ServingStatus_NOT_SERVING)
+	st, err = Check(ctx, c, "Arith")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, ServingStatus_NOT_SERVING, st)
+
+	// shutdown flips every service, and holds until resumed
+	s.SetServingStatus("Arith", ServingStatus_SERVING)
+	s.Shutdown()
+	s.SetServingStatus("Arith", ServingStatus_SERVING)
+	for _, service := range []string{"", "Arith"} {
+		st, _ = Check(ctx, c, service)
+		assert.Equal(t, ServingStatus_NOT_SERVING, st)
+	}
+	s.Resume()
+	for _, service := range []string{"", "Arith"} {
+		st, _ = Check(ctx, c, service)
+		assert.Equal(t, ServingStatus_SERVING, st)
+	}
+}
+
+func TestWatch(t *testing.T) {
+	s := NewServer()
+	ctx, cancel := context.WithCancel(context.Background())
+	updates := make(chan ServingStatus, 10)
+	done := make(chan error, 1)
+	go func() {
+		done <- Watch(ctx, localCaller{s}, "Arith", func(st ServingStatus) { updates <- st })
+	}()
+	next := func() ServingStatus {
+		select {
+		case st := <-updates:
+			return st
+		case <-time.After(5 * time.Second):
+			t.Fatal("no status update")
+			return ServingStatus_UNKNOWN
+		}
+	}
+
+	assert.Equal(t, ServingStatus_SERVICE_UNKNOWN, next())
+	s.SetServingStatus("Arith", ServingStatus_SERVING)
+	assert.Equal(t, ServingStatus_SERVING, next())
+	// setting the same status is not a change
+	s.SetServingStatus("Arith", ServingStatus_SERVING)
+	s.Shutdown()
+	assert.Equal(t, ServingStatus_NOT_SERVING, next())
+
+	cancel()
+	assert.Equal(t, context.Canceled, <-done)
+	assert.Equal(t, 0, len(updates))
+}
+
+func TestServer_Unknown(t *testing.T) {
+	s := NewServer()
+	reply := &HealthCheckResponse{}
+	assert.Equal(t, status.NotFound, status.CodeOf(s.Check(&HealthCheckRequest{Service: "Arith"}, reply)))
+	assert.Equal(t, 1, len(s.services))
+
+	// an unknown service is kept while watched
+	done := make(chan *HealthWatchResponse, 1)
+	go func() {
+		reply := &HealthWatchResponse{}
+		s.Watch(&HealthWatchRequest{Service: "Arith", Revision: 1, WaitMs: 1 << 62}, reply)
+		done <- reply
+	}()
+	for {
+		s.mutex.Lock()
+		n := len(s.services)
+		s.mutex.Unlock()
+		if n == 2 {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	s.SetServingStatus("Arith", ServingStatus_SERVING)
+	assert.Equal(t, ServingStatus_SERVING, (<-done).Status)
+
+	watch := &HealthWatchResponse{}
+	assert.Equal(t, nil, s.Watch(&HealthWatchRequest{Service: "Sort", WaitMs: 1}, watch))
+	assert.Equal(t, ServingStatus_SERVICE_UNKNOWN, watch.Status)
+	assert.Equal(t, nil, s.Watch(&HealthWatchRequest{Service: "Sort", Revision: watch.Revision, WaitMs: 1}, watch))
+	assert.Equal(t, 2, len(s.services))
+}
+
+func TestHandler(t *testing.T) {
+	s := NewServer()
+	s.SetServingStatus("Arith", ServingStatus_NOT_SERVING)
+	h := Handler(s)
+	probe := func(method, target string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(method, target, nil))
+		return w
+	}
+
+	w := probe(http.MethodGet, "/healthz")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "SERVING\n", w.Body.String())
+	assert.Equal(t, http.StatusServiceUnavailable, probe(http.MethodGet, "/healthz?service=Arith").Code)
+	assert.Equal(t, http.StatusNotFound, probe(http.MethodGet, "/healthz?service=Unknown").Code)
+	assert.Equal(t, http.StatusMethodNotAllowed, probe(http.MethodPost, "/healthz").Code)
+
+	s.Shutdown()
+	assert.Equal(t, http.StatusServiceUnavailable, probe(http.MethodHead, "/healthz").Code)
+}
@@ -0,0 +1,32 @@
+package health
+
+import (
+	"net/http"
+
+	"github.com/mizumoto-cn/TRPcG/status"
+)
+
+// Handler returns an http.Handler answering the health probes of orchestrators with
+// the status of s: 200 for SERVING, 503 otherwise, and 404 for unknown services.
+// The service is the one of the query parameter "service", the whole server if none.
+func Handler(s *Server) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			w.Header().Set("Allow", "GET, HEAD")
+			http.Error(w, "method "+r.Method+" not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		reply := &HealthCheckResponse{}
+		if err := s.Check(&HealthCheckRequest{Service: r.URL.Query().Get("service")}, reply); err != nil {
+			http.Error(w, status.Convert(err).Message(), http.StatusNotFound)
+			return
+		}
+		code := http.StatusOK
+		if reply.Status != ServingStatus_SERVING {
+			code = http.StatusServiceUnavailable
+		}
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.WriteHeader(code)
+		w.Write([]byte(reply.Status.String() + "\n"))
+	})
+}
@@ -0,0 +1,150 @@
+package health
+
+import (
+	"sync"
+	"time"
+
+	"github.com/mizumoto-cn/TRPcG/status"
+)
+
+// ServiceName is the name Server is registered under
+const ServiceName = "Health"
+
+// Server implements the Health service, which every TRPcG.Server registers.
+// The status of the whole server is the one of the "" service.
+type Server struct {
+	mutex    sync.Mutex
+	services map[string]*entry
+	shutdown bool
+}
+
+// maxWait is the longest wait of a call of Watch
+const maxWait = 30 * time.Second
+
+// entry holds the status of a service
+type entry struct {
+	status   ServingStatus
+	known    bool          // false until the status is set
+	revision uint64        // incremented on each change
+	changed  chan struct{} // closed on the next change
+	watchers int           // calls of Watch waiting, which keep an unknown service
+}
+
+// NewServer returns a health server reporting the whole server as SERVING
+func NewServer() *Server {
+	s := &Server{services: make(map[string]*entry)}
+	s.SetServingStatus("", ServingStatus_SERVING)
+	return s
+}
+
+// get returns the entry of service, creating it if needed. The mutex must be held.
+func (s *Server) get(service string) *entry {
+	e, ok := s.services[service]
+	if !ok {
+		e = &entry{changed: make(chan struct{})}
+		s.services[service] = e
+	}
+	return e
+}
+
+// set changes the status of e. The mutex must be held.
+func (e *entry) set(st ServingStatus) {
+	if e.known && e.status == st {
+		return
+	}
+	e.status, e.known = st, true
+	e.revision++
+	close(e.changed)
+	e.changed = make(chan struct{})
+}
+
+// SetServingStatus sets the status of service, it is ignored after Shutdown
+func (s *Server) SetServingStatus(service string, st ServingStatus) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.shutdown {
+		return
+	}
+	s.get(service).set(st)
+}
+
+// Shutdown sets every service NOT_SERVING and ignores further status changes
+// until Resume, so that clients stop sending calls before the server stops
+func (s *Server) Shutdown() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.shutdown = true
+	for _, e := range s.services {
+		if e.known {
+			e.set(ServingStatus_NOT_SERVING)
+		}
+	}
+}
+
+// Resume sets every service SERVING and accepts status changes again
+func (s *Server) Resume() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.shutdown = false
+	for _, e := range s.services {
+		if e.known {
+			e.set(ServingStatus_SERVING)
+		}
+	}
+}
+
+// Check replies the status of a service, it fails with NotFound for unknown services
+func (s *Server) Check(args *HealthCheckRequest, reply *HealthCheckResponse) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	e, ok := s.services[args.Service]
+	if !ok || !e.known {
+		return status.Errorf(status.NotFound, "unknown service %q", args.Service)
+	}
+	reply.Status = e.status
+	return nil
+}
+
+// Watch replies the status of a service once its revision differs from the one
+// of the caller, or once the wait is over. Unknown services are SERVICE_UNKNOWN.
+func (s *Server) Watch(args *HealthWatchRequest, reply *HealthWatchResponse) error {
+	wait := maxWait
+	if args.WaitMs > 0 && args.WaitMs < maxWait.Milliseconds() {
+		wait = time.Duration(args.WaitMs) * time.Millisecond
+	}
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+
+	s.mutex.Lock()
+	e := s.get(args.Service)
+	e.watchers++
+	s.mutex.Unlock()
+	defer func() {
+		s.mutex.Lock()
+		defer s.mutex.Unlock()
+		// unknown services are only kept while watched
+		if e.watchers--; e.watchers == 0 && !e.known {
+			delete(s.services, args.Service)
+		}
+	}()
+	for {
+		s.mutex.Lock()
+		st, changed := e.status, e.changed
+		if !e.known {
+			st = ServingStatus_SERVICE_UNKNOWN
+		}
+		// revision 0 stands for no known status
+		revision := e.revision + 1
+		s.mutex.Unlock()
+		if revision != args.Revision {
+			reply.Status, reply.Revision = st, revision
+			return nil
+		}
+		select {
+		case <-changed:
+		case <-timer.C:
+			reply.Status, reply.Revision = st, revision
+			return nil
+		}
+	}
+}
@@ -0,0 +1,123 @@
+package inspect
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// JSON decodes the bodies of the JSON serializer
+func JSON(method string, response bool, body []byte) (string, error) {
+	if len(body) == 0 {
+		return "", nil
+	}
+	var out bytes.Buffer
+	if err := json.Indent(&out, body, "", "  "); err != nil {
+		return "", err
+	}
+	return out.String(), nil
+}
+
+// Proto decodes the bodies of the protobuf serializer as JSON, with the message
+// types returned by types for a method. The fields of the methods without types
+// are listed by number, as Wire does.
+func Proto(types func(method string) (args, reply protoreflect.MessageType)) Decoder {
+	return func(method string, response bool, body []byte) (string, error) {
+		args, reply := types(method)
+		t := args
+		if response {
+			t = reply
+		}
+		if t == nil {
+			return Wire(method, response, body)
+		}
+		m := t.New().Interface()
+		if err := proto.Unmarshal(body, m); err != nil {
+			return "", err
+		}
+		// protojson randomizes its spacing, Indent lays it out again
+		data, err := protojson.MarshalOptions{EmitUnpopulated: true}.Marshal(m)
+		if err != nil {
+			return "", err
+		}
+		return JSON(method, response, data)
+	}
+}
+
+// Wire lists the fields of protobuf bodies by number, without their types
+func Wire(method string, response bool, body []byte) (string, error) {
+	var b strings.Builder
+	if err := wireFields(&b, body, ""); err != nil {
+		return "", err
+	}
+	return b.String(), nil
+}
+
+func wireFields(b *strings.Builder, data []byte, indent string) error {
+	for len(data) > 0 {
+		num, typ, n := protowire.ConsumeTag(data)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		data = data[n:]
+		switch typ {
+		case protowire.VarintType:
+			v, n := protowire.ConsumeVarint(data)
+			if n < 0 {
+				return protowire.ParseError(n)
+			}
+			fmt.Fprintf(b, "%s%d: %d\n", indent, num, v)
+			data = data[n:]
+		case protowire.Fixed32Type:
+			v, n := protowire.ConsumeFixed32(data)
+			if n < 0 {
+				return protowire.ParseError(n)
+			}
+			fmt.Fprintf(b, "%s%d: 0x%08x\n", indent, num, v)
+			data = data[n:]
+		case protowire.Fixed64Type:
+			v, n := protowire.ConsumeFixed64(data)
+			if n < 0 {
+				return protowire.ParseError(n)
+			}
+			fmt.Fprintf(b, "%s%d: 0x%016x\n", indent, num, v)
+			data = data[n:]
+		case protowire.BytesType:
+			v, n := protowire.ConsumeBytes(data)
+			if n < 0 {
+				return protowire.ParseError(n)
+			}
+			data = data[n:]
+			// a string if printable, a nested message if it parses as one
+			var nested strings.Builder
+			if !printable(v) && wireFields(&nested, v, indent+"  ") == nil {
+				fmt.Fprintf(b, "%s%d: {\n%s%s}\n", indent, num, nested.String(), indent)
+			} else {
+				fmt.Fprintf(b, "%s%d: %q\n", indent, num, v)
+			}
+		default:
+			return fmt.Errorf("unsupported wire type %d", typ)
+		}
+	}
+	return nil
+}
+
+func printable(data []byte) bool {
+	if !utf8.Valid(data) {
+		return false
+	}
+	for _, r := range string(data) {
+		if !unicode.IsPrint(r) && !unicode.IsSpace(r) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,274 @@
+// Package inspect decodes the TRPcG wire protocol. Proxy sits transparently
+// between clients and a server, decoding every request and response it forwards
+// into an Event, which Print shows and Recorder writes to a file. Replayer sends
+// the requests of such a recording again, comparing the responses.
+package inspect
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/mizumoto-cn/TRPcG/codec"
+	"github.com/mizumoto-cn/TRPcG/compressor"
+)
+
+// Event is a request or a response seen on the wire
+type Event struct {
+	Time         time.Time               `json:"time"`
+	Conn         uint64                  `json:"conn"` // number of the connection, from 1
+	Response     bool                    `json:"response,omitempty"`
+	ID           uint64                  `json:"id"`
+	Method       string                  `json:"method"` // of the request, or of the request answered
+	CompressType compressor.CompressType `json:"compress_type"`
+	Checksum     uint32                  `json:"checksum"`
+	Meta         map[string]string       `json:"meta,omitempty"`
+	Error        string                  `json:"error,omitempty"` // of a response
+	Body         []byte                  `json:"body"`            // as on the wire, compressed
+	Decoded      string                  `json:"decoded,omitempty"`
+	DecodeError  string                  `json:"decode_error,omitempty"`
+}
+
+// Decoder pretty prints the decompressed body of a request or a response of method
+type Decoder func(method string, response bool, body []byte) (string, error)
+
+// Proxy forwards the connections it accepts to Target unchanged, calling
+// OnEvent with every request and response decoded on the way
+type Proxy struct {
+	Target  string
+	Decode  Decoder      // nil leaves the bodies undecoded
+	OnEvent func(*Event) // called from one goroutine at a time
+	Dial    func(network, addr string) (net.Conn, error)
+
+	mutex  sync.Mutex // of OnEvent
+	conns  uint64
+	open   sync.Map // of the client connections
+	active sync.WaitGroup
+}
+
+// Serve accepts connections on l until it is closed, then waits for the
+// connections accepted to end
+func (p *Proxy) Serve(l net.Listener) error {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			p.active.Wait()
+			return err
+		}
+		p.active.Add(1)
+		p.open.Store(conn, struct{}{})
+		go func() {
+			p.serveConn(conn)
+			p.open.Delete(conn)
+			p.active.Done()
+		}()
+	}
+}
+
+// Close closes the connections being proxied
+func (p *Proxy) Close() {
+	p.open.Range(func(conn, _ any) bool {
+		conn.(net.Conn).Close()
+		return true
+	})
+}
+
+func (p *Proxy) serveConn(client net.Conn) {
+	defer client.Close()
+	dial := p.Dial
+	if dial == nil {
+		dial = net.Dial
+	}
+	server, err := dial("tcp", p.Target)
+	if err != nil {
+		return
+	}
+	defer server.Close()
+	conn := atomic.AddUint64(&p.conns, 1)
+
+	// the methods of the requests in flight, to name their responses
+	var mutex sync.Mutex
+	methods := make(map[uint64]string)
+
+	done := make(chan struct{}, 2)
+	go func() {
+		handshake := false
+		p.forward(server, client, func(r *bufio.Reader) (*Event, error) {
+			// the magic is forwarded with the first request
+			if !handshake {
+				handshake = true
+				if err := codec.SkipMagic(r); err != nil {
+					return nil, err
+				}
+			}
+			h, body, err := codec.ReadRequest(r)
+			if err != nil {
+				return nil, err
+			}
+			mutex.Lock()
+			methods[h.ID] = h.Method
+			mutex.Unlock()
+			return &Event{Conn: conn, ID: h.ID, Method: h.Method, CompressType: h.CompressType,
+				Checksum: h.Checksum, Meta: h.Meta, Body: body}, nil
+		})
+		done <- struct{}{}
+	}()
+	go func() {
+		p.forward(client, server, func(r *bufio.Reader) (*Event, error) {
+			h, body, err := codec.ReadResponse(r)
+			if err != nil {
+				return nil, err
+			}
+			mutex.Lock()
+			method := methods[h.ID]
+			delete(methods, h.ID)
+			mutex.Unlock()
+			return &Event{Conn: conn, Response: true, ID: h.ID, Method: method, CompressType: h.CompressType,
+				Checksum: h.CheckSum, Meta: h.Meta, Error: h.Error, Body: body}, nil
+		})
+		done <- struct{}{}
+	}()
+	// either side closing ends the connection
+	<-done
+	client.Close()
+	server.Close()
+	<-done
+}
+
+// forward copies src to dst as is, decoding the stream with next. Each frame is
+// decoded and its event emitted before it is forwarded, so a request is known
+// before its response comes back.
+func (p *Proxy) forward(dst io.WriteCloser, src io.Reader, next func(*bufio.Reader) (*Event, error)) {
+	defer dst.Close()
+	raw := &keepReader{r: src}
+	r := bufio.NewReader(raw)
+	for {
+		e, err := next(r)
+		if err != nil {
+			// not TRPcG, or cut short: keep forwarding undecoded, from the bytes read
+			if _, err = dst.Write(raw.kept); err == nil {
+				io.Copy(dst, src)
+			}
+			return
+		}
+		e.Time = time.Now()
+		p.emit(e)
+		// the bytes of the frame, but the ones read ahead
+		n := len(raw.kept) - r.Buffered()
+		if _, err = dst.Write(raw.kept[:n]); err != nil {
+			return
+		}
+		raw.kept = append(raw.kept[:0], raw.kept[n:]...)
+	}
+}
+
+// keepReader keeps the bytes read from r until they are forwarded
+type keepReader struct {
+	r    io.Reader
+	kept []byte
+}
+
+func (k *keepReader) Read(b []byte) (int, error) {
+	n, err := k.r.Read(b)
+	k.kept = append(k.kept, b[:n]...)
+	return n, err
+}
+
+func (p *Proxy) emit(e *Event) {
+	if p.Decode != nil {
+		decode(p.Decode, e)
+	}
+	if p.OnEvent != nil {
+		p.mutex.Lock()
+		p.OnEvent(e)
+		p.mutex.Unlock()
+	}
+}
+
+// decode sets the decoded body of e, or the error decoding it
+func decode(d Decoder, e *Event) {
+	body, err := codec.Decompress(e.CompressType, e.Checksum, e.Body)
+	if err == nil {
+		e.Decoded, err = d(e.Method, e.Response, body)
+	}
+	e.DecodeError = ""
+	if err != nil {
+		e.DecodeError = err.Error()
+	}
+}
+
+var compressorNames = map[compressor.CompressType]string{
+	compressor.Raw:    "raw",
+	compressor.Gzip:   "gzip",
+	compressor.Snappy: "snappy",
+	compressor.Zlib:   "zlib",
+}
+
+// Print writes an event for people to read
+func Print(w io.Writer, e *Event) {
+	arrow := ">"
+	if e.Response {
+		arrow = "<"
+	}
+	name, ok := compressorNames[e.CompressType]
+	if !ok {
+		name = fmt.Sprintf("compressor(%d)", e.CompressType)
+	}
+	fmt.Fprintf(w, "%s %s #%d %s id=%d %s %dB\n", e.Time.Format("15:04:05.000000"), arrow, e.Conn,
+		e.Method, e.ID, name, len(e.Body))
+	keys := make([]string, 0, len(e.Meta))
+	for k := range e.Meta {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		fmt.Fprintf(w, "%s %s: %s\n", arrow, k, e.Meta[k])
+	}
+	if e.Error != "" {
+		fmt.Fprintf(w, "%s error: %s\n", arrow, e.Error)
+	}
+	switch {
+	case e.DecodeError != "":
+		fmt.Fprintf(w, "%s undecodable body: %s\n", arrow, e.DecodeError)
+	case e.Decoded != "":
+		fmt.Fprintln(w, strings.TrimRight(e.Decoded, "\n"))
+	}
+}
+
+// Recorder writes events to a file as JSON lines
+type Recorder struct {
+	mutex sync.Mutex
+	e     *json.Encoder
+}
+
+func NewRecorder(w io.Writer) *Recorder {
+	return &Recorder{e: json.NewEncoder(w)}
+}
+
+func (r *Recorder) Record(e *Event) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.e.Encode(e)
+}
+
+// ReadRecording reads the events written by a Recorder
+func ReadRecording(r io.Reader) ([]*Event, error) {
+	var events []*Event
+	d := json.NewDecoder(r)
+	for {
+		e := &Event{}
+		if err := d.Decode(e); err == io.EOF {
+			return events, nil
+		} else if err != nil {
+			return nil, err
+		}
+		events = append(events, e)
+	}
+}
@@ -0,0 +1,231 @@
+package inspect
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mizumoto-cn/TRPcG"
+	"github.com/mizumoto-cn/TRPcG/compressor"
+	"github.com/mizumoto-cn/TRPcG/metadata"
+	"github.com/mizumoto-cn/TRPcG/serializer"
+	jsonp "github.com/mizumoto-cn/TRPcG/testing/json"
+	message "github.com/mizumoto-cn/TRPcG/testing/message"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// listen returns a listener on a free local port, closed with the test
+func listen(t *testing.T) net.Listener {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal("listen error:", err)
+	}
+	t.Cleanup(func() { l.Close() })
+	return l
+}
+
+// serve serves rcvr as name and returns the address of the server
+func serve(t *testing.T, name string, rcvr any, opts ...TRPcG.Option) string {
+	server := TRPcG.NewServer(opts...)
+	if err := server.RegisterName(name, rcvr); err != nil {
+		t.Fatal("register error:", err)
+	}
+	l := listen(t)
+	go server.Serve(l)
+	return l.Addr().String()
+}
+
+// proxy serves rcvr behind a proxy and returns a client of the proxy, and the
+// events seen by the proxy once the client is closed
+func proxy(t *testing.T, name string, rcvr any, decode Decoder, opts ...TRPcG.Option) (*TRPcG.Client, func() []*Event) {
+	var (
+		mutex  sync.Mutex
+		events []*Event
+	)
+	p := &Proxy{Target: serve(t, name, rcvr, opts...), Decode: decode, OnEvent: func(e *Event) {
+		mutex.Lock()
+		events = append(events, e)
+		mutex.Unlock()
+	}}
+	pl := listen(t)
+	served := make(chan struct{})
+	go func() {
+		p.Serve(pl)
+		close(served)
+	}()
+
+	conn, err := net.Dial("tcp", pl.Addr().String())
+	if err != nil {
+		t.Fatal("dial error:", err)
+	}
+	client := TRPcG.NewClient(conn, opts...)
+	return client, func() []*Event {
+		client.Close()
+		pl.Close()
+		<-served
+		mutex.Lock()
+		defer mutex.Unlock()
+		return events
+	}
+}
+
+func TestProxy_Json(t *testing.T) {
+	client, events := proxy(t, "TestService", new(jsonp.TestService), JSON,
+		TRPcG.WithSerializer(serializer.Json), TRPcG.WithCompress(compressor.Gzip))
+
+	ctx := metadata.NewOutgoingContext(context.Background(), metadata.Pairs("client-id", "alice"))
+	reply := &jsonp.Response{}
+	assert.Equal(t, nil, client.CallContext(ctx, "TestService.Add", &jsonp.Request{A: 1, B: 2}, reply))
+	assert.Equal(t, float64(3), reply.C)
+	err := client.Call("TestService.Div", &jsonp.Request{A: 1}, reply)
+	assert.NotEqual(t, nil, err)
+
+	seen := events()
+	assert.Equal(t, 4, len(seen))
+	request, response := seen[0], seen[1]
+	assert.Equal(t, false, request.Response)
+	assert.Equal(t, "TestService.Add", request.Method)
+	assert.Equal(t, compressor.Gzip, request.CompressType)
+	assert.Equal(t, "alice", request.Meta["client-id"])
+	assert.Equal(t, "{\n  \"a\": 1,\n  \"b\": 2\n}", request.Decoded)
+	assert.Equal(t, true, response.Response)
+	assert.Equal(t, request.ID, response.ID)
+	assert.Equal(t, "TestService.Add", response.Method)
+	assert.Equal(t, "{\n  \"c\": 3\n}", response.Decoded)
+	assert.Equal(t, "TestService.Div", seen[3].Method)
+	assert.Equal(t, err.Error(), seen[3].Error)
+
+	var out bytes.Buffer
+	Print(&out, request)
+	assert.Contains(t, out.String(), fmt.Sprintf("> #1 TestService.Add id=%d gzip %dB\n", request.ID, len(request.Body)))
+	assert.Contains(t, out.String(), "> client-id: alice\n{\n  \"a\": 1,")
+
+	// a recording reads back as it was
+	out.Reset()
+	r := NewRecorder(&out)
+	for _, e := range seen {
+		assert.Equal(t, nil, r.Record(e))
+	}
+	recorded, err := ReadRecording(&out)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, len(seen), len(recorded))
+	assert.Equal(t, seen[0].Body, recorded[0].Body)
+	assert.Equal(t, seen[0].Meta, recorded[0].Meta)
+	assert.Equal(t, true, seen[1].Time.Equal(recorded[1].Time))
+}
+
+// TestProxy_Order tests that a request is seen before its response, however slow its decoding
+func TestProxy_Order(t *testing.T) {
+	slow := func(method string, response bool, body []byte) (string, error) {
+		if !response {
+			time.Sleep(20 * time.Millisecond)
+		}
+		return JSON(method, response, body)
+	}
+	client, events := proxy(t, "TestService", new(jsonp.TestService), slow, TRPcG.WithSerializer(serializer.Json))
+	for i := 0; i < 3; i++ {
+		assert.Equal(t, nil, client.Call("TestService.Add", &jsonp.Request{A: 1, B: 2}, &jsonp.Response{}))
+	}
+	seen := events()
+	assert.Equal(t, 6, len(seen))
+	for i, e := range seen {
+		assert.Equal(t, i%2 == 1, e.Response)
+		assert.Equal(t, "TestService.Add", e.Method)
+	}
+}
+
+func TestProxy_Proto(t *testing.T) {
+	types := func(method string) (protoreflect.MessageType, protoreflect.MessageType) {
+		if method != "ArithService.Add" {
+			return nil, nil
+		}
+		return (&message.ArithRequest{}).ProtoReflect().Type(), (&message.ArithResponse{}).ProtoReflect().Type()
+	}
+	client, events := proxy(t, "ArithService", new(message.ArithService), Proto(types), TRPcG.WithCompress(compressor.Snappy))
+
+	reply := &message.ArithResponse{}
+	assert.Equal(t, nil, client.Call("ArithService.Add", &message.ArithRequest{A: 1, B: 2}, reply))
+	assert.Equal(t, nil, client.Call("ArithService.Mul", &message.ArithRequest{A: 2, B: 3}, reply))
+	assert.Equal(t, float64(6), reply.C)
+
+	seen := events()
+	assert.Equal(t, 4, len(seen))
+	assert.Equal(t, "{\n  \"a\": 1,\n  \"b\": 2\n}", seen[0].Decoded)
+	assert.Equal(t, "{\n  \"c\": 3\n}", seen[1].Decoded)
+	// without types, fields by number
+	assert.Equal(t, "1: 0x4000000000000000\n2: 0x4008000000000000\n", seen[2].Decoded)
+	assert.Equal(t, "1: 0x4018000000000000\n", seen[3].Decoded)
+}
+
+// upgraded is a TestService whose Add changed
+type upgraded struct {
+	jsonp.TestService
+}
+
+func (u *upgraded) Add(args *jsonp.Request, reply *jsonp.Response) error {
+	reply.C = args.A + args.B + 1
+	return nil
+}
+
+func TestReplay(t *testing.T) {
+	opts := []TRPcG.Option{TRPcG.WithSerializer(serializer.Json), TRPcG.WithCompress(compressor.Zlib)}
+	client, events := proxy(t, "TestService", new(jsonp.TestService), nil, opts...)
+	reply := &jsonp.Response{}
+	assert.Equal(t, nil, client.Call("TestService.Add", &jsonp.Request{A: 1, B: 2}, reply))
+	time.Sleep(200 * time.Millisecond)
+	assert.Equal(t, nil, client.Call("TestService.Mul", &jsonp.Request{A: 2, B: 3}, reply))
+	assert.NotEqual(t, nil, client.Call("TestService.Div", &jsonp.Request{A: 1}, reply))
+	recorded := events()
+	assert.Equal(t, 6, len(recorded))
+
+	// the same service responds as recorded, at twice the recorded pace
+	r := &Replayer{Target: serve(t, "TestService", new(jsonp.TestService), opts...), Speed: 2}
+	start := time.Now()
+	results := r.Replay(context.Background(), recorded)
+	assert.GreaterOrEqual(t, time.Since(start), 100*time.Millisecond)
+	assert.Less(t, time.Since(start), 200*time.Millisecond)
+	assert.Equal(t, 3, len(results))
+	for _, result := range results {
+		assert.Equal(t, true, result.Match(), result.Diff())
+		assert.Equal(t, "", result.Diff())
+	}
+	assert.Equal(t, "TestService.Div", results[2].Replayed.Method)
+	assert.Equal(t, "divided by zero", results[2].Replayed.Error)
+
+	// an upgrade changing Add shows up, decoded
+	r = &Replayer{Target: serve(t, "TestService", new(upgraded), opts...), Decode: JSON}
+	results = r.Replay(context.Background(), recorded)
+	assert.Equal(t, false, results[0].Match())
+	assert.Equal(t, "  {\n-   \"c\": 3\n+   \"c\": 4\n  }\n", results[0].Diff())
+	assert.Equal(t, true, results[1].Match())
+	assert.Equal(t, true, results[2].Match())
+
+	// without a server, every request fails
+	l := listen(t)
+	l.Close()
+	r = &Replayer{Target: l.Addr().String()}
+	results = r.Replay(context.Background(), recorded)
+	for _, result := range results {
+		assert.Equal(t, false, result.Match())
+		assert.NotEqual(t, nil, result.Err)
+	}
+}
+
+func TestWire(t *testing.T) {
+	data, err := proto.Marshal(&message.ArithRequest{A: 1})
+	assert.Equal(t, nil, err)
+	nested := append([]byte{0x12, byte(len(data))}, data...)
+	nested = append(nested, 0x18, 0x96, 0x01, 0x22, 0x02, 'h', 'i')
+	out, err := Wire("", false, nested)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "2: {\n  1: 0x3ff0000000000000\n}\n3: 150\n4: \"hi\"\n", out)
+
+	_, err = Wire("", false, []byte{0x0a, 0x05})
+	assert.NotEqual(t, nil, err)
+}
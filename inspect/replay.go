@@ -0,0 +1,301 @@
+package inspect
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mizumoto-cn/TRPcG/codec"
+	"github.com/mizumoto-cn/TRPcG/header"
+)
+
+var errNoResponse = errors.New("connection closed before the response")
+
+// Replayer sends the requests of a recording to Target again, on as many
+// connections as were recorded, and compares the responses with the recorded ones
+type Replayer struct {
+	Target  string
+	Speed   float64       // of the recorded timing, 2 replaying twice as fast, 0 as fast as possible
+	Decode  Decoder       // compares the bodies decoded, their bytes if nil
+	Timeout time.Duration // for each response, 10 seconds if not set
+	Dial    func(network, addr string) (net.Conn, error)
+}
+
+// Result is a request replayed, with its recorded and replayed responses
+type Result struct {
+	Request  *Event
+	Recorded *Event        // nil if the recording has no response to the request
+	Replayed *Event        // nil if the request could not be replayed
+	Latency  time.Duration // from sending the request to its response
+	Err      error         // why the request could not be replayed
+	decoded  bool
+}
+
+// Match reports whether the server responded as recorded: the same error and
+// the same body. Requests recorded without response match any response.
+func (r *Result) Match() bool {
+	if r.Err != nil || r.Replayed == nil {
+		return false
+	}
+	if r.Recorded == nil {
+		return true
+	}
+	if r.Recorded.Error != r.Replayed.Error {
+		return false
+	}
+	if r.decoded {
+		return r.Recorded.Decoded == r.Replayed.Decoded && r.Recorded.DecodeError == r.Replayed.DecodeError
+	}
+	return bytes.Equal(body(r.Recorded), body(r.Replayed))
+}
+
+// Diff returns how the replayed response differs from the recorded one, line
+// by line, "" if they match
+func (r *Result) Diff() string {
+	switch {
+	case r.Match():
+		return ""
+	case r.Err != nil:
+		return fmt.Sprintf("! %v\n", r.Err)
+	}
+	var b strings.Builder
+	if r.Recorded.Error != r.Replayed.Error {
+		fmt.Fprintf(&b, "- error: %s\n+ error: %s\n", r.Recorded.Error, r.Replayed.Error)
+	}
+	if r.decoded {
+		b.WriteString(diff(printed(r.Recorded), printed(r.Replayed)))
+	} else if !bytes.Equal(body(r.Recorded), body(r.Replayed)) {
+		fmt.Fprintf(&b, "- %q\n+ %q\n", body(r.Recorded), body(r.Replayed))
+	}
+	return b.String()
+}
+
+// body returns the decompressed body of e, as on the wire if it does not decompress
+func body(e *Event) []byte {
+	data, err := codec.Decompress(e.CompressType, e.Checksum, e.Body)
+	if err != nil {
+		return e.Body
+	}
+	return data
+}
+
+func printed(e *Event) string {
+	if e.DecodeError != "" {
+		return "undecodable body: " + e.DecodeError
+	}
+	return e.Decoded
+}
+
+// Replay sends the requests of events at their recorded pace, and waits for
+// their responses. Canceling ctx stops the replay, failing the requests left.
+func (r *Replayer) Replay(ctx context.Context, events []*Event) []*Result {
+	var requests []*Event
+	recorded := make(map[[2]uint64]*Event)
+	for _, e := range events {
+		if e.Response {
+			recorded[[2]uint64{e.Conn, e.ID}] = e
+		} else {
+			requests = append(requests, e)
+		}
+	}
+	sort.SliceStable(requests, func(i, j int) bool { return requests[i].Time.Before(requests[j].Time) })
+
+	conns := make(map[uint64]*replayConn)
+	defer func() {
+		for _, c := range conns {
+			if c.conn != nil {
+				c.conn.Close()
+			}
+		}
+	}()
+	timeout := r.Timeout
+	if timeout == 0 {
+		timeout = 10 * time.Second
+	}
+
+	results := make([]*Result, len(requests))
+	var wg sync.WaitGroup
+	start := time.Now()
+	for i, e := range requests {
+		result := &Result{Request: e, Recorded: recorded[[2]uint64{e.Conn, e.ID}], decoded: r.Decode != nil}
+		results[i] = result
+		if result.Recorded != nil && r.Decode != nil {
+			decode(r.Decode, result.Recorded)
+		}
+		if r.Speed > 0 {
+			at := time.Duration(float64(e.Time.Sub(requests[0].Time)) / r.Speed)
+			select {
+			case <-time.After(at - time.Since(start)):
+			case <-ctx.Done():
+			}
+		}
+		if result.Err = ctx.Err(); result.Err != nil {
+			continue
+		}
+
+		c, ok := conns[e.Conn]
+		if !ok {
+			c = r.dial()
+			conns[e.Conn] = c
+		}
+		sent := time.Now()
+		response, err := c.send(e)
+		if err != nil {
+			result.Err = err
+			continue
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			select {
+			case replayed, ok := <-response:
+				if !ok {
+					result.Err = c.failure()
+					return
+				}
+				result.Latency = time.Since(sent)
+				replayed.Conn = result.Request.Conn
+				replayed.Method = result.Request.Method
+				if r.Decode != nil {
+					decode(r.Decode, replayed)
+				}
+				result.Replayed = replayed
+			case <-time.After(timeout):
+				result.Err = fmt.Errorf("no response after %v", timeout)
+			case <-ctx.Done():
+				result.Err = ctx.Err()
+			}
+		}()
+	}
+	wg.Wait()
+	return results
+}
+
+func (r *Replayer) dial() *replayConn {
+	dial := r.Dial
+	if dial == nil {
+		dial = net.Dial
+	}
+	c := &replayConn{pending: make(map[uint64]chan *Event)}
+	c.conn, c.err = dial("tcp", r.Target)
+	if c.err == nil {
+		_, c.err = c.conn.Write([]byte(codec.Magic))
+	}
+	if c.err == nil {
+		go c.receive()
+	}
+	return c
+}
+
+// replayConn is a connection replaying the requests of a recorded one
+type replayConn struct {
+	conn    net.Conn
+	mutex   sync.Mutex
+	seq     uint64
+	pending map[uint64]chan *Event
+	err     error // of the connection, failing the requests pending and to come
+}
+
+// send writes a request with a new ID, returning where its response comes,
+// closed if the connection fails first
+func (c *replayConn) send(e *Event) (chan *Event, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.err != nil {
+		return nil, c.err
+	}
+	c.seq++
+	response := make(chan *Event, 1)
+	c.pending[c.seq] = response
+	h := &header.RequestHeader{CompressType: e.CompressType, Method: e.Method, ID: c.seq, Checksum: e.Checksum, Meta: e.Meta}
+	if err := codec.WriteRequest(c.conn, h, e.Body); err != nil {
+		c.fail(err)
+		return nil, err
+	}
+	return response, nil
+}
+
+func (c *replayConn) receive() {
+	r := bufio.NewReader(c.conn)
+	for {
+		h, body, err := codec.ReadResponse(r)
+		if err != nil {
+			c.mutex.Lock()
+			c.fail(errNoResponse)
+			c.mutex.Unlock()
+			return
+		}
+		e := &Event{Time: time.Now(), Response: true, ID: h.ID, CompressType: h.CompressType,
+			Checksum: h.CheckSum, Meta: h.Meta, Error: h.Error, Body: body}
+		c.mutex.Lock()
+		if response, ok := c.pending[h.ID]; ok {
+			delete(c.pending, h.ID)
+			response <- e
+		}
+		c.mutex.Unlock()
+	}
+}
+
+// fail fails the connection with err, called with the mutex held
+func (c *replayConn) fail(err error) {
+	if c.err == nil {
+		c.err = err
+	}
+	for id, response := range c.pending {
+		close(response)
+		delete(c.pending, id)
+	}
+}
+
+func (c *replayConn) failure() error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.err
+}
+
+// diff returns the lines of a and b, those of a only prefixed by "- ", those
+// of b only by "+ " and the common ones by "  "
+func diff(a, b string) string {
+	x := strings.Split(strings.TrimRight(a, "\n"), "\n")
+	y := strings.Split(strings.TrimRight(b, "\n"), "\n")
+	// lcs[i][j] is the length of the longest common subsequence of x[i:] and y[j:]
+	lcs := make([][]int, len(x)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(y)+1)
+	}
+	for i := len(x) - 1; i >= 0; i-- {
+		for j := len(y) - 1; j >= 0; j-- {
+			if x[i] == y[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+	var out strings.Builder
+	i, j := 0, 0
+	for i < len(x) || j < len(y) {
+		switch {
+		case i < len(x) && j < len(y) && x[i] == y[j]:
+			fmt.Fprintf(&out, "  %s\n", x[i])
+			i++
+			j++
+		case j == len(y) || (i < len(x) && lcs[i+1][j] >= lcs[i][j+1]):
+			fmt.Fprintf(&out, "- %s\n", x[i])
+			i++
+		default:
+			fmt.Fprintf(&out, "+ %s\n", y[j])
+			j++
+		}
+	}
+	return out.String()
+}
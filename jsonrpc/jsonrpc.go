@@ -0,0 +1,354 @@
+// Package jsonrpc serves rpc.Server over JSON-RPC 2.0 (https://www.jsonrpc.org/specification),
+// for the clients which cannot speak TRPcG, see TRPcG.Server::ServeJSONRPC and
+// TRPcG.Server::JSONRPCHandler. Methods are named as in TRPcG, "Service.Method",
+// and their args and replies are the JSON mapping of their protobuf messages.
+package jsonrpc
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/rpc"
+	"strings"
+	"sync"
+
+	"github.com/mizumoto-cn/TRPcG/metadata"
+	"github.com/mizumoto-cn/TRPcG/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+)
+
+// Version is the version of the protocol, the jsonrpc member of the messages
+const Version = "2.0"
+
+// Error codes defined by the specification
+const (
+	ParseError     = -32700
+	InvalidRequest = -32600
+	MethodNotFound = -32601
+	InvalidParams  = -32602
+	InternalError  = -32603
+)
+
+// ErrorCode returns the code of the error object of an error with the status code c,
+// in the range the specification reserves to server errors: -32000 minus c.
+// The method and params errors of the calls have the codes of the specification.
+func ErrorCode(c status.Code) int {
+	return -32000 - int(c)
+}
+
+// Error is the error object of a response
+type Error struct {
+	Code    int        `json:"code"`
+	Message string     `json:"message"`
+	Data    *ErrorData `json:"data,omitempty"`
+}
+
+// ErrorData is the data of the error objects of the calls which failed
+type ErrorData struct {
+	Status   string      `json:"status"`             // name of the status code, such as "NotFound"
+	Metadata metadata.MD `json:"metadata,omitempty"` // response metadata, such as validate.ViolationsKey
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("jsonrpc: %s (%d)", e.Message, e.Code)
+}
+
+// Request is a request object
+type Request struct {
+	Version string          `json:"jsonrpc"`
+	Method  string          `json:"method"`
+	Params  json.RawMessage `json:"params,omitempty"`
+	ID      json.RawMessage `json:"id,omitempty"` // nil for notifications
+}
+
+// Response is a response object
+type Response struct {
+	Version string          `json:"jsonrpc"`
+	Result  json.RawMessage `json:"result,omitempty"`
+	Error   *Error          `json:"error,omitempty"`
+	ID      json.RawMessage `json:"id"`
+}
+
+var null = json.RawMessage("null")
+
+// call is a request read, waiting for its response
+type call struct {
+	id            json.RawMessage // nil for notifications
+	batch         *batch          // nil if not in a batch
+	invalidParams bool
+	header        metadata.MD
+}
+
+// batch collects the responses of the calls of a batch request, written together
+type batch struct {
+	responses []*Response
+	pending   int // calls yet to respond
+}
+
+type serverCodec struct {
+	dec *json.Decoder
+	w   io.Writer
+	c   io.Closer
+	md  metadata.MD
+
+	mutex   sync.Mutex // guards the fields below and the writes
+	seq     uint64
+	pending map[uint64]*call
+	queue   []json.RawMessage // requests of the batch read last, not read by ReadRequestHeader yet
+	batch   *batch
+	params  json.RawMessage // of the request whose body is read next
+	body    uint64          // sequence number of the request whose body is read next
+}
+
+// NewServerCodec returns a rpc.ServerCodec reading JSON-RPC 2.0 requests from conn,
+// single or batched, and writing their responses to it. md is the metadata of every
+// request, such as the headers of an HTTP request, may be nil.
+func NewServerCodec(conn io.ReadWriteCloser, md metadata.MD) rpc.ServerCodec {
+	return &serverCodec{
+		dec:     json.NewDecoder(conn),
+		w:       conn,
+		c:       conn,
+		md:      md,
+		pending: make(map[uint64]*call),
+	}
+}
+
+// ServerCodec::ReadRequestHeader()
+func (server *serverCodec) ReadRequestHeader(r *rpc.Request) error {
+	for {
+		server.mutex.Lock()
+		ok, err := server.dequeue(r)
+		server.mutex.Unlock()
+		if ok || err != nil {
+			return err
+		}
+
+		var raw json.RawMessage
+		if err := server.dec.Decode(&raw); err != nil {
+			if err == io.EOF || errors.Is(err, io.ErrUnexpectedEOF) {
+				return err
+			}
+			// the stream cannot be resynchronized
+			server.mutex.Lock()
+			server.write(&Response{Version: Version, Error: &Error{Code: ParseError, Message: err.Error()}, ID: null})
+			server.mutex.Unlock()
+			return err
+		}
+		server.mutex.Lock()
+		if raw[0] == '[' {
+			var list []json.RawMessage
+			json.Unmarshal(raw, &list) // valid JSON
+			if len(list) == 0 {
+				server.write(invalid(null, "empty batch"))
+			}
+			server.queue, server.batch = list, &batch{}
+		} else {
+			ok = server.readRequest(r, raw, nil)
+		}
+		server.mutex.Unlock()
+		if ok {
+			return nil
+		}
+	}
+}
+
+// dequeue reads the next valid request of the batch read last into r, returning
+// false once none is left, the responses to the invalid ones written if nothing
+// else is to be answered
+func (server *serverCodec) dequeue(r *rpc.Request) (bool, error) {
+	for len(server.queue) > 0 {
+		raw := server.queue[0]
+		server.queue = server.queue[1:]
+		if server.readRequest(r, raw, server.batch) {
+			return true, nil
+		}
+	}
+	b := server.batch
+	server.batch = nil
+	if b != nil && b.pending == 0 && len(b.responses) > 0 {
+		return false, server.write(b.responses)
+	}
+	return false, nil
+}
+
+// readRequest reads the request raw into r, returning false if it has no
+// response to wait for: invalid, its error response is written or added to b
+func (server *serverCodec) readRequest(r *rpc.Request, raw json.RawMessage, b *batch) bool {
+	var req Request
+	if err := json.Unmarshal(raw, &req); err != nil {
+		return server.respond(b, invalid(null, "not a request object"))
+	}
+	id := req.ID
+	if len(id) > 0 && !validID(id) {
+		return server.respond(b, invalid(null, "id must be a string, a number or null"))
+	}
+	if id == nil {
+		// response to a notification with an invalid request, the id it could not give
+		id = null
+	}
+	switch {
+	case req.Version != Version:
+		return server.respond(b, invalid(id, `jsonrpc must be "2.0"`))
+	case req.Method == "":
+		return server.respond(b, invalid(id, "method is required"))
+	case len(req.Params) > 0 && req.Params[0] != '[' && req.Params[0] != '{' && string(req.Params) != "null":
+		return server.respond(b, invalid(id, "params must be an array or an object"))
+	}
+
+	server.seq++
+	server.pending[server.seq] = &call{id: req.ID, batch: b}
+	if b != nil && req.ID != nil {
+		b.pending++
+	}
+	server.body, server.params = server.seq, req.Params
+	r.ServiceMethod = req.Method
+	r.Seq = server.seq
+	return true
+}
+
+func validID(id json.RawMessage) bool {
+	switch id[0] {
+	case '"', 'n', '-', '0', '1', '2', '3', '4', '5', '6', '7', '8', '9':
+		return true
+	}
+	return false
+}
+
+func invalid(id json.RawMessage, msg string) *Response {
+	return &Response{Version: Version, Error: &Error{Code: InvalidRequest, Message: msg}, ID: id}
+}
+
+// respond writes resp, or adds it to b if not nil, and returns false
+func (server *serverCodec) respond(b *batch, resp *Response) bool {
+	if b != nil {
+		b.responses = append(b.responses, resp)
+	} else {
+		server.write(resp)
+	}
+	return false
+}
+
+// ServerCodec::ReadRequestBody()
+func (server *serverCodec) ReadRequestBody(param any) error {
+	server.mutex.Lock()
+	params, c := server.params, server.pending[server.body]
+	server.params = nil
+	server.mutex.Unlock()
+	if param == nil || len(params) == 0 || string(params) == "null" {
+		return nil
+	}
+	if params[0] == '[' {
+		// by position, the single argument of the methods
+		var list []json.RawMessage
+		if err := json.Unmarshal(params, &list); err != nil || len(list) != 1 {
+			c.invalidParams = true
+			return status.Errorf(status.InvalidArgument, "params must be an array of 1 item, or an object")
+		}
+		params = list[0]
+	}
+	if err := unmarshal(params, param); err != nil {
+		c.invalidParams = true
+		return status.Errorf(status.InvalidArgument, "invalid params: %v", err)
+	}
+	return nil
+}
+
+// ServerCodec::WriteResponse()
+func (server *serverCodec) WriteResponse(r *rpc.Response, param any) error {
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+	c, ok := server.pending[r.Seq]
+	if !ok {
+		return errors.New("invalid sequence number in response")
+	}
+	delete(server.pending, r.Seq)
+	if c.id == nil {
+		// notification
+		return nil
+	}
+
+	resp := &Response{Version: Version, ID: c.id}
+	if r.Error == "" {
+		result, err := marshal(param)
+		if err == nil {
+			resp.Result = result
+		} else {
+			r.Error = status.Errorf(status.Internal, "encoding the reply: %v", err).Error()
+		}
+	}
+	if r.Error != "" {
+		resp.Error = c.error(r.Error)
+	}
+	if c.batch == nil {
+		return server.write(resp)
+	}
+	c.batch.responses = append(c.batch.responses, resp)
+	c.batch.pending--
+	if c.batch.pending > 0 || c.batch == server.batch {
+		// waiting for the other calls, or for the invalid requests left
+		return nil
+	}
+	return server.write(c.batch.responses)
+}
+
+// error returns the error object of the call failed with the error string msg
+func (c *call) error(msg string) *Error {
+	if c.invalidParams {
+		s, _ := status.FromError(errors.New(msg))
+		return &Error{Code: InvalidParams, Message: s.Message()}
+	}
+	if strings.HasPrefix(msg, "rpc: can't find ") || strings.HasPrefix(msg, "rpc: service/method request ill-formed") {
+		return &Error{Code: MethodNotFound, Message: strings.TrimPrefix(msg, "rpc: ")}
+	}
+	s, _ := status.FromError(errors.New(msg))
+	return &Error{
+		Code:    ErrorCode(s.Code()),
+		Message: s.Message(),
+		Data:    &ErrorData{Status: s.Code().String(), Metadata: c.header},
+	}
+}
+
+// write writes a response or a batch of them, the mutex held
+func (server *serverCodec) write(v any) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	_, err = server.w.Write(append(data, '\n'))
+	return err
+}
+
+// Metadata returns the metadata received with the request of sequence number seq
+func (server *serverCodec) Metadata(seq uint64) metadata.MD {
+	return server.md.Copy()
+}
+
+// SetHeader sets the metadata sent back with the response of sequence number seq,
+// in the data of its error object
+func (server *serverCodec) SetHeader(seq uint64, md metadata.MD) {
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+	if c, ok := server.pending[seq]; ok {
+		c.header = md
+	}
+}
+
+func (server *serverCodec) Close() error {
+	return server.c.Close()
+}
+
+func unmarshal(data []byte, v any) error {
+	if m, ok := v.(proto.Message); ok {
+		return protojson.Unmarshal(data, m)
+	}
+	return json.Unmarshal(data, v)
+}
+
+func marshal(v any) (json.RawMessage, error) {
+	if m, ok := v.(proto.Message); ok {
+		return protojson.MarshalOptions{EmitUnpopulated: true}.Marshal(m)
+	}
+	return json.Marshal(v)
+}
@@ -0,0 +1,128 @@
+package jsonrpc_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/rpc"
+	"strings"
+	"testing"
+
+	"github.com/mizumoto-cn/TRPcG/jsonrpc"
+	"github.com/mizumoto-cn/TRPcG/status"
+	jsonp "github.com/mizumoto-cn/TRPcG/testing/json"
+	message "github.com/mizumoto-cn/TRPcG/testing/message"
+	"github.com/stretchr/testify/assert"
+)
+
+// conn reads the requests of a test and buffers the responses
+type conn struct {
+	io.Reader
+	bytes.Buffer
+}
+
+func (c *conn) Read(p []byte) (int, error) { return c.Reader.Read(p) }
+
+func (c *conn) Close() error { return nil }
+
+// exchange serves the requests with the arith services, and returns the responses
+func exchange(t *testing.T, requests string) []any {
+	server := rpc.NewServer()
+	server.RegisterName("ArithService", new(message.ArithService))
+	server.RegisterName("TestService", new(jsonp.TestService))
+	c := &conn{Reader: strings.NewReader(requests)}
+	server.ServeCodec(jsonrpc.NewServerCodec(c, nil))
+
+	var responses []any
+	dec := json.NewDecoder(&c.Buffer)
+	for dec.More() {
+		var resp any
+		if err := dec.Decode(&resp); err != nil {
+			t.Fatal("decode error:", err)
+		}
+		responses = append(responses, resp)
+	}
+	return responses
+}
+
+func parse(s string) any {
+	var v any
+	json.Unmarshal([]byte(s), &v)
+	return v
+}
+
+func TestServerCodec(t *testing.T) {
+	responses := exchange(t, `
+		{"jsonrpc": "2.0", "method": "ArithService.Add", "params": {"a": 1, "b": 2}, "id": 1}
+		{"jsonrpc": "2.0", "method": "ArithService.Mul", "params": [{"a": 3, "b": 4}], "id": "two"}
+		{"jsonrpc": "2.0", "method": "TestService.Sub", "params": {"a": 3, "b": 1}, "id": null}
+		{"jsonrpc": "2.0", "method": "ArithService.Add", "params": {"a": 1}}`)
+	assert.ElementsMatch(t, []any{
+		parse(`{"jsonrpc": "2.0", "result": {"c": 3}, "id": 1}`),
+		parse(`{"jsonrpc": "2.0", "result": {"c": 12}, "id": "two"}`),
+		parse(`{"jsonrpc": "2.0", "result": {"c": 2}, "id": null}`),
+	}, responses)
+}
+
+func TestServerCodec_Batch(t *testing.T) {
+	responses := exchange(t, `[
+		{"jsonrpc": "2.0", "method": "ArithService.Add", "params": {"a": 1, "b": 2}, "id": 1},
+		{"jsonrpc": "2.0", "method": "ArithService.Sub", "params": {"a": 1, "b": 2}},
+		{"jsonrpc": "1.0", "method": "ArithService.Sub", "id": 2},
+		{"jsonrpc": "2.0", "method": "ArithService.Div", "params": {"a": 1}, "id": 3},
+		7
+	]`)
+	if !assert.Equal(t, 1, len(responses)) {
+		return
+	}
+	assert.ElementsMatch(t, []any{
+		parse(`{"jsonrpc": "2.0", "result": {"c": 3}, "id": 1}`),
+		parse(`{"jsonrpc": "2.0", "error": {"code": -32600, "message": "jsonrpc must be \"2.0\""}, "id": 2}`),
+		parse(`{"jsonrpc": "2.0", "error": {"code": -32002, "message": "divided by zero", "data": {"status": "Unknown"}}, "id": 3}`),
+		parse(`{"jsonrpc": "2.0", "error": {"code": -32600, "message": "not a request object"}, "id": null}`),
+	}, responses[0])
+
+	responses = exchange(t, `[{"jsonrpc": "2.0", "method": "ArithService.Add", "params": {"a": 1, "b": 2}}]
+	[{"jsonrpc": "2.0", "method": "ArithService.Add", "id": 4}]`)
+	assert.Equal(t, []any{parse(`[{"jsonrpc": "2.0", "result": {"c": 0}, "id": 4}]`)}, responses)
+}
+
+func TestServerCodec_Errors(t *testing.T) {
+	responses := exchange(t, `
+		{"jsonrpc": "2.0", "method": "ArithService.Pow", "id": 1}
+		{"jsonrpc": "2.0", "method": "Unknown.Add", "id": 2}
+		{"jsonrpc": "2.0", "method": "ArithService.Add", "params": {"a": "one"}, "id": 3}
+		{"jsonrpc": "2.0", "method": "ArithService.Add", "params": [1, 2], "id": 4}
+		{"jsonrpc": "2.0", "method": "ArithService.Add", "params": 1, "id": 5}
+		{"jsonrpc": "2.0", "id": 6}
+		{"jsonrpc": "2.0", "method": "ArithService.Add", "id": true}
+		[]
+		{"jsonrpc": "2.0", "method": "ArithService.Add", "id": 7`)
+	codes := make([]any, len(responses))
+	for i, r := range responses {
+		codes[i] = []any{r.(map[string]any)["id"], r.(map[string]any)["error"].(map[string]any)["code"]}
+	}
+	assert.ElementsMatch(t, []any{
+		[]any{1.0, -32601.0},
+		[]any{2.0, -32601.0},
+		[]any{3.0, -32602.0},
+		[]any{4.0, -32602.0},
+		[]any{5.0, -32600.0},
+		[]any{6.0, -32600.0},
+		[]any{nil, -32600.0},
+		[]any{nil, -32600.0},
+	}, codes)
+
+	responses = exchange(t, `{"jsonrpc": "2.0", "method": "ArithService.Add", "id": 1}
+		{"jsonrpc": "2.0", "method": }
+		{"jsonrpc": "2.0", "method": "ArithService.Add", "id": 2}`)
+	assert.ElementsMatch(t, []any{
+		parse(`{"jsonrpc": "2.0", "result": {"c": 0}, "id": 1}`),
+		parse(`{"jsonrpc": "2.0", "error": {"code": -32700, "message": "invalid character '}' looking for beginning of value"}, "id": null}`),
+	}, responses)
+}
+
+func TestErrorCode(t *testing.T) {
+	assert.Equal(t, -32005, jsonrpc.ErrorCode(status.NotFound))
+	assert.Equal(t, -32016, jsonrpc.ErrorCode(status.Unauthenticated))
+}
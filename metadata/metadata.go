@@ -0,0 +1,79 @@
+package metadata
+
+import (
+	"context"
+	"strings"
+)
+
+// MD is the metadata carried in request and response headers.
+// Keys are case-insensitive and are always stored in lower case.
+type MD map[string]string
+
+// New creates a MD from the given key-value map
+func New(m map[string]string) MD {
+	md := make(MD, len(m))
+	for k, v := range m {
+		md.Set(k, v)
+	}
+	return md
+}
+
+// Pairs returns a MD formed from the mapping of key, value ...
+// Pairs panics if len(kv) is odd.
+func Pairs(kv ...string) MD {
+	if len(kv)%2 == 1 {
+		panic("metadata: Pairs got an odd number of input pairs")
+	}
+	md := make(MD, len(kv)/2)
+	for i := 0; i < len(kv); i += 2 {
+		md.Set(kv[i], kv[i+1])
+	}
+	return md
+}
+
+// Get returns the value of key, or "" if it is not present
+func (md MD) Get(key string) string {
+	return md[strings.ToLower(key)]
+}
+
+// Set sets the value of key
+func (md MD) Set(key, value string) {
+	md[strings.ToLower(key)] = value
+}
+
+// Copy returns a copy of md
+func (md MD) Copy() MD {
+	return Join(md)
+}
+
+// Join merges all the given MDs into a new one, later values win
+func Join(mds ...MD) MD {
+	out := MD{}
+	for _, md := range mds {
+		for k, v := range md {
+			out[k] = v
+		}
+	}
+	return out
+}
+
+type outgoingKey struct{}
+
+// NewOutgoingContext attaches md to ctx, the metadata will be sent
+// with every call made with the returned context.
+func NewOutgoingContext(ctx context.Context, md MD) context.Context {
+	return context.WithValue(ctx, outgoingKey{}, md)
+}
+
+// AppendToOutgoingContext returns a new context with kv merged into the
+// outgoing metadata already attached to ctx.
+func AppendToOutgoingContext(ctx context.Context, kv ...string) context.Context {
+	md, _ := FromOutgoingContext(ctx)
+	return NewOutgoingContext(ctx, Join(md, Pairs(kv...)))
+}
+
+// FromOutgoingContext returns the outgoing metadata in ctx if it exists
+func FromOutgoingContext(ctx context.Context) (MD, bool) {
+	md, ok := ctx.Value(outgoingKey{}).(MD)
+	return md, ok
+}
@@ -0,0 +1,165 @@
+// Package mock supports the mocks generated by protoc-gen-trpcg with the mock
+// parameter: each method of a mocked service is a Method, answering the calls
+// it expects with canned responses and recording them, so that tests of the
+// code calling a service, or called by it, need no sockets.
+package mock
+
+import (
+	"reflect"
+	"sync"
+
+	"github.com/mizumoto-cn/TRPcG/status"
+	"google.golang.org/protobuf/proto"
+)
+
+// T is the part of *testing.T that Verify reports to
+type T interface {
+	Helper()
+	Errorf(format string, args ...any)
+}
+
+// Method mocks a method of a service taking Req and returning Resp
+type Method[Req, Resp any] struct {
+	mutex        sync.Mutex
+	name         string
+	expectations []*Expectation[Req, Resp]
+	calls        []Req
+	unexpected   []Req
+}
+
+// Expectation is a call expected by a Method, and how it is answered
+type Expectation[Req, Resp any] struct {
+	match func(Req) bool
+	do    func(Req) (Resp, error)
+	times int // 0 for any number of calls, at least one
+	calls int
+}
+
+// Name names the method in the errors of m, set by the generated mocks
+func (m *Method[Req, Resp]) Name(name string) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.name = name
+}
+
+// On expects calls whose request match, any request if match is nil. Calls
+// are answered by the first expectation matching them that is not exhausted.
+func (m *Method[Req, Resp]) On(match func(Req) bool) *Expectation[Req, Resp] {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	e := &Expectation[Req, Resp]{match: match}
+	m.expectations = append(m.expectations, e)
+	return e
+}
+
+// Return answers the expected calls with reply and err, the calls expected
+// without answer getting an empty reply
+func (e *Expectation[Req, Resp]) Return(reply Resp, err error) *Expectation[Req, Resp] {
+	e.do = func(Req) (Resp, error) { return reply, err }
+	return e
+}
+
+// Do answers the expected calls with f
+func (e *Expectation[Req, Resp]) Do(f func(Req) (Resp, error)) *Expectation[Req, Resp] {
+	e.do = f
+	return e
+}
+
+// Times expects exactly n calls
+func (e *Expectation[Req, Resp]) Times(n int) *Expectation[Req, Resp] {
+	e.times = n
+	return e
+}
+
+// Once expects exactly one call
+func (e *Expectation[Req, Resp]) Once() *Expectation[Req, Resp] {
+	return e.Times(1)
+}
+
+// Call answers a call of the method. Unexpected calls fail with Unimplemented.
+func (m *Method[Req, Resp]) Call(req Req) (Resp, error) {
+	m.mutex.Lock()
+	m.calls = append(m.calls, req)
+	var e *Expectation[Req, Resp]
+	for _, candidate := range m.expectations {
+		if (candidate.match == nil || candidate.match(req)) && (candidate.times == 0 || candidate.calls < candidate.times) {
+			e = candidate
+			break
+		}
+	}
+	if e == nil {
+		m.unexpected = append(m.unexpected, req)
+		m.mutex.Unlock()
+		var zero Resp
+		return zero, status.Errorf(status.Unimplemented, "unexpected call to %s with %v", m.name, req)
+	}
+	e.calls++
+	do := e.do
+	m.mutex.Unlock()
+
+	if do == nil {
+		return empty[Resp](), nil
+	}
+	return do(req)
+}
+
+// empty returns a new empty Resp, pointing to its zero value if a pointer
+func empty[Resp any]() Resp {
+	var zero Resp
+	if t := reflect.TypeOf(zero); t != nil && t.Kind() == reflect.Pointer {
+		return reflect.New(t.Elem()).Interface().(Resp)
+	}
+	return zero
+}
+
+// Calls returns the requests of the calls made, expected or not
+func (m *Method[Req, Resp]) Calls() []Req {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return append([]Req(nil), m.calls...)
+}
+
+// Verify reports to t the unexpected calls and the expected calls that were
+// not made, returning whether there were none
+func (m *Method[Req, Resp]) Verify(t T) bool {
+	t.Helper()
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	ok := true
+	for _, req := range m.unexpected {
+		t.Errorf("unexpected call to %s with %v", m.name, req)
+		ok = false
+	}
+	for i, e := range m.expectations {
+		switch {
+		case e.times == 0 && e.calls == 0:
+			t.Errorf("expectation %d of %s: expected calls, got none", i+1, m.name)
+			ok = false
+		case e.times > 0 && e.calls != e.times:
+			t.Errorf("expectation %d of %s: expected %d calls, got %d", i+1, m.name, e.times, e.calls)
+			ok = false
+		}
+	}
+	return ok
+}
+
+// Eq matches the requests equal to want, compared with proto.Equal for
+// protobuf messages
+func Eq[Req any](want Req) func(Req) bool {
+	return func(req Req) bool {
+		if w, ok := any(want).(proto.Message); ok {
+			if r, ok := any(req).(proto.Message); ok {
+				return proto.Equal(w, r)
+			}
+		}
+		return reflect.DeepEqual(want, req)
+	}
+}
+
+// Copy copies the response of a mocked method into the reply of a call
+func Copy(dst, src proto.Message) {
+	proto.Reset(dst)
+	if src != nil && src.ProtoReflect().IsValid() {
+		proto.Merge(dst, src)
+	}
+}
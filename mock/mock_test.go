@@ -0,0 +1,131 @@
+package mock_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/mizumoto-cn/TRPcG"
+	"github.com/mizumoto-cn/TRPcG/mock"
+	"github.com/mizumoto-cn/TRPcG/status"
+	message "github.com/mizumoto-cn/TRPcG/testing/message"
+	"github.com/mizumoto-cn/TRPcG/testing/shop"
+	"github.com/stretchr/testify/assert"
+)
+
+// recorder records what Verify reports
+type recorder struct {
+	errors []string
+}
+
+func (r *recorder) Helper() {}
+
+func (r *recorder) Errorf(format string, args ...any) {
+	r.errors = append(r.errors, fmt.Sprintf(format, args...))
+}
+
+func TestMethod(t *testing.T) {
+	var m mock.Method[*message.ArithRequest, *message.ArithResponse]
+	m.Name("ArithService.Add")
+	m.On(mock.Eq(&message.ArithRequest{A: 1, B: 2})).Return(&message.ArithResponse{C: 3}, nil).Once()
+	m.On(func(r *message.ArithRequest) bool { return r.B == 0 }).Return(nil, errors.New("divided by zero"))
+	m.On(func(r *message.ArithRequest) bool { return r.A == 2 }).Do(func(r *message.ArithRequest) (*message.ArithResponse, error) {
+		return &message.ArithResponse{C: r.A * r.B}, nil
+	}).Times(2)
+
+	reply, err := m.Call(&message.ArithRequest{A: 1, B: 2})
+	assert.Equal(t, nil, err)
+	assert.Equal(t, float64(3), reply.C)
+	// the first expectation is exhausted
+	_, err = m.Call(&message.ArithRequest{A: 1, B: 2})
+	assert.Equal(t, status.Unimplemented, status.CodeOf(err))
+
+	_, err = m.Call(&message.ArithRequest{A: 1})
+	assert.Equal(t, "divided by zero", err.Error())
+
+	reply, err = m.Call(&message.ArithRequest{A: 2, B: 3})
+	assert.Equal(t, nil, err)
+	assert.Equal(t, float64(6), reply.C)
+	assert.Equal(t, 4, len(m.Calls()))
+
+	r := &recorder{}
+	assert.Equal(t, false, m.Verify(r))
+	assert.Equal(t, 2, len(r.errors))
+	assert.Contains(t, r.errors[0], "unexpected call to ArithService.Add with ")
+	assert.Equal(t, "expectation 3 of ArithService.Add: expected 2 calls, got 1", r.errors[1])
+}
+
+func TestMethod_Empty(t *testing.T) {
+	var m mock.Method[*message.ArithRequest, *message.ArithResponse]
+	m.On(nil)
+
+	// answered without response, the reply is empty
+	reply, err := m.Call(&message.ArithRequest{A: 1})
+	assert.Equal(t, nil, err)
+	assert.Equal(t, float64(0), reply.C)
+	assert.Equal(t, true, m.Verify(t))
+
+	copied := &message.ArithResponse{C: 1}
+	mock.Copy(copied, &message.ArithResponse{C: 3})
+	assert.Equal(t, float64(3), copied.C)
+	mock.Copy(copied, (*message.ArithResponse)(nil))
+	assert.Equal(t, float64(0), copied.C)
+}
+
+func TestMock_Client(t *testing.T) {
+	m := shop.NewMockOrders()
+	m.Place.On(mock.Eq(&shop.Order{CustomerId: 1})).Return(&shop.Receipt{Order: &shop.Order{CustomerId: 1}}, nil).Once()
+	m.Place.On(func(o *shop.Order) bool { return o.CustomerId == 2 }).Return(nil, errors.New("out of stock"))
+	m.Place.On(func(o *shop.Order) bool { return o.CustomerId == 3 }).Do(func(o *shop.Order) (*shop.Receipt, error) {
+		return &shop.Receipt{Order: o}, nil
+	}).Times(2)
+	client := m.Client()
+
+	reply, err := client.Place(context.Background(), &shop.Order{CustomerId: 1})
+	assert.Equal(t, nil, err)
+	assert.Equal(t, int64(1), reply.Order.CustomerId)
+	// the first expectation is exhausted
+	_, err = client.Place(context.Background(), &shop.Order{CustomerId: 1})
+	assert.Equal(t, status.Unimplemented, status.CodeOf(err))
+
+	_, err = client.Place(context.Background(), &shop.Order{CustomerId: 2})
+	assert.Equal(t, "out of stock", err.Error())
+
+	async := &shop.Receipt{}
+	call := <-client.PlaceAsync(&shop.Order{CustomerId: 3}, async)
+	assert.Equal(t, nil, call.Error)
+	assert.Equal(t, int64(3), async.Order.CustomerId)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = client.Place(ctx, &shop.Order{CustomerId: 3})
+	assert.Equal(t, context.Canceled, err)
+
+	// the canceled call never reached the mock
+	assert.Equal(t, 4, len(m.Place.Calls()))
+
+	r := &recorder{}
+	assert.Equal(t, false, m.Verify(r))
+	assert.Equal(t, 2, len(r.errors))
+	assert.Contains(t, r.errors[0], "unexpected call to Orders.Place with ")
+	assert.Equal(t, "expectation 3 of Orders.Place: expected 2 calls, got 1", r.errors[1])
+}
+
+func TestMock_Server(t *testing.T) {
+	m := shop.NewMockOrders()
+	m.Place.On(mock.Eq(&shop.Order{CustomerId: 1})).Return(&shop.Receipt{Order: &shop.Order{CustomerId: 1}}, nil).Once()
+	m.Place.On(nil).Once()
+	server := m.Server()
+
+	// a server of Orders for net/rpc
+	assert.Equal(t, nil, shop.RegisterOrdersServer(TRPcG.NewServer(), server))
+
+	reply := &shop.Receipt{Order: &shop.Order{CustomerId: 7}}
+	assert.Equal(t, nil, server.Place(&shop.Order{CustomerId: 1}, reply))
+	assert.Equal(t, int64(1), reply.Order.CustomerId)
+	// answered without response, the reply is empty
+	assert.Equal(t, nil, server.Place(&shop.Order{CustomerId: 2}, reply))
+	assert.Equal(t, (*shop.Order)(nil), reply.Order)
+	assert.Equal(t, true, m.Verify(t))
+}
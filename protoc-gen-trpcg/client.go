@@ -0,0 +1,67 @@
+package main
+
+import (
+	"unicode"
+	"unicode/utf8"
+
+	"google.golang.org/protobuf/compiler/protogen"
+)
+
+const (
+	contextPackage = protogen.GoImportPath("context")
+	rpcPackage     = protogen.GoImportPath("net/rpc")
+	trpcgPackage   = protogen.GoImportPath("github.com/mizumoto-cn/TRPcG")
+	statusPackage  = protogen.GoImportPath("github.com/mizumoto-cn/TRPcG/status")
+)
+
+// generateClient generates the typed client of s
+func (md *rpc) generateClient(g *protogen.GeneratedFile, s *protogen.Service) {
+	name := s.GoName + "Client"
+	impl := lowerFirst(name)
+
+	g.P("// ", name, " is the client of ", s.GoName)
+	g.P("type ", name, " interface {")
+	for _, m := range s.Methods {
+		comment(g, m.Comments)
+		g.P(m.GoName, "(ctx ", g.QualifiedGoIdent(contextPackage.Ident("Context")), ", args *", g.QualifiedGoIdent(m.Input.GoIdent),
+			", opts ...", g.QualifiedGoIdent(trpcgPackage.Ident("CallOption")), ") (*", g.QualifiedGoIdent(m.Output.GoIdent), ", error)")
+		g.P("// ", m.GoName, "Async calls ", m.GoName, " asynchronously, sending the call on the channel returned once done")
+		g.P(m.GoName, "Async(args *", g.QualifiedGoIdent(m.Input.GoIdent), ", reply *", g.QualifiedGoIdent(m.Output.GoIdent),
+			") chan *", g.QualifiedGoIdent(rpcPackage.Ident("Call")))
+	}
+	g.P("}")
+	g.P()
+	g.P("type ", impl, " struct {")
+	g.P("c ", g.QualifiedGoIdent(trpcgPackage.Ident("Invoker")))
+	g.P("}")
+	g.P()
+	g.P("// New", name, " returns a client of ", s.GoName, " calling through c,")
+	g.P("// a *TRPcG.Client or a *TRPcG.ClusterClient")
+	g.P("func New", name, "(c ", g.QualifiedGoIdent(trpcgPackage.Ident("Invoker")), ") ", name, " {")
+	g.P("return &", impl, "{c}")
+	g.P("}")
+	g.P()
+	for _, m := range s.Methods {
+		serviceMethod := md.serviceName(s.GoName) + "." + m.GoName
+		g.P("func (c *", impl, ") ", m.GoName, "(ctx ", g.QualifiedGoIdent(contextPackage.Ident("Context")), ", args *",
+			g.QualifiedGoIdent(m.Input.GoIdent), ", opts ...", g.QualifiedGoIdent(trpcgPackage.Ident("CallOption")), ") (*",
+			g.QualifiedGoIdent(m.Output.GoIdent), ", error) {")
+		g.P("reply := new(", g.QualifiedGoIdent(m.Output.GoIdent), ")")
+		g.P("if err := c.c.CallContext(ctx, \"", serviceMethod, "\", args, reply, opts...); err != nil {")
+		g.P("return nil, err")
+		g.P("}")
+		g.P("return reply, nil")
+		g.P("}")
+		g.P()
+		g.P("func (c *", impl, ") ", m.GoName, "Async(args *", g.QualifiedGoIdent(m.Input.GoIdent), ", reply *",
+			g.QualifiedGoIdent(m.Output.GoIdent), ") chan *", g.QualifiedGoIdent(rpcPackage.Ident("Call")), " {")
+		g.P("return c.c.AsyncCall(\"", serviceMethod, "\", args, reply)")
+		g.P("}")
+		g.P()
+	}
+}
+
+func lowerFirst(s string) string {
+	r, n := utf8.DecodeRuneInString(s)
+	return string(unicode.ToLower(r)) + s[n:]
+}
@@ -8,44 +8,102 @@ import (
 )
 
 func main() {
-	g := rpc{}
-	protogen.Options{}.Run(g.Generate)
+	g := rpc{params: defaultParams()}
+	protogen.Options{ParamFunc: g.set}.Run(g.Generate)
 }
 
-type rpc struct{}
+type rpc struct {
+	params
+}
 
 // Generate generate service code
 func (md *rpc) Generate(plugin *protogen.Plugin) error {
+	if err := md.check(); err != nil {
+		return err
+	}
 	for _, f := range plugin.Files {
+		if !f.Generate {
+			continue
+		}
+		if err := unary(f); err != nil {
+			return err
+		}
+		validated := validatedMessages(f.Messages)
+		if len(f.Services) > 0 && (md.client || md.server) || len(validated) > 0 {
+			if err := md.generateFile(plugin, f, validated); err != nil {
+				return err
+			}
+		}
 		if len(f.Services) == 0 {
 			continue
 		}
-		fileName := f.GeneratedFilenamePrefix + ".svr.go"
-		t := plugin.NewGeneratedFile(fileName, f.GoImportPath)
-		t.P("// Code generated by protoc-gen-trpcg.")
-		t.P()
-		pkg := fmt.Sprintf("package %s", f.GoPackageName)
-		t.P(pkg)
-		t.P()
-		for _, s := range f.Services {
-			serviceCode := fmt.Sprintf(`%s type %s struct{}`,
-				getComments(s.Comments), s.Desc.Name())
-			t.P(serviceCode)
-			t.P()
-			for _, m := range s.Methods {
-				funcCode := fmt.Sprintf(`%s func(this *%s) %s(args *%s,reply *%s)error{
-					// define your service ...
-					return nil
-				}
-				`, getComments(m.Comments), s.Desc.Name(),
-					m.Desc.Name(), m.Input.Desc.Name(), m.Output.Desc.Name())
-				t.P(funcCode)
+		if md.mock {
+			if err := md.generateMockFile(plugin, f); err != nil {
+				return err
+			}
+		}
+		if md.openapi {
+			if err := md.generateOpenAPI(plugin, f); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// unary fails on the streaming methods of f: calls are unary until the runtime
+// supports streams
+func unary(f *protogen.File) error {
+	for _, s := range f.Services {
+		for _, m := range s.Methods {
+			var kind string
+			switch {
+			case m.Desc.IsStreamingClient() && m.Desc.IsStreamingServer():
+				kind = "bidirectional streaming"
+			case m.Desc.IsStreamingClient():
+				kind = "client streaming"
+			case m.Desc.IsStreamingServer():
+				kind = "server streaming"
+			default:
+				continue
 			}
+			return fmt.Errorf("%s: method %s.%s is %s, which TRPcG does not support: "+
+				"its calls are unary, a request for a response", f.Desc.Path(), s.GoName, m.GoName, kind)
+		}
+	}
+	return nil
+}
+
+// generateFile generates the code of the services of f and the Validate methods
+// of the validated messages, regenerated along with the proto file: the services
+// are implemented in files of their own
+func (md *rpc) generateFile(plugin *protogen.Plugin, f *protogen.File, validated []*protogen.Message) error {
+	g := plugin.NewGeneratedFile(f.GeneratedFilenamePrefix+md.suffix, f.GoImportPath)
+	header(g, f)
+	for _, s := range f.Services {
+		if md.server {
+			md.generateServer(g, s)
+		}
+		if md.client {
+			md.generateClient(g, s)
+		}
+	}
+	for _, m := range validated {
+		if err := generateValidate(g, m); err != nil {
+			return err
 		}
 	}
 	return nil
 }
 
+func header(g *protogen.GeneratedFile, f *protogen.File) {
+	g.P("// Code generated by protoc-gen-trpcg. DO NOT EDIT.")
+	g.P("// source: ", f.Desc.Path())
+	g.P()
+	g.P("package ", f.GoPackageName)
+	g.P()
+}
+
 // getComments get comment details
 func getComments(comments protogen.CommentSet) string {
 	c := make([]string, 0)
@@ -61,3 +119,10 @@ func getComments(comments protogen.CommentSet) string {
 	}
 	return res
 }
+
+// comment writes the comments of a declaration, if any
+func comment(g *protogen.GeneratedFile, comments protogen.CommentSet) {
+	if c := getComments(comments); c != "" {
+		g.P(strings.TrimSuffix(c, "\n"))
+	}
+}
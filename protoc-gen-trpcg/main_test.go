@@ -0,0 +1,226 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/mizumoto-cn/TRPcG/validate"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/protobuf/compiler/protogen"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/pluginpb"
+)
+
+// generate runs the plugin on the files of a descriptor set written by
+//
+//	protoc --include_source_info --include_imports --descriptor_set_out=testdata/<name>.pb <name>.proto
+//
+// from testdata/stream.proto, testing/message/arith.proto or testing/shop/shop.proto,
+// the latter with -I validate, and returns the files generated by name, or the error of the plugin
+func generate(t *testing.T, set string, param string) (map[string]string, string) {
+	return generateSet(t, readSet(t, set), param)
+}
+
+func readSet(t *testing.T, set string) *descriptorpb.FileDescriptorSet {
+	data, err := os.ReadFile(set)
+	if err != nil {
+		t.Fatal("read error:", err)
+	}
+	files := &descriptorpb.FileDescriptorSet{}
+	if err = proto.Unmarshal(data, files); err != nil {
+		t.Fatal("unmarshal error:", err)
+	}
+	return files
+}
+
+func generateSet(t *testing.T, files *descriptorpb.FileDescriptorSet, param string) (map[string]string, string) {
+	req := &pluginpb.CodeGeneratorRequest{ProtoFile: files.File, Parameter: proto.String(param)}
+	for _, f := range files.File {
+		req.FileToGenerate = append(req.FileToGenerate, f.GetName())
+	}
+	g := rpc{params: defaultParams()}
+	plugin, err := protogen.Options{ParamFunc: g.set}.New(req)
+	if err != nil {
+		return nil, err.Error()
+	}
+	if err = g.Generate(plugin); err != nil {
+		plugin.Error(err)
+	}
+	resp := plugin.Response()
+	generated := make(map[string]string)
+	for _, f := range resp.File {
+		generated[f.GetName()] = f.GetContent()
+	}
+	return generated, resp.GetError()
+}
+
+func TestGenerate_Client(t *testing.T) {
+	generated, err := generate(t, "testdata/arith.pb", "paths=source_relative")
+	assert.Equal(t, "", err)
+	golden, _ := os.ReadFile("testdata/arith.trpcg.go.golden")
+	assert.Equal(t, string(golden), generated["arith.trpcg.go"])
+}
+
+func TestGenerate_Mock(t *testing.T) {
+	generated, err := generate(t, "testdata/arith.pb", "paths=source_relative,mock")
+	assert.Equal(t, "", err)
+	golden, _ := os.ReadFile("testdata/arith_mock.trpcg.go.golden")
+	assert.Equal(t, string(golden), generated["arith_mock.trpcg.go"])
+	generated, err = generate(t, "testdata/shop.pb", "paths=source_relative,mock")
+	assert.Equal(t, "", err)
+	golden, _ = os.ReadFile("../testing/shop/shop_mock.trpcg.go")
+	assert.Equal(t, string(golden), generated["shop_mock.trpcg.go"])
+
+	generated, _ = generate(t, "testdata/arith.pb", "paths=source_relative,mock=false")
+	assert.Equal(t, 1, len(generated))
+
+	_, err = generate(t, "testdata/arith.pb", "mock=maybe")
+	assert.Equal(t, `invalid value "maybe" of parameter mock`, err)
+	_, err = generate(t, "testdata/arith.pb", "frobnicate")
+	assert.Equal(t, `unknown parameter "frobnicate"`, err)
+}
+
+func TestGenerate_Streaming(t *testing.T) {
+	generated, err := generate(t, "testdata/stream.pb", "")
+	assert.Equal(t, "stream.proto: method Ticker.Watch is server streaming, which TRPcG does not support: "+
+		"its calls are unary, a request for a response", err)
+	assert.Equal(t, 0, len(generated))
+}
+
+func TestGenerate_Params(t *testing.T) {
+	generated, err := generate(t, "testdata/arith.pb", "paths=source_relative,prefix=math.v1.,suffix=.rpc.go,mock")
+	assert.Equal(t, "", err)
+	assert.Equal(t, 2, len(generated))
+	assert.Contains(t, generated["arith.rpc.go"], `s.RegisterName("math.v1.ArithService", impl)`)
+	assert.Contains(t, generated["arith.rpc.go"], `c.c.CallContext(ctx, "math.v1.ArithService.Add", args, reply, opts...)`)
+	assert.Contains(t, generated["arith_mock.rpc.go"], `m.Add.Name("math.v1.ArithService.Add")`)
+
+	generated, err = generate(t, "testdata/arith.pb", "paths=source_relative,client=false")
+	assert.Equal(t, "", err)
+	assert.Contains(t, generated["arith.trpcg.go"], "type ArithServiceServer interface")
+	assert.NotContains(t, generated["arith.trpcg.go"], "type ArithServiceClient interface")
+	generated, err = generate(t, "testdata/arith.pb", "paths=source_relative,server=false")
+	assert.Equal(t, "", err)
+	assert.NotContains(t, generated["arith.trpcg.go"], "type ArithServiceServer interface")
+	assert.Contains(t, generated["arith.trpcg.go"], "type ArithServiceClient interface")
+	generated, err = generate(t, "testdata/arith.pb", "client=false,server=false")
+	assert.Equal(t, "", err)
+	assert.Equal(t, 0, len(generated))
+
+	// paths=import, the default, places the files by the go_package of the proto file
+	generated, err = generate(t, "testdata/arith.pb", "paths=import")
+	assert.Equal(t, "", err)
+	assert.Equal(t, 1, len(generated))
+	assert.NotContains(t, generated, "arith.trpcg.go")
+
+	for param, want := range map[string]string{
+		"paths=nowhere":          `unknown path type "nowhere": want "import" or "source_relative"`,
+		"client=sometimes":       `invalid value "sometimes" of parameter client`,
+		"prefix=math v1.":        `invalid value "math v1." of parameter prefix: not a service name prefix`,
+		"prefix=.v1":             `invalid value ".v1" of parameter prefix: not a service name prefix`,
+		"suffix=.trpcg":          `invalid value ".trpcg" of parameter suffix: not the suffix of a Go file`,
+		"suffix=.pb.go":          `invalid value ".pb.go" of parameter suffix: not the suffix of a Go file`,
+		"mock,server=false":      "parameter mock needs the client and the server",
+		"client=false,mock=true": "parameter mock needs the client and the server",
+	} {
+		_, err = generate(t, "testdata/arith.pb", param)
+		assert.Equal(t, want, err, param)
+	}
+}
+
+func TestGenerate_OpenAPI(t *testing.T) {
+	generated, err := generate(t, "testdata/arith.pb", "paths=source_relative,openapi")
+	assert.Equal(t, "", err)
+	assert.Equal(t, 2, len(generated))
+	golden, _ := os.ReadFile("../testing/message/arith.openapi.json")
+	assert.Equal(t, string(golden), generated["arith.openapi.json"])
+
+	generated, err = generate(t, "testdata/shop.pb", "paths=source_relative,prefix=shop.v1.,openapi,client=false,server=false")
+	assert.Equal(t, "", err)
+	assert.Equal(t, 2, len(generated)) // and the Validate methods
+	var doc struct {
+		Paths map[string]struct {
+			Post struct {
+				Summary     string
+				Description string
+				Responses   map[string]json.RawMessage
+			}
+		}
+		Components struct {
+			Schemas map[string]struct {
+				Description string
+				Required    []string
+				Properties  map[string]map[string]any
+			}
+		}
+	}
+	assert.Equal(t, nil, json.Unmarshal([]byte(generated["shop.openapi.json"]), &doc))
+	place := doc.Paths["/shop.v1.Orders/Place"].Post
+	assert.Equal(t, "Place places an order", place.Summary)
+	assert.Contains(t, place.Description, "It fails with FailedPrecondition when an item is out of stock.")
+	assert.Contains(t, string(place.Responses["200"]), "#/components/schemas/shop.v1.Receipt")
+	assert.Contains(t, string(place.Responses["400"]), "#/components/responses/400")
+
+	order := doc.Components.Schemas["shop.v1.Order"]
+	assert.Equal(t, "Order is an order of items", order.Description)
+	assert.Equal(t, map[string]any{"type": "string", "format": "int64", "description": "customer_id identifies the customer"},
+		order.Properties["customerId"])
+	assert.Equal(t, map[string]any{"type": "string", "format": "date-time"}, order.Properties["deliverBy"])
+	assert.Equal(t, map[string]any{"type": "string", "enum": []any{"NORMAL", "EXPRESS"}}, order.Properties["priority"])
+	assert.Equal(t, "object", order.Properties["notes"]["type"])
+	// and the validation rules
+	assert.Equal(t, []string{"customerId"}, order.Required)
+	assert.Equal(t, 1.0, order.Properties["items"]["minItems"])
+	assert.Equal(t, 5.0, order.Properties["notes"]["maxProperties"])
+	assert.Equal(t, 12.0, order.Properties["coupon"]["maxLength"])
+	assert.Equal(t, map[string]any{"type": "string", "pattern": "^[a-z]+$"}, order.Properties["tags"]["items"])
+	item := doc.Components.Schemas["shop.v1.Order.Item"]
+	assert.Equal(t, map[string]any{"type": "integer", "format": "int64", "minimum": 1.0, "maximum": 99.0}, item.Properties["quantity"])
+	assert.Equal(t, "Item is a line of an order", doc.Components.Schemas["shop.v1.Order.Item"].Description)
+	assert.Contains(t, doc.Components.Schemas, "shop.v1.Receipt")
+	assert.NotContains(t, doc.Components.Schemas, "google.protobuf.Timestamp")
+}
+
+func TestGenerate_Validate(t *testing.T) {
+	generated, err := generate(t, "testdata/shop.pb", "paths=source_relative")
+	assert.Equal(t, "", err)
+	golden, _ := os.ReadFile("../testing/shop/shop.trpcg.go")
+	assert.Equal(t, string(golden), generated["shop.trpcg.go"])
+
+	// the Validate methods are generated without the services
+	generated, err = generate(t, "testdata/shop.pb", "paths=source_relative,client=false,server=false")
+	assert.Equal(t, "", err)
+	assert.Contains(t, generated["shop.trpcg.go"], "func (x *Order) Validate() error {")
+	assert.NotContains(t, generated["shop.trpcg.go"], "OrdersServer")
+
+	for field, rules := range map[string]*validate.FieldRules{
+		"coupon:shop.proto: rule min and max does not apply to field shop.v1.Order.coupon of kind string": {
+			Min: proto.Float64(1)},
+		"priority:shop.proto: rule pattern does not apply to field shop.v1.Order.priority of kind enum": {
+			Pattern: proto.String("^A")},
+		"customer_id:shop.proto: rule defined_only does not apply to field shop.v1.Order.customer_id of kind int64": {
+			DefinedOnly: proto.Bool(true)},
+		"customer_id:shop.proto: bound 1.5 of field shop.v1.Order.customer_id does not fit its kind int64": {
+			Min: proto.Float64(1.5)},
+		"tags:shop.proto: invalid pattern of field shop.v1.Order.tags: error parsing regexp: missing closing ): `(a`": {
+			Pattern: proto.String("(a")},
+	} {
+		set := readSet(t, "testdata/shop.pb")
+		name, want, _ := strings.Cut(field, ":")
+		for _, f := range set.File {
+			for _, m := range f.MessageType {
+				for _, fd := range m.Field {
+					if m.GetName() == "Order" && fd.GetName() == name {
+						fd.Options = &descriptorpb.FieldOptions{}
+						proto.SetExtension(fd.Options, validate.E_Rules, rules)
+					}
+				}
+			}
+		}
+		_, err = generateSet(t, set, "")
+		assert.Equal(t, want, err)
+	}
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"fmt"
+
+	"google.golang.org/protobuf/compiler/protogen"
+)
+
+const mockPackage = protogen.GoImportPath("github.com/mizumoto-cn/TRPcG/mock")
+
+// generateMockFile generates the mocks of the services of f, in a file of their own
+func (md *rpc) generateMockFile(plugin *protogen.Plugin, f *protogen.File) error {
+	g := plugin.NewGeneratedFile(f.GeneratedFilenamePrefix+"_mock"+md.suffix, f.GoImportPath)
+	header(g, f)
+	for _, s := range f.Services {
+		if err := md.generateMock(g, s); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// generateMock generates the mock of s, answering both as a client and as a server
+func (md *rpc) generateMock(g *protogen.GeneratedFile, s *protogen.Service) error {
+	name := "Mock" + s.GoName
+	client := "mock" + s.GoName + "Client"
+	server := "mock" + s.GoName + "Server"
+	for _, m := range s.Methods {
+		switch m.GoName {
+		case "Client", "Server", "Verify":
+			return fmt.Errorf("method %s of service %s clashes with the methods of its mock", m.GoName, s.GoName)
+		}
+	}
+	method := func(m *protogen.Method) string {
+		return g.QualifiedGoIdent(mockPackage.Ident("Method")) + "[*" + g.QualifiedGoIdent(m.Input.GoIdent) +
+			", *" + g.QualifiedGoIdent(m.Output.GoIdent) + "]"
+	}
+
+	g.P("// ", name, " mocks ", s.GoName, ": each of its methods answers the calls it")
+	g.P("// expects with canned responses, and records them. Client and Server answer")
+	g.P("// through it as a client and as a server of ", s.GoName, ".")
+	g.P("type ", name, " struct {")
+	for _, m := range s.Methods {
+		g.P(m.GoName, " ", method(m))
+	}
+	g.P("}")
+	g.P()
+	g.P("// New", name, " returns a mock of ", s.GoName, " expecting no call")
+	g.P("func New", name, "() *", name, " {")
+	g.P("m := &", name, "{}")
+	for _, m := range s.Methods {
+		g.P("m.", m.GoName, ".Name(\"", md.serviceName(s.GoName), ".", m.GoName, "\")")
+	}
+	g.P("return m")
+	g.P("}")
+	g.P()
+	g.P("// Client returns the ", s.GoName, "Client answered by m")
+	g.P("func (m *", name, ") Client() ", s.GoName, "Client {")
+	g.P("return &", client, "{m}")
+	g.P("}")
+	g.P()
+	g.P("// Server returns the ", s.GoName, "Server answered by m, to register on a server")
+	g.P("func (m *", name, ") Server() ", s.GoName, "Server {")
+	g.P("return &", server, "{m}")
+	g.P("}")
+	g.P()
+	g.P("// Verify reports to t the unexpected calls and the expected calls that were")
+	g.P("// not made, returning whether there were none")
+	g.P("func (m *", name, ") Verify(t ", g.QualifiedGoIdent(mockPackage.Ident("T")), ") bool {")
+	g.P("t.Helper()")
+	g.P("ok := true")
+	for _, m := range s.Methods {
+		g.P("ok = m.", m.GoName, ".Verify(t) && ok")
+	}
+	g.P("return ok")
+	g.P("}")
+	g.P()
+
+	g.P("type ", client, " struct {")
+	g.P("m *", name)
+	g.P("}")
+	g.P()
+	for _, m := range s.Methods {
+		serviceMethod := md.serviceName(s.GoName) + "." + m.GoName
+		g.P("func (c *", client, ") ", m.GoName, "(ctx ", g.QualifiedGoIdent(contextPackage.Ident("Context")), ", args *",
+			g.QualifiedGoIdent(m.Input.GoIdent), ", opts ...", g.QualifiedGoIdent(trpcgPackage.Ident("CallOption")), ") (*",
+			g.QualifiedGoIdent(m.Output.GoIdent), ", error) {")
+		g.P("if err := ctx.Err(); err != nil {")
+		g.P("return nil, err")
+		g.P("}")
+		g.P("return c.m.", m.GoName, ".Call(args)")
+		g.P("}")
+		g.P()
+		g.P("func (c *", client, ") ", m.GoName, "Async(args *", g.QualifiedGoIdent(m.Input.GoIdent), ", reply *",
+			g.QualifiedGoIdent(m.Output.GoIdent), ") chan *", g.QualifiedGoIdent(rpcPackage.Ident("Call")), " {")
+		g.P("done := make(chan *", g.QualifiedGoIdent(rpcPackage.Ident("Call")), ", 1)")
+		g.P("response, err := c.m.", m.GoName, ".Call(args)")
+		g.P("if err == nil {")
+		g.P(g.QualifiedGoIdent(mockPackage.Ident("Copy")), "(reply, response)")
+		g.P("}")
+		g.P("done <- &", g.QualifiedGoIdent(rpcPackage.Ident("Call")), "{ServiceMethod: \"", serviceMethod,
+			"\", Args: args, Reply: reply, Error: err, Done: done}")
+		g.P("return done")
+		g.P("}")
+		g.P()
+	}
+
+	g.P("type ", server, " struct {")
+	g.P("m *", name)
+	g.P("}")
+	g.P()
+	for _, m := range s.Methods {
+		g.P("func (s *", server, ") ", m.GoName, "(args *", g.QualifiedGoIdent(m.Input.GoIdent), ", reply *",
+			g.QualifiedGoIdent(m.Output.GoIdent), ") error {")
+		g.P("response, err := s.m.", m.GoName, ".Call(args)")
+		g.P("if err == nil {")
+		g.P(g.QualifiedGoIdent(mockPackage.Ident("Copy")), "(reply, response)")
+		g.P("}")
+		g.P("return err")
+		g.P("}")
+		g.P()
+	}
+	return nil
+}
@@ -0,0 +1,306 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/mizumoto-cn/TRPcG/gateway"
+	"github.com/mizumoto-cn/TRPcG/status"
+	"github.com/mizumoto-cn/TRPcG/validate"
+	"google.golang.org/protobuf/compiler/protogen"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// object is a JSON object of the OpenAPI document, marshaled with sorted keys
+type object map[string]any
+
+// generateOpenAPI generates the OpenAPI 3 document of the routes the gateway
+// serves for the services of f, in a file of its own
+func (md *rpc) generateOpenAPI(plugin *protogen.Plugin, f *protogen.File) error {
+	messages := make(map[protoreflect.FullName]*protogen.Message)
+	for _, file := range plugin.Files {
+		addMessages(messages, file.Messages)
+	}
+	schemas := object{}
+	paths := object{}
+	for _, s := range f.Services {
+		for _, m := range s.Methods {
+			addSchema(schemas, messages, m.Input.Desc)
+			addSchema(schemas, messages, m.Output.Desc)
+			operation := object{
+				"operationId": s.GoName + "_" + m.GoName,
+				"tags":        []string{md.serviceName(s.GoName)},
+				"requestBody": object{
+					"required": true,
+					"content":  jsonContent(schemaOf(m.Input.Desc)),
+				},
+				"responses": responses(object{
+					"description": "OK",
+					"content":     jsonContent(schemaOf(m.Output.Desc)),
+				}),
+			}
+			if d := description(m.Comments); d != "" {
+				operation["summary"] = strings.SplitN(d, "\n", 2)[0]
+				operation["description"] = d
+			}
+			paths["/"+md.serviceName(s.GoName)+"/"+m.GoName] = object{"post": operation}
+		}
+	}
+	codes := make([]string, 0, status.Unauthenticated)
+	for c := status.Canceled; c <= status.Unauthenticated; c++ {
+		codes = append(codes, c.String())
+	}
+	schemas["Error"] = object{
+		"type":        "object",
+		"description": "The error of a call",
+		"properties": object{
+			"code":    object{"type": "string", "enum": codes},
+			"message": object{"type": "string"},
+		},
+	}
+
+	var tags []object
+	for _, s := range f.Services {
+		tag := object{"name": md.serviceName(s.GoName)}
+		if d := description(s.Comments); d != "" {
+			tag["description"] = d
+		}
+		tags = append(tags, tag)
+	}
+	info := object{"title": f.Desc.Path(), "version": "0.0.0"}
+	if pkg := f.Desc.Package(); pkg != "" {
+		info["title"] = string(pkg)
+	}
+	data, err := json.MarshalIndent(object{
+		"openapi":    "3.0.3",
+		"info":       info,
+		"tags":       tags,
+		"paths":      paths,
+		"components": object{"schemas": schemas, "responses": errorResponses()},
+	}, "", "  ")
+	if err != nil {
+		return err
+	}
+	g := plugin.NewGeneratedFile(f.GeneratedFilenamePrefix+".openapi.json", f.GoImportPath)
+	_, err = g.Write(append(data, '\n'))
+	return err
+}
+
+// description returns the comments extracted by getComments, as text
+func description(comments protogen.CommentSet) string {
+	c := getComments(comments)
+	if c == "" {
+		return ""
+	}
+	lines := strings.Split(strings.TrimSuffix(c, "\n"), "\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimPrefix(strings.TrimPrefix(line, "//"), " ")
+	}
+	return strings.Join(lines, "\n")
+}
+
+func jsonContent(schema object) object {
+	return object{"application/json": object{"schema": schema}}
+}
+
+// schemaOf returns the schema of the message d, a reference to its component
+// unless a well-known type
+func schemaOf(d protoreflect.MessageDescriptor) object {
+	if schema := wellKnown(d); schema != nil {
+		return schema
+	}
+	return object{"$ref": "#/components/schemas/" + string(d.FullName())}
+}
+
+// addMessages adds messages and their nested messages by full name
+func addMessages(messages map[protoreflect.FullName]*protogen.Message, list []*protogen.Message) {
+	for _, m := range list {
+		messages[m.Desc.FullName()] = m
+		addMessages(messages, m.Messages)
+	}
+}
+
+// responses returns the responses of an operation: ok, or the errors the
+// gateway answers with
+func responses(ok object) object {
+	r := object{"200": ok}
+	for code := range errorResponses() {
+		r[code] = object{"$ref": "#/components/responses/" + code}
+	}
+	return r
+}
+
+// errorResponses returns the error responses by HTTP status, described by the
+// status codes answered with it
+func errorResponses() object {
+	codes := make(map[int][]string)
+	for c := status.Canceled; c <= status.Unauthenticated; c++ {
+		h := gateway.HTTPStatus(c)
+		codes[h] = append(codes[h], c.String())
+	}
+	r := object{}
+	for h, names := range codes {
+		text := http.StatusText(h)
+		if text == "" {
+			text = "Client Closed Request"
+		}
+		r[strconv.Itoa(h)] = object{
+			"description": fmt.Sprintf("%s: %s", text, strings.Join(names, ", ")),
+			"content":     jsonContent(object{"$ref": "#/components/schemas/Error"}),
+		}
+	}
+	return r
+}
+
+// addSchema adds the schema of the message d, and of the messages of its
+// fields, to schemas, described by the comments of the messages known
+func addSchema(schemas object, messages map[protoreflect.FullName]*protogen.Message, d protoreflect.MessageDescriptor) {
+	name := string(d.FullName())
+	if _, ok := schemas[name]; ok || wellKnown(d) != nil {
+		return
+	}
+	properties := object{}
+	schema := object{"type": "object", "properties": properties}
+	schemas[name] = schema
+	m := messages[d.FullName()]
+	if m != nil {
+		if c := description(m.Comments); c != "" {
+			schema["description"] = c
+		}
+	}
+
+	fields := d.Fields()
+	var nested []protoreflect.MessageDescriptor
+	for i := 0; i < fields.Len(); i++ {
+		field := fields.Get(i)
+		property := fieldSchema(field)
+		if r := rules(field); r != nil {
+			ruleSchema(schema, property, field, r)
+		}
+		if m != nil {
+			if c := description(m.Fields[i].Comments); c != "" {
+				if _, ok := property["$ref"]; ok {
+					// siblings of $ref are ignored
+					property = object{"allOf": []object{property}}
+				}
+				property["description"] = c
+			}
+		}
+		properties[field.JSONName()] = property
+		switch {
+		case field.IsMap() && field.MapValue().Message() != nil:
+			nested = append(nested, field.MapValue().Message())
+		case !field.IsMap() && field.Message() != nil:
+			nested = append(nested, field.Message())
+		}
+	}
+	for _, n := range nested {
+		addSchema(schemas, messages, n)
+	}
+}
+
+// ruleSchema adds the (trpcg.validate.rules) r of field to its property in
+// the schema of its message
+func ruleSchema(schema, property object, field protoreflect.FieldDescriptor, r *validate.FieldRules) {
+	if r.GetRequired() {
+		required, _ := schema["required"].([]string)
+		schema["required"] = append(required, field.JSONName())
+	}
+	value := property
+	switch {
+	case field.IsList():
+		value, _ = property["items"].(object)
+		setIf(property, "minItems", r.MinLen)
+		setIf(property, "maxItems", r.MaxLen)
+	case field.IsMap():
+		value, _ = property["additionalProperties"].(object)
+		setIf(property, "minProperties", r.MinLen)
+		setIf(property, "maxProperties", r.MaxLen)
+	case field.Kind() == protoreflect.StringKind:
+		setIf(property, "minLength", r.MinLen)
+		setIf(property, "maxLength", r.MaxLen)
+	}
+	if value == nil {
+		return
+	}
+	setIf(value, "minimum", r.Min)
+	setIf(value, "maximum", r.Max)
+	setIf(value, "pattern", r.Pattern)
+}
+
+// setIf sets key to the value pointed by v, if not nil
+func setIf[T any](o object, key string, v *T) {
+	if v != nil {
+		o[key] = *v
+	}
+}
+
+// fieldSchema returns the schema of the JSON mapping of a field
+func fieldSchema(field protoreflect.FieldDescriptor) object {
+	switch {
+	case field.IsMap():
+		return object{"type": "object", "additionalProperties": singularSchema(field.MapValue())}
+	case field.IsList():
+		return object{"type": "array", "items": singularSchema(field)}
+	}
+	return singularSchema(field)
+}
+
+// singularSchema returns the schema of a value of field, as protojson marshals it
+func singularSchema(field protoreflect.FieldDescriptor) object {
+	switch field.Kind() {
+	case protoreflect.BoolKind:
+		return object{"type": "boolean"}
+	case protoreflect.Int32Kind, protoreflect.Sint32Kind, protoreflect.Sfixed32Kind:
+		return object{"type": "integer", "format": "int32"}
+	case protoreflect.Uint32Kind, protoreflect.Fixed32Kind:
+		return object{"type": "integer", "format": "int64", "minimum": 0}
+	case protoreflect.Int64Kind, protoreflect.Sint64Kind, protoreflect.Sfixed64Kind:
+		return object{"type": "string", "format": "int64"}
+	case protoreflect.Uint64Kind, protoreflect.Fixed64Kind:
+		return object{"type": "string", "format": "uint64"}
+	case protoreflect.FloatKind:
+		return object{"type": "number", "format": "float"}
+	case protoreflect.DoubleKind:
+		return object{"type": "number", "format": "double"}
+	case protoreflect.StringKind:
+		return object{"type": "string"}
+	case protoreflect.BytesKind:
+		return object{"type": "string", "format": "byte"}
+	case protoreflect.EnumKind:
+		values := field.Enum().Values()
+		names := make([]string, values.Len())
+		for i := range names {
+			names[i] = string(values.Get(i).Name())
+		}
+		return object{"type": "string", "enum": names}
+	}
+	return schemaOf(field.Message())
+}
+
+// wellKnown returns the schema of the well-known types protojson maps to
+// other JSON values than objects of their fields, nil for other messages
+func wellKnown(d protoreflect.MessageDescriptor) object {
+	switch d.FullName() {
+	case "google.protobuf.Timestamp":
+		return object{"type": "string", "format": "date-time"}
+	case "google.protobuf.Duration", "google.protobuf.FieldMask":
+		return object{"type": "string"}
+	case "google.protobuf.Struct", "google.protobuf.Any", "google.protobuf.Empty":
+		return object{"type": "object"}
+	case "google.protobuf.ListValue":
+		return object{"type": "array", "items": object{}}
+	case "google.protobuf.Value":
+		return object{}
+	case "google.protobuf.BoolValue", "google.protobuf.Int32Value", "google.protobuf.UInt32Value",
+		"google.protobuf.Int64Value", "google.protobuf.UInt64Value", "google.protobuf.FloatValue",
+		"google.protobuf.DoubleValue", "google.protobuf.StringValue", "google.protobuf.BytesValue":
+		schema := singularSchema(d.Fields().ByName("value"))
+		schema["nullable"] = true
+		return schema
+	}
+	return nil
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// params are the parameters of the plugin, given with --trpcg_opt as
+// name=value pairs separated by commas. protogen handles paths and M.
+type params struct {
+	client  bool   // generate the clients of the services
+	server  bool   // generate the server interfaces of the services
+	mock    bool   // generate the mocks of the services
+	openapi bool   // generate the OpenAPI documents of the gateway routes of the services
+	prefix  string // of the service names registered, and called by the clients
+	suffix  string // of the files generated, after the name of the proto file
+}
+
+func defaultParams() params {
+	return params{client: true, server: true, suffix: ".trpcg.go"}
+}
+
+// servicePrefix is the valid prefixes: dotted names, as net/rpc splits the
+// service from the method at the last dot
+var servicePrefix = regexp.MustCompile(`^([A-Za-z_][A-Za-z0-9_]*\.)*[A-Za-z0-9_]*$`)
+
+// set sets a parameter given by --trpcg_opt
+func (p *params) set(name, value string) error {
+	switch name {
+	case "client", "server", "mock", "openapi":
+		if value == "" {
+			value = "true"
+		}
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("invalid value %q of parameter %s", value, name)
+		}
+		switch name {
+		case "client":
+			p.client = b
+		case "server":
+			p.server = b
+		case "openapi":
+			p.openapi = b
+		default:
+			p.mock = b
+		}
+	case "prefix":
+		if !servicePrefix.MatchString(value) {
+			return fmt.Errorf("invalid value %q of parameter prefix: not a service name prefix", value)
+		}
+		p.prefix = value
+	case "suffix":
+		if !strings.HasSuffix(value, ".go") || strings.Contains(value, "/") || value == ".pb.go" {
+			return fmt.Errorf("invalid value %q of parameter suffix: not the suffix of a Go file", value)
+		}
+		p.suffix = value
+	default:
+		return fmt.Errorf("unknown parameter %q", name)
+	}
+	return nil
+}
+
+// check checks the parameters once all set
+func (p *params) check() error {
+	if p.mock && !(p.client && p.server) {
+		return fmt.Errorf("parameter mock needs the client and the server")
+	}
+	return nil
+}
+
+// serviceName returns the name under which a service is registered
+func (p *params) serviceName(service string) string {
+	return p.prefix + service
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"google.golang.org/protobuf/compiler/protogen"
+)
+
+// generateServer generates the interface implemented by the servers of s, its
+// registration helper and the base of its implementations
+func (md *rpc) generateServer(g *protogen.GeneratedFile, s *protogen.Service) {
+	name := s.GoName + "Server"
+	unimplemented := "Unimplemented" + name
+
+	g.P("// ", name, " is implemented by the servers of ", s.GoName)
+	if getComments(s.Comments) != "" {
+		g.P("//")
+		comment(g, s.Comments)
+	}
+	g.P("type ", name, " interface {")
+	for _, m := range s.Methods {
+		comment(g, m.Comments)
+		g.P(m.GoName, "(args *", g.QualifiedGoIdent(m.Input.GoIdent), ", reply *", g.QualifiedGoIdent(m.Output.GoIdent), ") error")
+	}
+	g.P("}")
+	g.P()
+	g.P("// Register", name, " registers impl as the ", s.GoName, " service of s")
+	g.P("func Register", name, "(s *", g.QualifiedGoIdent(trpcgPackage.Ident("Server")), ", impl ", name, ") error {")
+	g.P("return s.RegisterName(\"", md.serviceName(s.GoName), "\", impl)")
+	g.P("}")
+	g.P()
+	g.P("// ", unimplemented, " fails every method with Unimplemented. Embedded in")
+	g.P("// the implementations of ", name, ", it keeps them building when methods are added.")
+	g.P("type ", unimplemented, " struct{}")
+	g.P()
+	for _, m := range s.Methods {
+		g.P("func (", unimplemented, ") ", m.GoName, "(args *", g.QualifiedGoIdent(m.Input.GoIdent), ", reply *",
+			g.QualifiedGoIdent(m.Output.GoIdent), ") error {")
+		g.P("return ", g.QualifiedGoIdent(statusPackage.Ident("Error")), "(", g.QualifiedGoIdent(statusPackage.Ident("Unimplemented")),
+			", \"method ", m.GoName, " not implemented\")")
+		g.P("}")
+		g.P()
+	}
+}
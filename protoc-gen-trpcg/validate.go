@@ -0,0 +1,296 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+
+	"github.com/mizumoto-cn/TRPcG/validate"
+	"google.golang.org/protobuf/compiler/protogen"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+const (
+	validatePackage = protogen.GoImportPath("github.com/mizumoto-cn/TRPcG/validate")
+	regexpPackage   = protogen.GoImportPath("regexp")
+	utf8Package     = protogen.GoImportPath("unicode/utf8")
+)
+
+// rules returns the (trpcg.validate.rules) of field, nil if none
+func rules(field protoreflect.FieldDescriptor) *validate.FieldRules {
+	r, _ := proto.GetExtension(field.Options(), validate.E_Rules).(*validate.FieldRules)
+	return r
+}
+
+// validated reports whether m has a Validate method: whether its fields, or
+// those of the messages of its fields, have rules
+func validated(m *protogen.Message) bool {
+	return validatedVisiting(m, make(map[*protogen.Message]bool))
+}
+
+func validatedVisiting(m *protogen.Message, visiting map[*protogen.Message]bool) bool {
+	if visiting[m] {
+		return false
+	}
+	visiting[m] = true
+	defer delete(visiting, m)
+	for _, f := range m.Fields {
+		if rules(f.Desc) != nil {
+			return true
+		}
+		if f.Message != nil && validatedVisiting(f.Message, visiting) {
+			return true
+		}
+	}
+	return false
+}
+
+// validatedMessages returns the messages, and nested messages, having a Validate method
+func validatedMessages(messages []*protogen.Message) []*protogen.Message {
+	var list []*protogen.Message
+	for _, m := range messages {
+		if !m.Desc.IsMapEntry() && validated(m) {
+			list = append(list, m)
+		}
+		list = append(list, validatedMessages(m.Messages)...)
+	}
+	return list
+}
+
+// generateValidate generates the Validate method of m
+func generateValidate(g *protogen.GeneratedFile, m *protogen.Message) error {
+	patterns := make(map[*protogen.Field]string)
+	for _, f := range m.Fields {
+		r := rules(f.Desc)
+		if r == nil || r.Pattern == nil {
+			continue
+		}
+		if _, err := regexp.Compile(r.GetPattern()); err != nil {
+			return fmt.Errorf("%s: invalid pattern of field %s: %v", f.Desc.ParentFile().Path(), f.Desc.FullName(), err)
+		}
+		patterns[f] = "_" + f.GoIdent.GoName + "_pattern"
+		g.P("var ", patterns[f], " = ", g.QualifiedGoIdent(regexpPackage.Ident("MustCompile")), "(", strconv.Quote(r.GetPattern()), ")")
+		g.P()
+	}
+
+	g.P("// Validate checks x against the rules of the fields of ", m.GoIdent.GoName, ", and of the")
+	g.P("// messages of its fields, returning a *validate.Error of the rules broken")
+	g.P("func (x *", m.GoIdent.GoName, ") Validate() error {")
+	g.P("if x == nil {")
+	g.P("return nil")
+	g.P("}")
+	g.P("var v ", g.QualifiedGoIdent(validatePackage.Ident("Violations")))
+	for _, f := range m.Fields {
+		if err := validateField(g, f, patterns[f]); err != nil {
+			return err
+		}
+	}
+	g.P("return v.Err()")
+	g.P("}")
+	g.P()
+	return nil
+}
+
+// validateField generates the checks of the rules of f, and of its messages
+func validateField(g *protogen.GeneratedFile, f *protogen.Field, pattern string) error {
+	r := rules(f.Desc)
+	if r == nil {
+		r = &validate.FieldRules{}
+	}
+	value := f // whose kind the values of f have
+	if f.Desc.IsMap() {
+		value = f.Message.Fields[1]
+	}
+	nested := value.Message != nil && validated(value.Message)
+	if err := applies(f, value, r); err != nil {
+		return err
+	}
+	name := strconv.Quote(string(f.Desc.Name()))
+	get := "x.Get" + f.GoName + "()"
+
+	switch {
+	case f.Desc.IsList() || f.Desc.IsMap():
+		if r.GetRequired() {
+			g.P("if len(", get, ") == 0 {")
+			g.P("v.Add(", name, ", \"is required\")")
+			g.P("}")
+		}
+		if r.MinLen != nil {
+			g.P("if len(", get, ") < ", r.GetMinLen(), " {")
+			g.P("v.Add(", name, ", \"must have at least ", r.GetMinLen(), " items\")")
+			g.P("}")
+		}
+		if r.MaxLen != nil {
+			g.P("if len(", get, ") > ", r.GetMaxLen(), " {")
+			g.P("v.Add(", name, ", \"must have at most ", r.GetMaxLen(), " items\")")
+			g.P("}")
+		}
+		elements := &validate.FieldRules{Min: r.Min, Max: r.Max, Pattern: r.Pattern, DefinedOnly: r.DefinedOnly}
+		if !proto.Equal(elements, &validate.FieldRules{}) || nested {
+			path := g.QualifiedGoIdent(validatePackage.Ident("Index")) + "(" + name + ", i)"
+			if f.Desc.IsMap() {
+				g.P("for k, e := range ", get, " {")
+				path = g.QualifiedGoIdent(validatePackage.Ident("Key")) + "(" + name + ", k)"
+			} else {
+				g.P("for i, e := range ", get, " {")
+			}
+			if err := validateValue(g, value, elements, "e", path, pattern, nested); err != nil {
+				return err
+			}
+			g.P("}")
+		}
+	case f.Oneof != nil && !f.Oneof.Desc.IsSynthetic():
+		set := "_, ok := x.Get" + f.Oneof.GoName + "().(*" + g.QualifiedGoIdent(f.GoIdent) + ")"
+		if r.GetRequired() {
+			g.P("if ", set, "; !ok {")
+			g.P("v.Add(", name, ", \"is required\")")
+			g.P("}")
+		}
+		g.P("if ", set, "; ok {")
+		if err := validateValue(g, f, r, get, name, pattern, nested); err != nil {
+			return err
+		}
+		g.P("}")
+	case f.Desc.HasPresence() && f.Message == nil:
+		// proto2 optional and proto3 optional scalars, checked if set
+		if r.GetRequired() {
+			g.P("if x.", f.GoName, " == nil {")
+			g.P("v.Add(", name, ", \"is required\")")
+			g.P("}")
+		}
+		g.P("if x.", f.GoName, " != nil {")
+		if err := validateValue(g, f, r, get, name, pattern, nested); err != nil {
+			return err
+		}
+		g.P("}")
+	default:
+		if r.GetRequired() {
+			g.P("if ", zero(f, get), " {")
+			g.P("v.Add(", name, ", \"is required\")")
+			g.P("}")
+		}
+		return validateValue(g, f, r, get, name, pattern, nested)
+	}
+	return nil
+}
+
+// validateValue generates the checks of the rules of a value of field, but
+// for required, at path
+func validateValue(g *protogen.GeneratedFile, field *protogen.Field, r *validate.FieldRules, value, path, pattern string, nested bool) error {
+	if r.Min != nil {
+		min, err := literal(field, r.GetMin())
+		if err != nil {
+			return err
+		}
+		g.P("if ", value, " < ", min, " {")
+		g.P("v.Add(", path, ", \"must be at least ", min, "\")")
+		g.P("}")
+	}
+	if r.Max != nil {
+		max, err := literal(field, r.GetMax())
+		if err != nil {
+			return err
+		}
+		g.P("if ", value, " > ", max, " {")
+		g.P("v.Add(", path, ", \"must be at most ", max, "\")")
+		g.P("}")
+	}
+	length, unit := "len("+value+")", "bytes"
+	if field.Desc.Kind() == protoreflect.StringKind {
+		length, unit = g.QualifiedGoIdent(utf8Package.Ident("RuneCountInString"))+"("+value+")", "characters"
+	}
+	if r.MinLen != nil {
+		g.P("if ", length, " < ", r.GetMinLen(), " {")
+		g.P("v.Add(", path, ", \"must be at least ", r.GetMinLen(), " ", unit, " long\")")
+		g.P("}")
+	}
+	if r.MaxLen != nil {
+		g.P("if ", length, " > ", r.GetMaxLen(), " {")
+		g.P("v.Add(", path, ", \"must be at most ", r.GetMaxLen(), " ", unit, " long\")")
+		g.P("}")
+	}
+	if r.Pattern != nil {
+		g.P("if !", pattern, ".MatchString(", value, ") {")
+		g.P("v.Add(", path, ", ", strconv.Quote("must match the pattern "+r.GetPattern()), ")")
+		g.P("}")
+	}
+	if r.GetDefinedOnly() {
+		names := protogen.GoIdent{GoName: field.Enum.GoIdent.GoName + "_name", GoImportPath: field.Enum.GoIdent.GoImportPath}
+		g.P("if _, ok := ", g.QualifiedGoIdent(names), "[int32(", value, ")]; !ok {")
+		g.P("v.Add(", path, ", \"must be a defined ", field.Enum.Desc.Name(), "\")")
+		g.P("}")
+	}
+	if nested {
+		g.P("v.Nested(", path, ", ", value, ")")
+	}
+	return nil
+}
+
+// applies fails on the rules of f which do not apply to its kind, value being
+// the field of its values
+func applies(f, value *protogen.Field, r *validate.FieldRules) error {
+	kind := value.Desc.Kind()
+	collection := f.Desc.IsList() || f.Desc.IsMap()
+	var rule string
+	switch {
+	case (r.Min != nil || r.Max != nil) && !number(kind):
+		rule = "min and max"
+	case (r.MinLen != nil || r.MaxLen != nil) && !collection && kind != protoreflect.StringKind && kind != protoreflect.BytesKind:
+		rule = "min_len and max_len"
+	case r.Pattern != nil && kind != protoreflect.StringKind:
+		rule = "pattern"
+	case r.GetDefinedOnly() && kind != protoreflect.EnumKind:
+		rule = "defined_only"
+	default:
+		return nil
+	}
+	return fmt.Errorf("%s: rule %s does not apply to field %s of kind %s", f.Desc.ParentFile().Path(), rule, f.Desc.FullName(), kind)
+}
+
+func number(kind protoreflect.Kind) bool {
+	switch kind {
+	case protoreflect.BoolKind, protoreflect.EnumKind, protoreflect.StringKind, protoreflect.BytesKind,
+		protoreflect.MessageKind, protoreflect.GroupKind:
+		return false
+	}
+	return true
+}
+
+// literal returns the Go literal of a bound of field, failing if its values cannot reach it
+func literal(field *protogen.Field, bound float64) (string, error) {
+	var min, max float64
+	switch field.Desc.Kind() {
+	case protoreflect.FloatKind, protoreflect.DoubleKind:
+		return strconv.FormatFloat(bound, 'g', -1, 64), nil
+	case protoreflect.Int32Kind, protoreflect.Sint32Kind, protoreflect.Sfixed32Kind:
+		min, max = math.MinInt32, math.MaxInt32
+	case protoreflect.Uint32Kind, protoreflect.Fixed32Kind:
+		min, max = 0, math.MaxUint32
+	case protoreflect.Int64Kind, protoreflect.Sint64Kind, protoreflect.Sfixed64Kind:
+		min, max = math.MinInt64, math.MaxInt64
+	default:
+		min, max = 0, math.MaxUint64
+	}
+	if bound != math.Trunc(bound) || bound < min || bound > max {
+		return "", fmt.Errorf("%s: bound %v of field %s does not fit its kind %s", field.Desc.ParentFile().Path(), bound,
+			field.Desc.FullName(), field.Desc.Kind())
+	}
+	return strconv.FormatFloat(bound, 'f', -1, 64), nil
+}
+
+// zero returns the condition of the value of a singular field being unset
+func zero(field *protogen.Field, value string) string {
+	switch field.Desc.Kind() {
+	case protoreflect.MessageKind, protoreflect.GroupKind:
+		return value + " == nil"
+	case protoreflect.StringKind:
+		return value + ` == ""`
+	case protoreflect.BytesKind:
+		return "len(" + value + ") == 0"
+	case protoreflect.BoolKind:
+		return "!" + value
+	}
+	return value + " == 0"
+}
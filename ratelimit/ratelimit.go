@@ -0,0 +1,182 @@
+package ratelimit
+
+import (
+	"math"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mizumoto-cn/TRPcG/metadata"
+)
+
+// RetryAfterKey is the response metadata key holding how long a rejected
+// client should wait before trying again, formatted as a time.Duration.
+const RetryAfterKey = "retry-after"
+
+// maxBuckets is the number of buckets above which refilled buckets get dropped
+const maxBuckets = 10000
+
+// Bucket is a token bucket holding up to burst tokens and refilled at rate tokens per second.
+// Bucket is not safe for concurrent use, Limiter guards its buckets.
+type Bucket struct {
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+// NewBucket returns a full bucket
+func NewBucket(rate float64, burst int, now time.Time) *Bucket {
+	return &Bucket{rate: rate, burst: float64(burst), tokens: float64(burst), last: now}
+}
+
+// Take takes a token from the bucket. If the bucket is empty, it returns false
+// and the time to wait until a token becomes available.
+func (b *Bucket) Take(now time.Time) (bool, time.Duration) {
+	b.refill(now)
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0
+	}
+	if b.rate <= 0 {
+		return false, time.Duration(math.MaxInt64)
+	}
+	wait := time.Duration((1 - b.tokens) / b.rate * float64(time.Second))
+	return false, wait
+}
+
+// full reports whether the bucket has been refilled to its burst
+func (b *Bucket) full(now time.Time) bool {
+	b.refill(now)
+	return b.tokens >= b.burst
+}
+
+func (b *Bucket) refill(now time.Time) {
+	if elapsed := now.Sub(b.last); elapsed > 0 {
+		b.tokens = math.Min(b.burst, b.tokens+elapsed.Seconds()*b.rate)
+		b.last = now
+	}
+}
+
+// KeyFunc returns the key of the bucket a call is counted against
+type KeyFunc func(peer net.Addr, md metadata.MD) string
+
+// ByPeer keys buckets by the host of the peer address, so that all
+// connections from one client share a bucket.
+func ByPeer(peer net.Addr, _ metadata.MD) string {
+	if peer == nil {
+		return ""
+	}
+	host, _, err := net.SplitHostPort(peer.String())
+	if err != nil {
+		return peer.String()
+	}
+	return host
+}
+
+// ByMetadata keys buckets by the value of the request metadata key
+func ByMetadata(key string) KeyFunc {
+	return func(_ net.Addr, md metadata.MD) string {
+		return md.Get(key)
+	}
+}
+
+// Rule limits the calls of a method to Rate per second with bursts of up to Burst calls.
+// With a nil Key all callers share one bucket.
+type Rule struct {
+	Rate  float64
+	Burst int
+	Key   KeyFunc
+}
+
+// Limiter holds the rules and token buckets of a server
+type Limiter struct {
+	mutex   sync.Mutex
+	rules   map[string]Rule
+	buckets map[string]*Bucket
+	now     func() time.Time
+}
+
+// NewLimiter returns a Limiter without rules, which allows every call
+func NewLimiter() *Limiter {
+	return &Limiter{
+		rules:   make(map[string]Rule),
+		buckets: make(map[string]*Bucket),
+		now:     time.Now,
+	}
+}
+
+// SetRule sets the rule of serviceMethod, which is either the full
+// "Service.Method" name or "Service.*" for all methods of a service.
+// A rule of an exact name takes precedence over the service wide one,
+// calls of a service wide rule share their buckets.
+func (l *Limiter) SetRule(serviceMethod string, rule Rule) *Limiter {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	l.rules[serviceMethod] = rule
+	// buckets of the former rule are sized after it
+	for k := range l.buckets {
+		if strings.HasPrefix(k, serviceMethod+"\x00") {
+			delete(l.buckets, k)
+		}
+	}
+	return l
+}
+
+// Allow takes a token for a call of serviceMethod. If the call is rejected,
+// it returns false and the time the caller should wait before trying again.
+func (l *Limiter) Allow(serviceMethod string, peer net.Addr, md metadata.MD) (bool, time.Duration) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	name, rule, ok := l.match(serviceMethod)
+	if !ok {
+		return true, 0
+	}
+	key := name + "\x00"
+	if rule.Key != nil {
+		key += rule.Key(peer, md)
+	}
+
+	now := l.now()
+	b, ok := l.buckets[key]
+	if !ok {
+		if len(l.buckets) >= maxBuckets {
+			l.sweep(now)
+		}
+		b = NewBucket(rule.Rate, rule.Burst, now)
+		l.buckets[key] = b
+	}
+	return b.Take(now)
+}
+
+func (l *Limiter) match(serviceMethod string) (string, Rule, bool) {
+	if rule, ok := l.rules[serviceMethod]; ok {
+		return serviceMethod, rule, true
+	}
+	if dot := strings.LastIndex(serviceMethod, "."); dot >= 0 {
+		name := serviceMethod[:dot] + ".*"
+		if rule, ok := l.rules[name]; ok {
+			return name, rule, true
+		}
+	}
+	return "", Rule{}, false
+}
+
+// sweep drops the buckets that have been refilled, they are recreated full on demand
+func (l *Limiter) sweep(now time.Time) {
+	for k, b := range l.buckets {
+		if b.full(now) {
+			delete(l.buckets, k)
+		}
+	}
+}
+
+// RetryAfter returns the retry-after hint of the response metadata md
+func RetryAfter(md metadata.MD) (time.Duration, bool) {
+	d, err := time.ParseDuration(md.Get(RetryAfterKey))
+	if err != nil {
+		return 0, false
+	}
+	return d, true
+}
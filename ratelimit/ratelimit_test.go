@@ -0,0 +1,65 @@
+package ratelimit
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/mizumoto-cn/TRPcG/metadata"
+	"github.com/stretchr/testify/assert"
+)
+
+// TestBucket_Take tests Bucket::Take
+func TestBucket_Take(t *testing.T) {
+	now := time.Unix(0, 0)
+	b := NewBucket(2, 2, now)
+
+	ok, _ := b.Take(now)
+	assert.Equal(t, true, ok)
+	ok, _ = b.Take(now)
+	assert.Equal(t, true, ok)
+	ok, wait := b.Take(now)
+	assert.Equal(t, false, ok)
+	assert.Equal(t, 500*time.Millisecond, wait)
+
+	ok, _ = b.Take(now.Add(500 * time.Millisecond))
+	assert.Equal(t, true, ok)
+}
+
+// TestLimiter_Allow tests Limiter::Allow
+func TestLimiter_Allow(t *testing.T) {
+	now := time.Unix(0, 0)
+	l := NewLimiter().
+		SetRule("Arith.Div", Rule{Rate: 1, Burst: 1, Key: ByPeer}).
+		SetRule("Arith.*", Rule{Rate: 1, Burst: 2, Key: ByMetadata("client-id")})
+	l.now = func() time.Time { return now }
+
+	peer1 := &net.TCPAddr{IP: net.IPv4(10, 0, 0, 1), Port: 1000}
+	peer1b := &net.TCPAddr{IP: net.IPv4(10, 0, 0, 1), Port: 2000}
+	peer2 := &net.TCPAddr{IP: net.IPv4(10, 0, 0, 2), Port: 1000}
+	alice := metadata.Pairs("client-id", "alice")
+	bob := metadata.Pairs("Client-ID", "bob")
+
+	cases := []struct {
+		name          string
+		serviceMethod string
+		peer          net.Addr
+		md            metadata.MD
+		expect        bool
+	}{
+		{"div-peer1", "Arith.Div", peer1, nil, true},
+		{"div-peer1-other-port", "Arith.Div", peer1b, nil, false},
+		{"div-peer2", "Arith.Div", peer2, nil, true},
+		{"add-alice-1", "Arith.Add", peer1, alice, true},
+		{"sub-alice-2", "Arith.Sub", peer2, alice, true},
+		{"mul-alice-3", "Arith.Mul", peer1, alice, false},
+		{"add-bob", "Arith.Add", peer1, bob, true},
+		{"unlimited", "Echo.Echo", peer1, nil, true},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			ok, _ := l.Allow(c.serviceMethod, c.peer, c.md)
+			assert.Equal(t, c.expect, ok)
+		})
+	}
+}
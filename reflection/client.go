@@ -0,0 +1,189 @@
+package reflection
+
+import (
+	"context"
+	"net/rpc"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mizumoto-cn/TRPcG/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+// Caller sends calls to a Reflection service, such as a TRPcG.Client
+type Caller interface {
+	AsyncCall(serviceMethod string, args any, reply any) chan *rpc.Call
+}
+
+func call(ctx context.Context, c Caller, method string, args any, reply any) error {
+	select {
+	case call := <-c.AsyncCall(ServiceName+"."+method, args, reply):
+		if call.Error != nil && strings.Contains(call.Error.Error(), "can't find service "+ServiceName) {
+			return status.Error(status.Unimplemented, "the server has no reflection service, see TRPcG.WithReflection")
+		}
+		return call.Error
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// ListServices returns the services of the server called by c
+func ListServices(ctx context.Context, c Caller) ([]*ServiceInfo, error) {
+	reply := &ListServicesResponse{}
+	if err := call(ctx, c, "ListServices", &ListServicesRequest{}, reply); err != nil {
+		return nil, err
+	}
+	return reply.Services, nil
+}
+
+// MissTTL is how long Types remembers that a method was not found or takes no
+// protobuf messages, before asking the server again
+const MissTTL = 10 * time.Second
+
+// Types resolves the protobuf types of the methods of a server, built from the
+// file descriptors its reflection service sends, so that generic tools can
+// call methods whose messages they are not linked with
+type Types struct {
+	caller  Caller
+	mutex   sync.Mutex
+	files   map[string]*descriptorpb.FileDescriptorProto
+	methods map[string][2]protoreflect.MessageType
+	misses  map[string]miss // failed lookups of methods
+}
+
+// miss is a failed lookup of a method, remembered until expiry
+type miss struct {
+	err    error
+	expiry time.Time
+}
+
+// NewTypes returns the types of the server called by c
+func NewTypes(c Caller) *Types {
+	return &Types{
+		caller:  c,
+		files:   make(map[string]*descriptorpb.FileDescriptorProto),
+		methods: make(map[string][2]protoreflect.MessageType),
+		misses:  make(map[string]miss),
+	}
+}
+
+// Method returns the types of the arguments and of the reply of serviceMethod.
+// It fails with NotFound for methods the server does not serve, and with
+// Unimplemented for those not taking protobuf messages, failures which are
+// remembered for MissTTL.
+func (t *Types) Method(ctx context.Context, serviceMethod string) (args, reply protoreflect.MessageType, err error) {
+	t.mutex.Lock()
+	types, ok := t.methods[serviceMethod]
+	m, missed := t.misses[serviceMethod]
+	if missed && time.Now().After(m.expiry) {
+		delete(t.misses, serviceMethod)
+		missed = false
+	}
+	t.mutex.Unlock()
+	switch {
+	case ok:
+		return types[0], types[1], nil
+	case missed:
+		return nil, nil, m.err
+	}
+
+	args, reply, err = t.method(ctx, serviceMethod)
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	switch status.CodeOf(err) {
+	case status.OK:
+		t.methods[serviceMethod] = [2]protoreflect.MessageType{args, reply}
+	case status.NotFound, status.Unimplemented:
+		t.misses[serviceMethod] = miss{err: err, expiry: time.Now().Add(MissTTL)}
+	}
+	return args, reply, err
+}
+
+// method looks the types of serviceMethod up with the reflection service
+func (t *Types) method(ctx context.Context, serviceMethod string) (args, reply protoreflect.MessageType, err error) {
+	dot := strings.LastIndex(serviceMethod, ".")
+	if dot < 0 {
+		return nil, nil, status.Errorf(status.NotFound, "%q is not a Service.Method name", serviceMethod)
+	}
+	services, err := ListServices(ctx, t.caller)
+	if err != nil {
+		return nil, nil, err
+	}
+	var method *MethodInfo
+	for _, s := range services {
+		if s.Name != serviceMethod[:dot] {
+			continue
+		}
+		for _, m := range s.Methods {
+			if m.Name == serviceMethod[dot+1:] {
+				method = m
+			}
+		}
+	}
+	switch {
+	case method == nil:
+		return nil, nil, status.Errorf(status.NotFound, "unknown method %s", serviceMethod)
+	case method.ArgsProto == "" || method.ReplyProto == "":
+		return nil, nil, status.Errorf(status.Unimplemented, "%s does not take protobuf messages", serviceMethod)
+	}
+	if args, err = t.Message(ctx, method.ArgsProto); err != nil {
+		return nil, nil, err
+	}
+	if reply, err = t.Message(ctx, method.ReplyProto); err != nil {
+		return nil, nil, err
+	}
+	return args, reply, nil
+}
+
+// Message returns the type of the protobuf message name
+func (t *Types) Message(ctx context.Context, name string) (protoreflect.MessageType, error) {
+	d, err := t.Descriptor(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	md, ok := d.(protoreflect.MessageDescriptor)
+	if !ok {
+		return nil, status.Errorf(status.NotFound, "%s is not a message", name)
+	}
+	return dynamicpb.NewMessageType(md), nil
+}
+
+// Descriptor returns the descriptor of the protobuf symbol name,
+// failing with NotFound if the server does not know it
+func (t *Types) Descriptor(ctx context.Context, name string) (protoreflect.Descriptor, error) {
+	reply := &FileResponse{}
+	err := call(ctx, t.caller, "FileContainingSymbol", &FileRequest{Symbol: name}, reply)
+	if status.CodeOf(err) == status.NotFound {
+		return nil, status.Errorf(status.NotFound, "unknown symbol %s", name)
+	}
+	if err != nil {
+		return nil, err
+	}
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	for _, data := range reply.FileDescriptors {
+		fd := &descriptorpb.FileDescriptorProto{}
+		if err := proto.Unmarshal(data, fd); err != nil {
+			return nil, err
+		}
+		t.files[fd.GetName()] = fd
+	}
+	set := &descriptorpb.FileDescriptorSet{}
+	for _, fd := range t.files {
+		set.File = append(set.File, fd)
+	}
+	files, err := protodesc.NewFiles(set)
+	if err != nil {
+		return nil, err
+	}
+	d, err := files.FindDescriptorByName(protoreflect.FullName(name))
+	if err != nil {
+		return nil, status.Errorf(status.NotFound, "unknown symbol %s", name)
+	}
+	return d, nil
+}
@@ -0,0 +1,120 @@
+package reflection
+
+import (
+	"context"
+	"net/rpc"
+	"reflect"
+	"testing"
+
+	"github.com/mizumoto-cn/TRPcG/status"
+	jsonp "github.com/mizumoto-cn/TRPcG/testing/json"
+	message "github.com/mizumoto-cn/TRPcG/testing/message"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+// mixedService has methods net/rpc serves and others it does not
+type mixedService struct{}
+
+func (mixedService) Add(args *message.ArithRequest, reply *message.ArithResponse) error { return nil }
+func (mixedService) Echo(args jsonp.Request, reply *jsonp.Response) error               { return nil }
+func (mixedService) Count(args int, reply *int) error                                   { return nil }
+func (mixedService) NoError(args int, reply *int)                                       {}
+func (mixedService) NoPointer(args int, reply int) error                                { return nil }
+func (mixedService) Close() error                                                       { return nil }
+
+func TestDescribe(t *testing.T) {
+	info := Describe("Mixed", reflect.TypeOf(mixedService{}))
+	assert.Equal(t, "Mixed", info.Name)
+	expect := []*MethodInfo{
+		{Name: "Add", ArgsType: "*message.ArithRequest", ReplyType: "*message.ArithResponse",
+			ArgsProto: "message.ArithRequest", ReplyProto: "message.ArithResponse"},
+		{Name: "Count", ArgsType: "int", ReplyType: "*int"},
+		{Name: "Echo", ArgsType: "json.Request", ReplyType: "*json.Response"},
+	}
+	assert.Equal(t, len(expect), len(info.Methods))
+	for i := range expect {
+		assert.Equal(t, true, proto.Equal(expect[i], info.Methods[i]), info.Methods[i].String())
+	}
+}
+
+func TestServer_ListServices(t *testing.T) {
+	s := NewServer(func() []*ServiceInfo {
+		return []*ServiceInfo{{Name: "Mixed"}, {Name: "Arith"}}
+	})
+	reply := &ListServicesResponse{}
+	assert.Equal(t, nil, s.ListServices(&ListServicesRequest{}, reply))
+	assert.Equal(t, "Arith", reply.Services[0].Name)
+	assert.Equal(t, "Mixed", reply.Services[1].Name)
+}
+
+func TestServer_FileContainingSymbol(t *testing.T) {
+	s := NewServer(nil)
+	reply := &FileResponse{}
+	assert.Equal(t, nil, s.FileContainingSymbol(&FileRequest{Symbol: "message.ArithRequest"}, reply))
+	assert.Equal(t, 1, len(reply.FileDescriptors))
+	fd := &descriptorpb.FileDescriptorProto{}
+	assert.Equal(t, nil, proto.Unmarshal(reply.FileDescriptors[0], fd))
+	assert.Equal(t, "arith.proto", fd.GetName())
+	assert.Equal(t, "ArithService", fd.Service[0].GetName())
+
+	err := s.FileContainingSymbol(&FileRequest{Symbol: "message.Unknown"}, &FileResponse{})
+	assert.Equal(t, status.NotFound, status.CodeOf(err))
+}
+
+// localCaller calls a reflection server in process, counting the calls
+type localCaller struct {
+	server *Server
+	calls  int
+}
+
+func (c *localCaller) AsyncCall(serviceMethod string, args any, reply any) chan *rpc.Call {
+	c.calls++
+	var err error
+	switch serviceMethod {
+	case ServiceName + ".ListServices":
+		err = c.server.ListServices(args.(*ListServicesRequest), reply.(*ListServicesResponse))
+	case ServiceName + ".FileContainingSymbol":
+		err = c.server.FileContainingSymbol(args.(*FileRequest), reply.(*FileResponse))
+	}
+	done := make(chan *rpc.Call, 1)
+	done <- &rpc.Call{ServiceMethod: serviceMethod, Args: args, Reply: reply, Error: err, Done: done}
+	return done
+}
+
+func TestTypes(t *testing.T) {
+	c := &localCaller{server: NewServer(func() []*ServiceInfo {
+		return []*ServiceInfo{Describe("Mixed", reflect.TypeOf(mixedService{}))}
+	})}
+	types := NewTypes(c)
+	ctx := context.Background()
+
+	args, reply, err := types.Method(ctx, "Mixed.Add")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "message.ArithRequest", string(args.Descriptor().FullName()))
+	assert.Equal(t, "message.ArithResponse", string(reply.Descriptor().FullName()))
+	calls := c.calls
+	_, _, err = types.Method(ctx, "Mixed.Add")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, calls, c.calls)
+
+	_, _, err = types.Method(ctx, "Mixed.Nope")
+	assert.Equal(t, status.NotFound, status.CodeOf(err))
+	assert.Equal(t, "unknown method Mixed.Nope", status.Convert(err).Message())
+	_, _, err = types.Method(ctx, "Mixed.Count")
+	assert.Equal(t, status.Unimplemented, status.CodeOf(err))
+	// the failures are remembered
+	calls = c.calls
+	_, _, err = types.Method(ctx, "Mixed.Nope")
+	assert.Equal(t, status.NotFound, status.CodeOf(err))
+	_, _, err = types.Method(ctx, "Mixed.Count")
+	assert.Equal(t, status.Unimplemented, status.CodeOf(err))
+	assert.Equal(t, calls, c.calls)
+
+	d, err := types.Descriptor(ctx, "message.ArithRequest")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "message.ArithRequest", string(d.FullName()))
+	_, err = types.Message(ctx, "message.Nope")
+	assert.Equal(t, "unknown symbol message.Nope", status.Convert(err).Message())
+}
@@ -0,0 +1,110 @@
+package reflection
+
+import (
+	"go/token"
+	"reflect"
+	"sort"
+
+	"github.com/mizumoto-cn/TRPcG/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+)
+
+// ServiceName is the name Server is registered under
+const ServiceName = "Reflection"
+
+// Server implements the Reflection service
+type Server struct {
+	list func() []*ServiceInfo
+}
+
+// NewServer returns a reflection server describing the services returned by list
+func NewServer(list func() []*ServiceInfo) *Server {
+	return &Server{list: list}
+}
+
+// ListServices replies the services of the server, sorted by name
+func (s *Server) ListServices(_ *ListServicesRequest, reply *ListServicesResponse) error {
+	reply.Services = s.list()
+	sort.Slice(reply.Services, func(i, j int) bool { return reply.Services[i].Name < reply.Services[j].Name })
+	return nil
+}
+
+// FileContainingSymbol replies the file descriptors defining a protobuf message,
+// it fails with NotFound for messages not linked into the server
+func (s *Server) FileContainingSymbol(args *FileRequest, reply *FileResponse) error {
+	d, err := protoregistry.GlobalFiles.FindDescriptorByName(protoreflect.FullName(args.Symbol))
+	if err != nil {
+		return status.Errorf(status.NotFound, "unknown symbol %q", args.Symbol)
+	}
+	seen := make(map[string]bool)
+	var add func(f protoreflect.FileDescriptor) error
+	add = func(f protoreflect.FileDescriptor) error {
+		if seen[f.Path()] {
+			return nil
+		}
+		seen[f.Path()] = true
+		imports := f.Imports()
+		for i := 0; i < imports.Len(); i++ {
+			if err := add(imports.Get(i).FileDescriptor); err != nil {
+				return err
+			}
+		}
+		data, err := proto.Marshal(protodesc.ToFileDescriptorProto(f))
+		if err != nil {
+			return err
+		}
+		reply.FileDescriptors = append(reply.FileDescriptors, data)
+		return nil
+	}
+	return add(d.ParentFile())
+}
+
+var (
+	typeOfError = reflect.TypeOf((*error)(nil)).Elem()
+	typeOfProto = reflect.TypeOf((*proto.Message)(nil)).Elem()
+)
+
+// Describe returns the description of the service name served by a receiver of type rcvr,
+// listing the methods net/rpc serves
+func Describe(name string, rcvr reflect.Type) *ServiceInfo {
+	info := &ServiceInfo{Name: name}
+	for i := 0; i < rcvr.NumMethod(); i++ {
+		method := rcvr.Method(i)
+		mtype := method.Type
+		// the rules of net/rpc: func (t *T) Method(args T1, reply *T2) error
+		if !method.IsExported() || mtype.NumIn() != 3 || mtype.NumOut() != 1 || mtype.Out(0) != typeOfError {
+			continue
+		}
+		args, reply := mtype.In(1), mtype.In(2)
+		if !exportedOrBuiltin(args) || reply.Kind() != reflect.Pointer || !exportedOrBuiltin(reply) {
+			continue
+		}
+		info.Methods = append(info.Methods, &MethodInfo{
+			Name:       method.Name,
+			ArgsType:   args.String(),
+			ReplyType:  reply.String(),
+			ArgsProto:  protoName(args),
+			ReplyProto: protoName(reply),
+		})
+	}
+	return info
+}
+
+func exportedOrBuiltin(t reflect.Type) bool {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	return token.IsExported(t.Name()) || t.PkgPath() == ""
+}
+
+// protoName returns the full name of the protobuf message of type t, "" if t is not one
+func protoName(t reflect.Type) string {
+	if !t.Implements(typeOfProto) {
+		return ""
+	}
+	m := reflect.Zero(t).Interface().(proto.Message)
+	return string(m.ProtoReflect().Descriptor().FullName())
+}
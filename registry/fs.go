@@ -0,0 +1,135 @@
+package registry
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// FileSystem is a registry kept in a directory shared by the servers and clients,
+// such as a network file system. Each instance is a JSON file:
+//
+//	<Dir>/<service>/<escaped address>.json
+//
+// Instances of servers which exit without deregistering stay until their file is removed.
+type FileSystem struct {
+	Dir      string
+	Interval time.Duration // between two reads of a watched service, 5s if not set
+}
+
+// path of the file of inst
+func (f *FileSystem) path(inst Instance) string {
+	return filepath.Join(f.Dir, url.PathEscape(inst.Service), url.PathEscape(inst.Addr)+".json")
+}
+
+// Register implements Registry
+func (f *FileSystem) Register(_ context.Context, inst Instance) error {
+	if err := validate(inst); err != nil {
+		return err
+	}
+	data, err := json.Marshal(inst)
+	if err != nil {
+		return err
+	}
+	path := f.path(inst)
+	if err = os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	// watchers never read a partly written file
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".register-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err = tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// Deregister implements Registry
+func (f *FileSystem) Deregister(_ context.Context, inst Instance) error {
+	err := os.Remove(f.path(inst))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+// list reads the instances of service
+func (f *FileSystem) list(service string) ([]Instance, error) {
+	dir := filepath.Join(f.Dir, url.PathEscape(service))
+	files, err := os.ReadDir(dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var instances []Instance
+	for _, file := range files {
+		if file.IsDir() || !strings.HasSuffix(file.Name(), ".json") {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(dir, file.Name()))
+		if errors.Is(err, os.ErrNotExist) {
+			continue // deregistered meanwhile
+		}
+		if err != nil {
+			return nil, err
+		}
+		var inst Instance
+		if err = json.Unmarshal(data, &inst); err != nil {
+			return nil, err
+		}
+		instances = append(instances, inst)
+	}
+	return sorted(instances), nil
+}
+
+// Watch implements Registry, reading the directory of service every Interval
+func (f *FileSystem) Watch(ctx context.Context, service string) (<-chan []Instance, error) {
+	// fail early on an unreadable directory
+	if _, err := f.list(service); err != nil {
+		return nil, err
+	}
+	interval := f.Interval
+	if interval <= 0 {
+		interval = 5 * time.Second
+	}
+	ch := make(chan []Instance, 1)
+	go func() {
+		defer close(ch)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		var last []Instance
+		first := true
+		for {
+			// errors keep the former instances
+			instances, err := f.list(service)
+			if err == nil && (first || !Equal(instances, last)) {
+				select {
+				case ch <- instances:
+					last, first = instances, false
+				case <-ctx.Done():
+					return
+				}
+			}
+			select {
+			case <-ticker.C:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return ch, nil
+}
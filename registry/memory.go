@@ -0,0 +1,106 @@
+package registry
+
+import (
+	"context"
+	"sync"
+)
+
+// Memory is an in-process registry, for servers and clients living in the same process
+type Memory struct {
+	mutex    sync.Mutex
+	services map[string]*entry
+}
+
+// entry holds the instances of a service
+type entry struct {
+	instances map[string]Instance // by address
+	revision  uint64              // incremented on each change
+	changed   chan struct{}       // closed on the next change
+}
+
+// NewMemory returns an empty in-process registry
+func NewMemory() *Memory {
+	return &Memory{services: make(map[string]*entry)}
+}
+
+// get returns the entry of service, creating it if needed. The mutex must be held.
+func (m *Memory) get(service string) *entry {
+	e, ok := m.services[service]
+	if !ok {
+		e = &entry{instances: make(map[string]Instance), changed: make(chan struct{})}
+		m.services[service] = e
+	}
+	return e
+}
+
+// notify wakes up the watchers of e. The mutex must be held.
+func (e *entry) notify() {
+	e.revision++
+	close(e.changed)
+	e.changed = make(chan struct{})
+}
+
+// Register implements Registry
+func (m *Memory) Register(_ context.Context, inst Instance) error {
+	if err := validate(inst); err != nil {
+		return err
+	}
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	e := m.get(inst.Service)
+	e.instances[inst.Addr] = inst
+	e.notify()
+	return nil
+}
+
+// Deregister implements Registry
+func (m *Memory) Deregister(_ context.Context, inst Instance) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	e := m.get(inst.Service)
+	if _, ok := e.instances[inst.Addr]; ok {
+		delete(e.instances, inst.Addr)
+		e.notify()
+	}
+	return nil
+}
+
+// list returns the instances of service, their revision,
+// and a channel closed once they change
+func (m *Memory) list(service string) ([]Instance, uint64, <-chan struct{}) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	e := m.get(service)
+	instances := make([]Instance, 0, len(e.instances))
+	for _, inst := range e.instances {
+		instances = append(instances, inst)
+	}
+	return sorted(instances), e.revision, e.changed
+}
+
+// Watch implements Registry
+func (m *Memory) Watch(ctx context.Context, service string) (<-chan []Instance, error) {
+	ch := make(chan []Instance, 1)
+	go func() {
+		defer close(ch)
+		var last []Instance
+		first := true
+		for {
+			instances, _, changed := m.list(service)
+			if first || !Equal(instances, last) {
+				select {
+				case ch <- instances:
+					last, first = instances, false
+				case <-ctx.Done():
+					return
+				}
+			}
+			select {
+			case <-changed:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return ch, nil
+}
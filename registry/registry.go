@@ -0,0 +1,98 @@
+package registry
+
+import (
+	"context"
+	"sort"
+
+	"github.com/mizumoto-cn/TRPcG/resolver"
+	"github.com/mizumoto-cn/TRPcG/status"
+)
+
+// Instance is a server serving a service at an address
+type Instance struct {
+	Service string            `json:"service" yaml:"service"`
+	Addr    string            `json:"addr" yaml:"addr"`
+	Weight  int               `json:"weight,omitempty" yaml:"weight,omitempty"`
+	Meta    map[string]string `json:"meta,omitempty" yaml:"meta,omitempty"`
+}
+
+// Registry keeps track of the instances of the services
+type Registry interface {
+	// Register adds inst to the instances of its service, replacing the former
+	// registration of the same address
+	Register(ctx context.Context, inst Instance) error
+	// Deregister removes inst from the instances of its service, it is a no-op
+	// if inst is not registered
+	Deregister(ctx context.Context, inst Instance) error
+	// Watch sends the instances of service on the returned channel, first the current
+	// ones, then a new set each time it changes. The channel is closed once ctx is done.
+	Watch(ctx context.Context, service string) (<-chan []Instance, error)
+}
+
+func validate(inst Instance) error {
+	if inst.Service == "" || inst.Addr == "" {
+		return status.Error(status.InvalidArgument, "instance without service or address")
+	}
+	return nil
+}
+
+// NewResolver returns a resolver of the service names registered in r, so that
+// clients can Dial them after resolver.Register("registry", registry.NewResolver(r)):
+//
+//	client, err := TRPcG.Dial("registry://ArithService")
+func NewResolver(r Registry) resolver.Resolver {
+	return registryResolver{r}
+}
+
+type registryResolver struct {
+	registry Registry
+}
+
+func (r registryResolver) Watch(ctx context.Context, service string) (<-chan []resolver.Address, error) {
+	instances, err := r.registry.Watch(ctx, service)
+	if err != nil {
+		return nil, err
+	}
+	ch := make(chan []resolver.Address, 1)
+	go func() {
+		defer close(ch)
+		for set := range instances {
+			addrs := make([]resolver.Address, 0, len(set))
+			for _, inst := range set {
+				addrs = append(addrs, resolver.Address{Addr: inst.Addr, Weight: inst.Weight, Meta: inst.Meta})
+			}
+			select {
+			case ch <- addrs:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return ch, nil
+}
+
+// Equal reports whether a and b hold the same instances, regardless of their order
+func Equal(a, b []Instance) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	a, b = sorted(a), sorted(b)
+	for i := range a {
+		if a[i].Service != b[i].Service || a[i].Addr != b[i].Addr ||
+			a[i].Weight != b[i].Weight || len(a[i].Meta) != len(b[i].Meta) {
+			return false
+		}
+		for k, v := range a[i].Meta {
+			if w, ok := b[i].Meta[k]; !ok || v != w {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func sorted(instances []Instance) []Instance {
+	s := append([]Instance(nil), instances...)
+	sort.Slice(s, func(i, j int) bool { return s[i].Addr < s[j].Addr })
+	return s
+}
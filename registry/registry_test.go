@@ -0,0 +1,177 @@
+package registry
+
+import (
+	"context"
+	"net/rpc"
+	"testing"
+	"time"
+
+	"github.com/mizumoto-cn/TRPcG/resolver"
+	"github.com/mizumoto-cn/TRPcG/status"
+	"github.com/stretchr/testify/assert"
+)
+
+var (
+	inst1 = Instance{Service: "Arith", Addr: "10.0.0.1:8082"}
+	inst2 = Instance{Service: "Arith", Addr: "10.0.0.2:8082", Weight: 2}
+)
+
+// next returns the next instance set sent on ch
+func next(t *testing.T, ch <-chan []Instance) []Instance {
+	select {
+	case instances := <-ch:
+		return instances
+	case <-time.After(5 * time.Second):
+		t.Fatal("no instances sent")
+		return nil
+	}
+}
+
+// testRegistry tests the registration of instances and the notification of the watchers
+func testRegistry(t *testing.T, r Registry) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	err := r.Register(ctx, Instance{Service: "Arith"})
+	assert.Equal(t, status.InvalidArgument, status.CodeOf(err))
+
+	ch, err := r.Watch(ctx, "Arith")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 0, len(next(t, ch)))
+
+	assert.Equal(t, nil, r.Register(ctx, inst1))
+	assert.Equal(t, []Instance{inst1}, next(t, ch))
+	assert.Equal(t, nil, r.Register(ctx, inst2))
+	assert.Equal(t, []Instance{inst1, inst2}, next(t, ch))
+	// other services do not matter
+	assert.Equal(t, nil, r.Register(ctx, Instance{Service: "Echo", Addr: "10.0.0.1:8082"}))
+	assert.Equal(t, nil, r.Deregister(ctx, inst1))
+	assert.Equal(t, []Instance{inst2}, next(t, ch))
+	assert.Equal(t, nil, r.Deregister(ctx, inst1))
+
+	cancel()
+	for range ch {
+	}
+}
+
+func TestMemory(t *testing.T) {
+	testRegistry(t, NewMemory())
+}
+
+func TestFileSystem(t *testing.T) {
+	testRegistry(t, &FileSystem{Dir: t.TempDir(), Interval: 10 * time.Millisecond})
+}
+
+func TestNewResolver(t *testing.T) {
+	m := NewMemory()
+	m.Register(context.Background(), inst2)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ch, err := NewResolver(m).Watch(ctx, "Arith")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, []resolver.Address{{Addr: "10.0.0.2:8082", Weight: 2}}, <-ch)
+}
+
+// TestService_Lease tests the expiry of the leases of the registry service
+func TestService_Lease(t *testing.T) {
+	now := time.Unix(0, 0)
+	s := NewService(time.Hour)
+	defer s.Close()
+	s.now = func() time.Time { return now }
+
+	list := func() []Instance {
+		reply := &WatchResponse{}
+		assert.Equal(t, nil, s.Watch(&WatchRequest{Service: "Arith"}, reply))
+		return reply.Instances
+	}
+
+	reply1, reply2 := &RegisterResponse{}, &RegisterResponse{}
+	assert.Equal(t, nil, s.Register(&RegisterRequest{Instance: inst1, TTL: 3 * time.Second}, reply1))
+	assert.Equal(t, 3*time.Second, reply1.TTL)
+	assert.Equal(t, nil, s.Register(&RegisterRequest{Instance: inst2}, reply2))
+	assert.Equal(t, time.Hour, reply2.TTL)
+	assert.Equal(t, []Instance{inst1, inst2}, list())
+
+	// heartbeats keep the lease alive
+	now = now.Add(2 * time.Second)
+	assert.Equal(t, nil, s.Heartbeat(&HeartbeatRequest{Lease: reply1.Lease}, &HeartbeatResponse{}))
+	now = now.Add(2 * time.Second)
+	s.sweep()
+	assert.Equal(t, []Instance{inst1, inst2}, list())
+
+	now = now.Add(2 * time.Second)
+	s.sweep()
+	assert.Equal(t, []Instance{inst2}, list())
+	err := s.Heartbeat(&HeartbeatRequest{Lease: reply1.Lease}, &HeartbeatResponse{})
+	assert.Equal(t, status.NotFound, status.CodeOf(err))
+
+	// a former lease of a registered again address does not deregister it
+	reply3 := &RegisterResponse{}
+	assert.Equal(t, nil, s.Register(&RegisterRequest{Instance: inst2}, reply3))
+	assert.Equal(t, nil, s.Deregister(&DeregisterRequest{Lease: reply2.Lease}, &DeregisterResponse{}))
+	assert.Equal(t, []Instance{inst2}, list())
+	assert.Equal(t, nil, s.Deregister(&DeregisterRequest{Lease: reply3.Lease}, &DeregisterResponse{}))
+	assert.Equal(t, 0, len(list()))
+}
+
+// TestService_Watch tests the long polling of the registry service
+func TestService_Watch(t *testing.T) {
+	s := NewService(0)
+	defer s.Close()
+
+	reply := &WatchResponse{}
+	assert.Equal(t, nil, s.Watch(&WatchRequest{Service: "Arith"}, reply))
+	assert.Equal(t, 0, len(reply.Instances))
+
+	// no change before the wait is over
+	start := time.Now()
+	assert.Equal(t, nil, s.Watch(&WatchRequest{Service: "Arith", Revision: reply.Revision, Wait: 50 * time.Millisecond}, reply))
+	assert.GreaterOrEqual(t, time.Since(start), 50*time.Millisecond)
+
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		s.Register(&RegisterRequest{Instance: inst1}, &RegisterResponse{})
+	}()
+	assert.Equal(t, nil, s.Watch(&WatchRequest{Service: "Arith", Revision: reply.Revision, Wait: time.Minute}, reply))
+	assert.Equal(t, []Instance{inst1}, reply.Instances)
+}
+
+// grantingCaller answers the calls of Remote with the service, granting the TTL ttl
+type grantingCaller struct {
+	service *Service
+	ttl     time.Duration
+}
+
+func (c grantingCaller) AsyncCall(serviceMethod string, args any, reply any) chan *rpc.Call {
+	var err error
+	switch serviceMethod {
+	case ServiceName + ".Register":
+		err = c.service.Register(args.(*RegisterRequest), reply.(*RegisterResponse))
+		reply.(*RegisterResponse).TTL = c.ttl
+	case ServiceName + ".Deregister":
+		err = c.service.Deregister(args.(*DeregisterRequest), reply.(*DeregisterResponse))
+	case ServiceName + ".Heartbeat":
+		err = c.service.Heartbeat(args.(*HeartbeatRequest), reply.(*HeartbeatResponse))
+	}
+	done := make(chan *rpc.Call, 1)
+	done <- &rpc.Call{ServiceMethod: serviceMethod, Args: args, Reply: reply, Error: err, Done: done}
+	return done
+}
+
+// TestRemote_TTL tests that the leases granted without a TTL are kept alive
+func TestRemote_TTL(t *testing.T) {
+	s := NewService(0)
+	defer s.Close()
+	list := func() []Instance {
+		reply := &WatchResponse{}
+		assert.Equal(t, nil, s.Watch(&WatchRequest{Service: "Arith"}, reply))
+		return reply.Instances
+	}
+	for _, ttl := range []time.Duration{0, 2, -time.Second} {
+		r := NewRemote(grantingCaller{service: s, ttl: ttl}, 0)
+		assert.Equal(t, nil, r.Register(context.Background(), inst1))
+		assert.Equal(t, []Instance{inst1}, list())
+		assert.Equal(t, nil, r.Deregister(context.Background(), inst1))
+		assert.Equal(t, 0, len(list()))
+	}
+}
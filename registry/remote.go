@@ -0,0 +1,161 @@
+package registry
+
+import (
+	"context"
+	"log"
+	"net/rpc"
+	"sync"
+	"time"
+
+	"github.com/mizumoto-cn/TRPcG/status"
+)
+
+// Caller sends calls to a registry Service, such as a TRPcG.Client or TRPcG.ClusterClient
+// using the JSON serializer
+type Caller interface {
+	AsyncCall(serviceMethod string, args any, reply any) chan *rpc.Call
+}
+
+// Remote is the Registry of a Service reached through a Caller.
+// Registered instances are kept alive by heartbeats until deregistered.
+type Remote struct {
+	caller Caller
+	ttl    time.Duration
+	wait   time.Duration // of the watch calls
+
+	mutex  sync.Mutex
+	leases map[string]*remoteLease // by service and address
+}
+
+type remoteLease struct {
+	id     string
+	cancel context.CancelFunc
+}
+
+// NewRemote returns a Registry registering instances with leases of ttl,
+// the default TTL of the service if not set
+func NewRemote(c Caller, ttl time.Duration) *Remote {
+	return &Remote{caller: c, ttl: ttl, wait: 30 * time.Second, leases: make(map[string]*remoteLease)}
+}
+
+// call calls method of the registry service, returning ctx.Err() once ctx is done
+func (r *Remote) call(ctx context.Context, method string, args any, reply any) error {
+	select {
+	case call := <-r.caller.AsyncCall(ServiceName+"."+method, args, reply):
+		return call.Error
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Register implements Registry, heartbeats keep the lease of inst alive until Deregister
+func (r *Remote) Register(ctx context.Context, inst Instance) error {
+	reply := &RegisterResponse{}
+	if err := r.call(ctx, "Register", &RegisterRequest{Instance: inst, TTL: r.ttl}, reply); err != nil {
+		return err
+	}
+	heartbeat, cancel := context.WithCancel(context.Background())
+	l := &remoteLease{id: reply.Lease, cancel: cancel}
+	r.mutex.Lock()
+	if former, ok := r.leases[key(inst)]; ok {
+		former.cancel()
+	}
+	r.leases[key(inst)] = l
+	r.mutex.Unlock()
+	go r.heartbeat(heartbeat, inst, l, reply.TTL)
+	return nil
+}
+
+// heartbeat renews the lease of inst every third of its ttl, registering inst
+// again if the lease expired meanwhile. A ttl too short to be divided is taken
+// for DefaultTTL.
+func (r *Remote) heartbeat(ctx context.Context, inst Instance, l *remoteLease, ttl time.Duration) {
+	if ttl/3 <= 0 {
+		ttl = DefaultTTL
+	}
+	ticker := time.NewTicker(ttl / 3)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+		r.mutex.Lock()
+		id := l.id
+		r.mutex.Unlock()
+		err := r.call(ctx, "Heartbeat", &HeartbeatRequest{Lease: id}, &HeartbeatResponse{})
+		if status.CodeOf(err) == status.NotFound {
+			reply := &RegisterResponse{}
+			err = r.call(ctx, "Register", &RegisterRequest{Instance: inst, TTL: r.ttl}, reply)
+			if err == nil {
+				r.mutex.Lock()
+				l.id = reply.Lease
+				r.mutex.Unlock()
+			}
+		}
+		if err != nil && ctx.Err() == nil {
+			log.Print("trpcg.registry: heartbeat of ", inst.Service, " at ", inst.Addr, ": ", err.Error())
+		}
+	}
+}
+
+// Deregister implements Registry
+func (r *Remote) Deregister(ctx context.Context, inst Instance) error {
+	r.mutex.Lock()
+	l, ok := r.leases[key(inst)]
+	var id string
+	if ok {
+		delete(r.leases, key(inst))
+		l.cancel()
+		// renewed by the heartbeat under the mutex
+		id = l.id
+	}
+	r.mutex.Unlock()
+	if !ok {
+		return nil
+	}
+	return r.call(ctx, "Deregister", &DeregisterRequest{Lease: id}, &DeregisterResponse{})
+}
+
+// Watch implements Registry with long polling calls
+func (r *Remote) Watch(ctx context.Context, service string) (<-chan []Instance, error) {
+	// fail early on an unreachable service
+	first := &WatchResponse{}
+	if err := r.call(ctx, "Watch", &WatchRequest{Service: service}, first); err != nil {
+		return nil, err
+	}
+	ch := make(chan []Instance, 1)
+	ch <- first.Instances
+	go func() {
+		defer close(ch)
+		last, revision := first.Instances, first.Revision
+		for {
+			reply := &WatchResponse{}
+			err := r.call(ctx, "Watch", &WatchRequest{Service: service, Revision: revision, Wait: r.wait}, reply)
+			if ctx.Err() != nil {
+				return
+			}
+			if err != nil {
+				// the former instances are kept until the service answers again
+				select {
+				case <-time.After(time.Second):
+					continue
+				case <-ctx.Done():
+					return
+				}
+			}
+			revision = reply.Revision
+			if Equal(reply.Instances, last) {
+				continue
+			}
+			select {
+			case ch <- reply.Instances:
+				last = reply.Instances
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return ch, nil
+}
@@ -0,0 +1,220 @@
+package registry
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"sync"
+	"time"
+
+	"github.com/mizumoto-cn/TRPcG/status"
+)
+
+// ServiceName is the name Service is registered under
+const ServiceName = "Registry"
+
+type RegisterRequest struct {
+	Instance Instance      `json:"instance"`
+	TTL      time.Duration `json:"ttl,omitempty"` // of the lease, the default TTL of the service if not set
+}
+
+type RegisterResponse struct {
+	Lease string        `json:"lease"`
+	TTL   time.Duration `json:"ttl"` // granted
+}
+
+type HeartbeatRequest struct {
+	Lease string `json:"lease"`
+}
+
+type HeartbeatResponse struct {
+}
+
+type DeregisterRequest struct {
+	Lease string `json:"lease"`
+}
+
+type DeregisterResponse struct {
+}
+
+type WatchRequest struct {
+	Service  string        `json:"service"`
+	Revision uint64        `json:"revision,omitempty"` // of the instances known by the caller, 0 for none
+	Wait     time.Duration `json:"wait,omitempty"`     // for a change at most, 30s if not set
+}
+
+type WatchResponse struct {
+	Instances []Instance `json:"instances"`
+	Revision  uint64     `json:"revision"`
+}
+
+// Service is a registry served over TRPcG, with the JSON serializer:
+//
+//	server := TRPcG.NewServer(TRPcG.WithSerializer(serializer.Json))
+//	server.RegisterName(registry.ServiceName, registry.NewService(10*time.Second))
+//
+// Instances are registered with a lease, and deregistered once their lease
+// expires without a heartbeat. Clients use it through a Remote.
+type Service struct {
+	ttl    time.Duration
+	memory *Memory
+	now    func() time.Time
+
+	mutex  sync.Mutex
+	leases map[string]*lease
+	owners map[string]string // lease of each service and address
+	stop   chan struct{}
+	once   sync.Once
+}
+
+type lease struct {
+	instance Instance
+	ttl      time.Duration
+	expires  time.Time
+}
+
+func key(inst Instance) string {
+	return inst.Service + "/" + inst.Addr
+}
+
+// DefaultTTL is the TTL of the leases granted by the services created without one
+const DefaultTTL = 10 * time.Second
+
+// NewService returns a registry service granting leases of ttl by default, DefaultTTL if not set.
+// Expired leases are swept until Close.
+func NewService(ttl time.Duration) *Service {
+	if ttl <= 0 {
+		ttl = DefaultTTL
+	}
+	s := &Service{
+		ttl:    ttl,
+		memory: NewMemory(),
+		now:    time.Now,
+		leases: make(map[string]*lease),
+		owners: make(map[string]string),
+		stop:   make(chan struct{}),
+	}
+	go func() {
+		ticker := time.NewTicker(ttl / 4)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				s.sweep()
+			case <-s.stop:
+				return
+			}
+		}
+	}()
+	return s
+}
+
+// Close stops sweeping the expired leases
+func (s *Service) Close() error {
+	s.once.Do(func() { close(s.stop) })
+	return nil
+}
+
+// sweep deregisters the instances of the expired leases
+func (s *Service) sweep() {
+	now := s.now()
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for id, l := range s.leases {
+		if now.After(l.expires) {
+			s.release(id, l)
+		}
+	}
+}
+
+// release ends a lease. The mutex must be held.
+func (s *Service) release(id string, l *lease) {
+	delete(s.leases, id)
+	k := key(l.instance)
+	if s.owners[k] == id {
+		delete(s.owners, k)
+		s.memory.Deregister(context.Background(), l.instance)
+	}
+}
+
+// Register registers an instance with a new lease, replacing the lease of the same address
+func (s *Service) Register(args *RegisterRequest, reply *RegisterResponse) error {
+	if err := validate(args.Instance); err != nil {
+		return err
+	}
+	ttl := args.TTL
+	if ttl <= 0 {
+		ttl = s.ttl
+	}
+	id, err := newLeaseID()
+	if err != nil {
+		return err
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	k := key(args.Instance)
+	if former, ok := s.owners[k]; ok {
+		delete(s.leases, former)
+	}
+	s.leases[id] = &lease{instance: args.Instance, ttl: ttl, expires: s.now().Add(ttl)}
+	s.owners[k] = id
+	s.memory.Register(context.Background(), args.Instance)
+	reply.Lease, reply.TTL = id, ttl
+	return nil
+}
+
+// Heartbeat renews a lease, it fails with NotFound once the lease expired
+func (s *Service) Heartbeat(args *HeartbeatRequest, _ *HeartbeatResponse) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	l, ok := s.leases[args.Lease]
+	if !ok {
+		return status.Errorf(status.NotFound, "lease %s not found", args.Lease)
+	}
+	l.expires = s.now().Add(l.ttl)
+	return nil
+}
+
+// Deregister ends a lease and deregisters its instance
+func (s *Service) Deregister(args *DeregisterRequest, _ *DeregisterResponse) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if l, ok := s.leases[args.Lease]; ok {
+		s.release(args.Lease, l)
+	}
+	return nil
+}
+
+// Watch replies the instances of a service once their revision differs from the
+// one of the caller, or once the wait is over
+func (s *Service) Watch(args *WatchRequest, reply *WatchResponse) error {
+	wait := args.Wait
+	if wait <= 0 {
+		wait = 30 * time.Second
+	}
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	for {
+		instances, revision, changed := s.memory.list(args.Service)
+		// revision 0 stands for no known instances
+		revision++
+		if revision != args.Revision {
+			reply.Instances, reply.Revision = instances, revision
+			return nil
+		}
+		select {
+		case <-changed:
+		case <-timer.C:
+			reply.Instances, reply.Revision = instances, revision
+			return nil
+		}
+	}
+}
+
+func newLeaseID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
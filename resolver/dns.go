@@ -0,0 +1,71 @@
+package resolver
+
+import (
+	"context"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DNS resolves "host:port" targets into the A/AAAA records of host, and SRV names
+// such as "_trpcg._tcp.example.com" into the targets, ports and weights of their records.
+// The records are looked up again every Interval.
+type DNS struct {
+	Interval time.Duration // 30s if not set
+	Resolver *net.Resolver // net.DefaultResolver if nil
+}
+
+// Watch polls the records of target
+func (d *DNS) Watch(ctx context.Context, target string) (<-chan []Address, error) {
+	if !strings.HasPrefix(target, "_") {
+		if _, _, err := net.SplitHostPort(target); err != nil {
+			return nil, err
+		}
+	}
+	// fail early on a name which does not resolve, rather than never sending
+	if _, err := d.lookup(ctx, target); err != nil {
+		return nil, err
+	}
+	interval := d.Interval
+	if interval <= 0 {
+		interval = 30 * time.Second
+	}
+	ch := make(chan []Address, 1)
+	go poll(ctx, interval, ch, func() ([]Address, error) {
+		return d.lookup(ctx, target)
+	})
+	return ch, nil
+}
+
+func (d *DNS) lookup(ctx context.Context, target string) ([]Address, error) {
+	r := d.Resolver
+	if r == nil {
+		r = net.DefaultResolver
+	}
+	var addrs []Address
+	if strings.HasPrefix(target, "_") {
+		_, records, err := r.LookupSRV(ctx, "", "", target)
+		if err != nil {
+			return nil, err
+		}
+		for _, srv := range records {
+			host := strings.TrimSuffix(srv.Target, ".")
+			addrs = append(addrs, Address{
+				Addr:   net.JoinHostPort(host, strconv.Itoa(int(srv.Port))),
+				Weight: int(srv.Weight),
+			})
+		}
+		return addrs, nil
+	}
+
+	host, port, _ := net.SplitHostPort(target)
+	hosts, err := r.LookupHost(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+	for _, h := range hosts {
+		addrs = append(addrs, Address{Addr: net.JoinHostPort(h, port)})
+	}
+	return addrs, nil
+}
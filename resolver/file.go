@@ -0,0 +1,53 @@
+package resolver
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// File resolves the path of a JSON or YAML file holding a list of addresses:
+//
+//	[{"addr": "10.0.0.1:8082", "weight": 2}, {"addr": "10.0.0.2:8082"}]
+//
+// Files ending in .yaml or .yml are read as YAML, any other as JSON.
+// The file is read again every Interval, so that edits are picked up.
+type File struct {
+	Interval time.Duration // 5s if not set
+}
+
+// Watch polls the file at path
+func (f *File) Watch(ctx context.Context, path string) (<-chan []Address, error) {
+	// fail early on a missing or malformed file
+	if _, err := readFile(path); err != nil {
+		return nil, err
+	}
+	interval := f.Interval
+	if interval <= 0 {
+		interval = 5 * time.Second
+	}
+	ch := make(chan []Address, 1)
+	go poll(ctx, interval, ch, func() ([]Address, error) {
+		return readFile(path)
+	})
+	return ch, nil
+}
+
+func readFile(path string) ([]Address, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var addrs []Address
+	switch filepath.Ext(path) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, &addrs)
+	default:
+		err = json.Unmarshal(data, &addrs)
+	}
+	return addrs, err
+}
@@ -0,0 +1,142 @@
+package resolver
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+var ErrUnknownScheme = errors.New("no resolver registered for the scheme of the target")
+
+// Address of a backend serving a target
+type Address struct {
+	Addr   string            `json:"addr" yaml:"addr"`
+	Weight int               `json:"weight,omitempty" yaml:"weight,omitempty"` // used by weighted balancers, 1 if not set
+	Meta   map[string]string `json:"meta,omitempty" yaml:"meta,omitempty"`
+}
+
+// Resolver resolves target names into address sets
+type Resolver interface {
+	// Watch sends the address set of target on the returned channel, first the current
+	// one, then a new one each time it changes. The channel is closed once ctx is done.
+	Watch(ctx context.Context, target string) (<-chan []Address, error)
+}
+
+var (
+	mutex     sync.RWMutex
+	resolvers = map[string]Resolver{
+		"static": Static{},
+		"dns":    &DNS{},
+		"file":   &File{},
+	}
+)
+
+// Register makes r resolve the targets of scheme, replacing the former resolver of scheme.
+// static, dns and file are registered by default.
+func Register(scheme string, r Resolver) {
+	mutex.Lock()
+	defer mutex.Unlock()
+	resolvers[scheme] = r
+}
+
+// Get returns the resolver registered for scheme
+func Get(scheme string) (Resolver, bool) {
+	mutex.RLock()
+	defer mutex.RUnlock()
+	r, ok := resolvers[scheme]
+	return r, ok
+}
+
+// Parse splits a "scheme://endpoint" target, such as "dns://example.com:8082"
+// or "file:///etc/trpcg/arith.json". Targets without scheme are static.
+func Parse(target string) (scheme, endpoint string) {
+	i := strings.Index(target, "://")
+	if i < 0 {
+		return "static", target
+	}
+	return target[:i], target[i+len("://"):]
+}
+
+// Watch resolves target with the resolver registered for its scheme
+func Watch(ctx context.Context, target string) (<-chan []Address, error) {
+	scheme, endpoint := Parse(target)
+	r, ok := Get(scheme)
+	if !ok {
+		return nil, ErrUnknownScheme
+	}
+	return r.Watch(ctx, endpoint)
+}
+
+// Static resolves comma separated addresses, like "10.0.0.1:8082,10.0.0.2:8082"
+type Static struct{}
+
+// Watch sends the addresses of target once
+func (Static) Watch(ctx context.Context, target string) (<-chan []Address, error) {
+	var addrs []Address
+	for _, addr := range strings.Split(target, ",") {
+		if addr = strings.TrimSpace(addr); addr != "" {
+			addrs = append(addrs, Address{Addr: addr})
+		}
+	}
+	ch := make(chan []Address, 1)
+	ch <- addrs
+	go func() {
+		<-ctx.Done()
+		close(ch)
+	}()
+	return ch, nil
+}
+
+// poll sends the result of resolve on ch each time it changes, resolve being
+// called every interval until ctx is done. Errors keep the former address set.
+func poll(ctx context.Context, interval time.Duration, ch chan<- []Address, resolve func() ([]Address, error)) {
+	defer close(ch)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	var last []Address
+	first := true
+	for {
+		addrs, err := resolve()
+		if err == nil && (first || !Equal(addrs, last)) {
+			select {
+			case ch <- addrs:
+				last, first = addrs, false
+			case <-ctx.Done():
+				return
+			}
+		}
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// Equal reports whether a and b hold the same addresses, regardless of their order
+func Equal(a, b []Address) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	a, b = sorted(a), sorted(b)
+	for i := range a {
+		if a[i].Addr != b[i].Addr || a[i].Weight != b[i].Weight || len(a[i].Meta) != len(b[i].Meta) {
+			return false
+		}
+		for k, v := range a[i].Meta {
+			if w, ok := b[i].Meta[k]; !ok || v != w {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func sorted(addrs []Address) []Address {
+	s := append([]Address(nil), addrs...)
+	sort.Slice(s, func(i, j int) bool { return s[i].Addr < s[j].Addr })
+	return s
+}
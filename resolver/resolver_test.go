@@ -0,0 +1,98 @@
+package resolver
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestParse tests Parse
+func TestParse(t *testing.T) {
+	cases := []struct {
+		target   string
+		scheme   string
+		endpoint string
+	}{
+		{"dns://example.com:8082", "dns", "example.com:8082"},
+		{"file:///etc/trpcg/arith.json", "file", "/etc/trpcg/arith.json"},
+		{"127.0.0.1:8082", "static", "127.0.0.1:8082"},
+	}
+	for _, c := range cases {
+		t.Run(c.target, func(t *testing.T) {
+			scheme, endpoint := Parse(c.target)
+			assert.Equal(t, c.scheme, scheme)
+			assert.Equal(t, c.endpoint, endpoint)
+		})
+	}
+}
+
+// TestStatic_Watch tests Static::Watch
+func TestStatic_Watch(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	ch, err := Watch(ctx, "static://127.0.0.1:1, 127.0.0.1:2")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, []Address{{Addr: "127.0.0.1:1"}, {Addr: "127.0.0.1:2"}}, <-ch)
+	cancel()
+	_, ok := <-ch
+	assert.Equal(t, false, ok)
+}
+
+// TestDNS_Watch tests DNS::Watch with a name resolved locally
+func TestDNS_Watch(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ch, err := (&DNS{}).Watch(ctx, "localhost:8082")
+	assert.Equal(t, nil, err)
+	addrs := <-ch
+	assert.NotEqual(t, 0, len(addrs))
+	for _, addr := range addrs {
+		assert.Contains(t, []string{"127.0.0.1:8082", "[::1]:8082"}, addr.Addr)
+	}
+
+	_, err = (&DNS{}).Watch(ctx, "localhost")
+	assert.NotEqual(t, nil, err)
+	_, err = (&DNS{}).Watch(ctx, "does-not-resolve.invalid:80")
+	assert.NotEqual(t, nil, err)
+}
+
+// TestFile_Watch tests that File picks up the edits of JSON and YAML files
+func TestFile_Watch(t *testing.T) {
+	cases := []struct {
+		name    string
+		content [2]string
+	}{
+		{"addrs.json", [2]string{
+			`[{"addr": "10.0.0.1:8082", "weight": 2}]`,
+			`[{"addr": "10.0.0.1:8082", "weight": 2}, {"addr": "10.0.0.2:8082", "meta": {"zone": "b"}}]`,
+		}},
+		{"addrs.yaml", [2]string{
+			"- addr: 10.0.0.1:8082\n  weight: 2\n",
+			"- addr: 10.0.0.1:8082\n  weight: 2\n- addr: 10.0.0.2:8082\n  meta:\n    zone: b\n",
+		}},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), c.name)
+			assert.Equal(t, nil, os.WriteFile(path, []byte(c.content[0]), 0o644))
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			ch, err := (&File{Interval: 10 * time.Millisecond}).Watch(ctx, path)
+			assert.Equal(t, nil, err)
+			assert.Equal(t, []Address{{Addr: "10.0.0.1:8082", Weight: 2}}, <-ch)
+
+			assert.Equal(t, nil, os.WriteFile(path, []byte(c.content[1]), 0o644))
+			assert.Equal(t, []Address{
+				{Addr: "10.0.0.1:8082", Weight: 2},
+				{Addr: "10.0.0.2:8082", Meta: map[string]string{"zone": "b"}},
+			}, <-ch)
+		})
+	}
+
+	_, err := (&File{}).Watch(context.Background(), filepath.Join(t.TempDir(), "missing.json"))
+	assert.NotEqual(t, nil, err)
+}
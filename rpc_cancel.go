@@ -0,0 +1,67 @@
+package TRPcG
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"net/rpc"
+	"sync"
+
+	"github.com/mizumoto-cn/TRPcG/codec"
+	"github.com/mizumoto-cn/TRPcG/metadata"
+	"google.golang.org/protobuf/types/known/emptypb"
+)
+
+// CallIDKey is the metadata key naming a call, so that its client can cancel it.
+// The attempts of hedged calls carry one, the losing attempts being cancelled.
+const CallIDKey = "call-id"
+
+// callServiceName is the built-in service receiving the cancellations of calls
+const callServiceName = "trpcg.Call"
+
+// cancelMethod cancels the call named by the CallIDKey metadata of its request,
+// on the same connection
+const cancelMethod = callServiceName + ".Cancel"
+
+// callService answers the cancellations, which the server codecs apply before
+// the requests reach it
+type callService struct{}
+
+// Cancel answers a cancellation
+func (callService) Cancel(*emptypb.Empty, *emptypb.Empty) error {
+	return nil
+}
+
+// contexts maps the args of the calls being served to their context
+var contexts sync.Map
+
+// ContextOf returns the context of the call whose service method received args,
+// the pointer it was given, or context.Background() if there is none. The context
+// is done once the client cancels the call or the connection is closed, long
+// methods can watch it to stop the work nobody waits for anymore.
+func ContextOf(args any) context.Context {
+	if ctx, ok := contexts.Load(args); ok {
+		return ctx.(context.Context)
+	}
+	return context.Background()
+}
+
+// newCallID returns a random call id
+func newCallID() string {
+	var b [8]byte
+	rand.Read(b[:])
+	return hex.EncodeToString(b[:])
+}
+
+// cancelCall asks the server to cancel the call of c named id, without waiting for its answer
+func (c *Client) cancelCall(id string) {
+	e := &codec.Envelope{Args: &emptypb.Empty{}, Metadata: metadata.Pairs(CallIDKey, id)}
+	c.Go(cancelMethod, e, &emptypb.Empty{}, make(chan *rpc.Call, 1))
+}
+
+// serverCall is a call being served by an interceptCodec
+type serverCall struct {
+	id     string // CallIDKey of the request, empty if none
+	args   any
+	cancel context.CancelFunc
+}
@@ -1,24 +1,66 @@
 package TRPcG
 
 import (
+	"context"
 	"io"
+	"net"
+	"net/http"
 	"net/rpc"
+	"strings"
 
+	"github.com/mizumoto-cn/TRPcG/balancer"
+	"github.com/mizumoto-cn/TRPcG/breaker"
 	"github.com/mizumoto-cn/TRPcG/codec"
 	"github.com/mizumoto-cn/TRPcG/compressor"
+	"github.com/mizumoto-cn/TRPcG/metadata"
+	"github.com/mizumoto-cn/TRPcG/registry"
+	"github.com/mizumoto-cn/TRPcG/resolver"
 	"github.com/mizumoto-cn/TRPcG/serializer"
 )
 
 type Client struct {
 	*rpc.Client
+	target        string // remote address of the connection, unless set by WithTarget
+	retryPolicies map[string]RetryPolicy
+	hedgePolicies map[string]HedgePolicy
+	breakers      *breaker.Group
 }
 
+// Invoker makes the calls of the clients generated by protoc-gen-trpcg,
+// implemented by Client and ClusterClient
+type Invoker interface {
+	CallContext(ctx context.Context, serviceMethod string, args any, reply any, opts ...CallOption) error
+	AsyncCall(serviceMethod string, args any, reply any) chan *rpc.Call
+}
+
+var (
+	_ Invoker = (*Client)(nil)
+	_ Invoker = (*ClusterClient)(nil)
+)
+
 // Functional Options Pattern
 type Option func(o *options)
 
 type options struct {
 	compressType compressor.CompressType
 	serializer   serializer.Serializer
+	interceptors []Interceptor
+
+	target        string
+	retryPolicies map[string]RetryPolicy
+	hedgePolicies map[string]HedgePolicy
+	breakers      *breaker.Group
+	resolver      resolver.Resolver
+	dialer        Dialer
+	balancer      balancer.Balancer
+	healthCheck   bool
+	healthService string
+
+	registry    registry.Registry
+	advertise   string
+	reflection  bool
+	http        bool
+	httpHandler http.Handler
 }
 
 // set compression type
@@ -44,17 +86,132 @@ func NewClient(conn io.ReadWriteCloser, args ...Option) *Client {
 	for _, option := range args {
 		option(&options)
 	}
+	if c, ok := conn.(net.Conn); ok && options.target == "" {
+		options.target = c.RemoteAddr().String()
+	}
 	return &Client{
-		rpc.NewClientWithCodec(codec.NewClientCodec(conn, options.compressType, options.serializer)),
+		Client:        rpc.NewClientWithCodec(codec.NewClientCodec(conn, options.compressType, options.serializer)),
+		target:        options.target,
+		retryPolicies: options.retryPolicies,
+		hedgePolicies: options.hedgePolicies,
+		breakers:      options.breakers,
 	}
 }
 
-// synchronous call
+// synchronous call, retried according to the retry policy of serviceMethod
 func (c *Client) Call(serviceMethod string, args any, reply any) error {
-	return c.Client.Call(serviceMethod, args, reply)
+	return c.CallContext(context.Background(), serviceMethod, args, reply)
 }
 
 // Async call  asynchronously calls the rpc function and returns a channel of *rpc.Call
 func (c *Client) AsyncCall(serviceMethod string, args any, reply any) chan *rpc.Call {
-	return c.Go(serviceMethod, args, reply, nil).Done
+	if c.breakers == nil {
+		return c.Go(serviceMethod, args, reply, nil).Done
+	}
+	done := make(chan *rpc.Call, 1)
+	report, err := c.breakers.Get(c.target, serviceMethod).Allow()
+	if err != nil {
+		done <- &rpc.Call{ServiceMethod: serviceMethod, Args: args, Reply: reply, Error: err, Done: done}
+		return done
+	}
+	call := c.Go(serviceMethod, args, reply, make(chan *rpc.Call, 1))
+	go func() {
+		call := <-call.Done
+		report(call.Error)
+		done <- call
+	}()
+	return done
+}
+
+// CallOption configures a single call made by CallContext
+type CallOption func(e *codec.Envelope)
+
+// Header stores the metadata of the response header into md
+func Header(md *metadata.MD) CallOption {
+	return func(e *codec.Envelope) {
+		e.Header = md
+	}
+}
+
+// CallContext calls the rpc function synchronously, sending the outgoing metadata of ctx
+// along with the request. It returns ctx.Err() as soon as ctx is done; the pending call is
+// then abandoned, and reply must not be used as the response may still be written into it.
+// Failed calls are retried according to the retry policy of serviceMethod,
+// each attempt being hedged according to its hedge policy.
+func (c *Client) CallContext(ctx context.Context, serviceMethod string, args any, reply any, opts ...CallOption) error {
+	policy, ok := lookup(c.retryPolicies, serviceMethod)
+	if !ok {
+		return c.callContext(ctx, serviceMethod, args, reply, opts)
+	}
+	return withRetry(ctx, policy, false, opts, func(ctx context.Context, opts []CallOption) error {
+		return c.callContext(ctx, serviceMethod, args, reply, opts)
+	})
+}
+
+// callContext makes a single attempt of CallContext
+func (c *Client) callContext(ctx context.Context, serviceMethod string, args any, reply any, opts []CallOption) error {
+	e := newEnvelope(ctx, args, opts)
+	if policy, ok := lookup(c.hedgePolicies, serviceMethod); ok {
+		return c.hedgeContext(ctx, policy, serviceMethod, e, reply, nil)
+	}
+	return c.send(ctx, serviceMethod, e, reply)
+}
+
+// send sends the call wrapped in e and waits for its response
+func (c *Client) send(ctx context.Context, serviceMethod string, e *codec.Envelope, reply any) (err error) {
+	if c.breakers != nil {
+		report, open := c.breakers.Get(c.target, serviceMethod).Allow()
+		if open != nil {
+			return open
+		}
+		defer func() { report(err) }()
+	}
+	call := c.Go(serviceMethod, e, reply, make(chan *rpc.Call, 1))
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case call = <-call.Done:
+		return call.Error
+	}
+}
+
+// newEnvelope wraps args with the outgoing metadata of ctx and applies opts
+func newEnvelope(ctx context.Context, args any, opts []CallOption) *codec.Envelope {
+	e := &codec.Envelope{Args: args}
+	e.Metadata, _ = metadata.FromOutgoingContext(ctx)
+	for _, opt := range opts {
+		opt(e)
+	}
+	return e
+}
+
+// WithTarget names the target of the client, which keys its circuit breakers.
+// It defaults to the remote address of the connection.
+func WithTarget(target string) Option {
+	return func(o *options) {
+		o.target = target
+	}
+}
+
+// WithCircuitBreaker guards the calls of the client with the breakers of g.
+// Calls fail fast with breaker.ErrOpen while the breaker of their target is open.
+func WithCircuitBreaker(g *breaker.Group) Option {
+	return func(o *options) {
+		o.breakers = g
+	}
+}
+
+// lookup returns the entry of serviceMethod in a map keyed by "Service.Method",
+// "Service.*" or "*", the most specific key winning.
+func lookup[T any](m map[string]T, serviceMethod string) (T, bool) {
+	if v, ok := m[serviceMethod]; ok {
+		return v, true
+	}
+	if dot := strings.LastIndex(serviceMethod, "."); dot >= 0 {
+		if v, ok := m[serviceMethod[:dot]+".*"]; ok {
+			return v, true
+		}
+	}
+	v, ok := m["*"]
+	return v, ok
 }
@@ -0,0 +1,539 @@
+package TRPcG
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/rpc"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/mizumoto-cn/TRPcG/balancer"
+	"github.com/mizumoto-cn/TRPcG/health"
+	"github.com/mizumoto-cn/TRPcG/metadata"
+	"github.com/mizumoto-cn/TRPcG/resolver"
+	"github.com/mizumoto-cn/TRPcG/status"
+)
+
+const (
+	dialBackoff  = time.Second     // time a backend is skipped after failing to dial it
+	dialTimeout  = 5 * time.Second // of the dials of the backends
+	checkTimeout = 5 * time.Second // of the first health check of the backends
+)
+
+// Dialer connects to the address of a backend
+type Dialer func(ctx context.Context, addr string) (net.Conn, error)
+
+// WithResolver resolves the target of Dial with r instead of the resolver registered for its scheme.
+// r is given the whole target.
+func WithResolver(r resolver.Resolver) Option {
+	return func(o *options) {
+		o.resolver = r
+	}
+}
+
+// WithDialer connects to the backends of Dial with d instead of a TCP dialer
+func WithDialer(d Dialer) Option {
+	return func(o *options) {
+		o.dialer = d
+	}
+}
+
+// WithBalancer picks the backends of the calls of Dial with b instead of balancer.RoundRobin
+func WithBalancer(b balancer.Balancer) Option {
+	return func(o *options) {
+		o.balancer = b
+	}
+}
+
+// WithHealthCheck takes the backends of Dial out of rotation while the health service
+// of their server reports service other than SERVING, "" standing for the whole server.
+// Backends whose server has no health service stay in rotation.
+func WithHealthCheck(service string) Option {
+	return func(o *options) {
+		o.healthCheck, o.healthService = true, service
+	}
+}
+
+// ClusterClient is a client of a target served by several backends.
+// It watches the addresses of the backends through a resolver,
+// and dials a Client to each of them on demand.
+type ClusterClient struct {
+	target        string
+	options       []Option // of the backend clients
+	dialer        Dialer
+	retryPolicies map[string]RetryPolicy
+	hedgePolicies map[string]HedgePolicy
+	balancer      balancer.Balancer
+	healthCheck   bool
+	healthService string
+	ctx           context.Context // done once the client is closed
+	cancel        context.CancelFunc
+
+	mutex    sync.Mutex
+	backends []*backend // in the order of the resolver
+	ready    chan struct{}
+}
+
+// backend implements balancer.Backend
+type backend struct {
+	inflight int64 // accessed atomically
+	address  resolver.Address
+
+	mutex      sync.Mutex // never held while dialing
+	client     *Client
+	serving    bool          // as reported by the health service of the client
+	connecting chan struct{} // closed once the dial in flight is over, nil if none
+	removed    bool
+	retryAt    time.Time // no dial before
+	dialErr    error     // of the last dial which failed
+}
+
+// Dial returns a ClusterClient of target, such as "dns://trpcg.example.com:8082".
+// The resolver registered for the scheme of the target watches its addresses,
+// see resolver.Parse. Retry and hedge policies apply to the cluster, a retried call
+// may go to another backend and the copies of a hedged call go to different backends.
+// Other options apply to every backend client.
+func Dial(target string, opts ...Option) (*ClusterClient, error) {
+	var o options
+	for _, opt := range opts {
+		opt(&o)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	var (
+		addrs <-chan []resolver.Address
+		err   error
+	)
+	if o.resolver != nil {
+		addrs, err = o.resolver.Watch(ctx, target)
+	} else {
+		addrs, err = resolver.Watch(ctx, target)
+	}
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+
+	dialer := o.dialer
+	if dialer == nil {
+		d := &net.Dialer{}
+		dialer = func(ctx context.Context, addr string) (net.Conn, error) {
+			return d.DialContext(ctx, "tcp", addr)
+		}
+	}
+	if o.balancer == nil {
+		o.balancer = balancer.RoundRobin()
+	}
+	cc := &ClusterClient{
+		target:        target,
+		options:       opts,
+		dialer:        dialer,
+		retryPolicies: o.retryPolicies,
+		hedgePolicies: o.hedgePolicies,
+		balancer:      o.balancer,
+		healthCheck:   o.healthCheck,
+		healthService: o.healthService,
+		ctx:           ctx,
+		cancel:        cancel,
+		ready:         make(chan struct{}),
+	}
+	go func() {
+		for set := range addrs {
+			cc.update(set)
+		}
+	}()
+	return cc, nil
+}
+
+// update replaces the backends by the ones of addrs, keeping the clients of the known addresses
+func (cc *ClusterClient) update(addrs []resolver.Address) {
+	cc.mutex.Lock()
+	defer cc.mutex.Unlock()
+	known := make(map[string]*backend, len(cc.backends))
+	for _, b := range cc.backends {
+		known[b.address.Addr] = b
+	}
+	backends := make([]*backend, 0, len(addrs))
+	for _, addr := range addrs {
+		b, ok := known[addr.Addr]
+		if ok {
+			delete(known, addr.Addr)
+		} else {
+			b = &backend{}
+		}
+		// get reads the address under the mutex of the backend
+		b.mutex.Lock()
+		b.address = addr
+		b.mutex.Unlock()
+		backends = append(backends, b)
+	}
+	for _, b := range known {
+		b.close()
+	}
+	cc.backends = backends
+	if u, ok := cc.balancer.(balancer.Updater); ok {
+		u.Update(addrs)
+	}
+	select {
+	case <-cc.ready:
+	default:
+		close(cc.ready)
+	}
+}
+
+// Backends returns the addresses of the backends
+func (cc *ClusterClient) Backends() []resolver.Address {
+	cc.mutex.Lock()
+	defer cc.mutex.Unlock()
+	addrs := make([]resolver.Address, 0, len(cc.backends))
+	for _, b := range cc.backends {
+		addrs = append(addrs, b.address)
+	}
+	return addrs
+}
+
+// Close stops watching the target and closes the backend clients
+func (cc *ClusterClient) Close() error {
+	cc.cancel()
+	cc.mutex.Lock()
+	defer cc.mutex.Unlock()
+	for _, b := range cc.backends {
+		b.close()
+	}
+	cc.backends = nil
+	return nil
+}
+
+// Call calls the rpc function of a backend synchronously
+func (cc *ClusterClient) Call(serviceMethod string, args any, reply any) error {
+	return cc.CallContext(context.Background(), serviceMethod, args, reply)
+}
+
+// CallContext is like Client.CallContext, each attempt going to the next backend
+func (cc *ClusterClient) CallContext(ctx context.Context, serviceMethod string, args any, reply any, opts ...CallOption) error {
+	policy, ok := lookup(cc.retryPolicies, serviceMethod)
+	if !ok {
+		return cc.callContext(ctx, serviceMethod, args, reply, opts)
+	}
+	return withRetry(ctx, policy, true, opts, func(ctx context.Context, opts []CallOption) error {
+		return cc.callContext(ctx, serviceMethod, args, reply, opts)
+	})
+}
+
+// AsyncCall asynchronously calls the rpc function of a backend
+func (cc *ClusterClient) AsyncCall(serviceMethod string, args any, reply any) chan *rpc.Call {
+	done := make(chan *rpc.Call, 1)
+	b, client, err := cc.pick(context.Background(), balancer.PickInfo{ServiceMethod: serviceMethod})
+	if err != nil {
+		done <- &rpc.Call{ServiceMethod: serviceMethod, Args: args, Reply: reply, Error: err, Done: done}
+		return done
+	}
+	answered := b.attempt(client)
+	ch := client.AsyncCall(serviceMethod, args, reply)
+	go func() {
+		call := <-ch
+		answered(call.Error)
+		done <- call
+	}()
+	return done
+}
+
+func (cc *ClusterClient) callContext(ctx context.Context, serviceMethod string, args any, reply any, opts []CallOption) error {
+	info := balancer.PickInfo{ServiceMethod: serviceMethod}
+	info.Metadata, _ = metadata.FromOutgoingContext(ctx)
+	b, client, err := cc.pick(ctx, info)
+	if err != nil {
+		return err
+	}
+	e := newEnvelope(ctx, args, opts)
+	policy, ok := lookup(cc.hedgePolicies, serviceMethod)
+	if !ok {
+		answered := b.attempt(client)
+		err = client.send(ctx, serviceMethod, e, reply)
+		answered(err)
+		return err
+	}
+	// every attempt is counted on, and drops, the backend it is sent to
+	backends := map[*Client]*backend{client: b}
+	policy.Alternates = cc.alternates(ctx, info, backends, policy.MaxAttempts)
+	return client.hedgeContext(ctx, policy, serviceMethod, e, reply, func(client *Client) func(error) {
+		return backends[client].attempt(client)
+	})
+}
+
+// pick returns an available backend chosen by the balancer and its client, waiting
+// for the first address set. The backends of exclude are not considered.
+func (cc *ClusterClient) pick(ctx context.Context, info balancer.PickInfo, exclude ...*backend) (*backend, *Client, error) {
+	select {
+	case <-cc.ready:
+	case <-ctx.Done():
+		return nil, nil, ctx.Err()
+	}
+	cc.mutex.Lock()
+	backends := cc.backends
+	cc.mutex.Unlock()
+
+	var candidates, dialing []balancer.Backend
+	for _, b := range backends {
+		if contains(exclude, b) {
+			continue
+		}
+		if ok, inFlight := b.available(); ok {
+			candidates = append(candidates, b)
+		} else if inFlight {
+			dialing = append(dialing, b)
+		}
+	}
+	// calls wait for the dials in flight only if no other backend is available
+	if len(candidates) == 0 {
+		candidates = dialing
+	}
+	var lastErr error
+	for len(candidates) > 0 {
+		picked, err := cc.balancer.Pick(info, candidates)
+		if err != nil {
+			lastErr = err
+			break
+		}
+		b := picked.(*backend)
+		client, err := b.get(ctx, cc)
+		if err == nil {
+			return b, client, nil
+		}
+		lastErr = err
+		// the balancer picks again among the others
+		for i, c := range candidates {
+			if c == picked {
+				candidates = append(candidates[:i:i], candidates[i+1:]...)
+				break
+			}
+		}
+	}
+	if lastErr == nil {
+		return nil, nil, status.Errorf(status.Unavailable, "no backend of %s available", cc.target)
+	}
+	return nil, nil, status.Errorf(status.Unavailable, "no backend of %s available: %v", cc.target, lastErr)
+}
+
+// alternates picks the clients receiving the copies of a hedged call, sent first to the
+// only client of backends, adding them to backends
+func (cc *ClusterClient) alternates(ctx context.Context, info balancer.PickInfo, backends map[*Client]*backend, attempts int) []*Client {
+	if attempts <= 0 {
+		attempts = 2
+	}
+	var clients []*Client
+	var exclude []*backend
+	for _, b := range backends {
+		exclude = append(exclude, b)
+	}
+	for i := 1; i < attempts; i++ {
+		b, client, err := cc.pick(ctx, info, exclude...)
+		if err != nil {
+			break
+		}
+		exclude = append(exclude, b)
+		clients = append(clients, client)
+		backends[client] = b
+	}
+	return clients
+}
+
+func contains(backends []*backend, b *backend) bool {
+	for _, c := range backends {
+		if c == b {
+			return true
+		}
+	}
+	return false
+}
+
+// Address implements balancer.Backend
+func (b *backend) Address() resolver.Address {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return b.address
+}
+
+// InFlight implements balancer.Backend
+func (b *backend) InFlight() int {
+	return int(atomic.LoadInt64(&b.inflight))
+}
+
+// available reports whether the backend is connected and serving, or may be dialed,
+// and whether it is being dialed. It does not wait for the dial in flight.
+func (b *backend) available() (ok bool, dialing bool) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	switch {
+	case b.removed:
+		return false, false
+	case b.connecting != nil:
+		return false, true
+	case b.client != nil:
+		return b.serving, false
+	}
+	return !time.Now().Before(b.retryAt), false
+}
+
+// get returns the client of the backend, dialing it if needed. Concurrent calls
+// share the dial in flight, waiting for it as long as their ctx allows.
+func (b *backend) get(ctx context.Context, cc *ClusterClient) (*Client, error) {
+	for {
+		b.mutex.Lock()
+		switch {
+		case b.removed:
+			b.mutex.Unlock()
+			return nil, errBackendRemoved
+		case b.client != nil:
+			client, serving := b.client, b.serving
+			b.mutex.Unlock()
+			if !serving {
+				return nil, errBackendNotServing
+			}
+			return client, nil
+		case b.connecting == nil && time.Now().Before(b.retryAt):
+			err := b.dialErr
+			b.mutex.Unlock()
+			return nil, err
+		case b.connecting == nil:
+			b.connecting = make(chan struct{})
+			go b.connect(cc, b.address.Addr, b.connecting)
+		}
+		connecting := b.connecting
+		b.mutex.Unlock()
+
+		select {
+		case <-connecting:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// connect connects a client to addr without the mutex, then publishes it, or the
+// error which keeps the backend out of rotation for dialBackoff. It closes done once over.
+func (b *backend) connect(cc *ClusterClient, addr string, done chan struct{}) {
+	client, serving, err := cc.connect(addr)
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	defer close(done)
+	b.connecting = nil
+	if err != nil {
+		b.retryAt, b.dialErr = time.Now().Add(dialBackoff), err
+		return
+	}
+	if b.removed {
+		client.Close()
+		return
+	}
+	b.client, b.serving = client, serving
+	if cc.healthCheck {
+		go b.watchHealth(client, cc.healthService)
+	}
+}
+
+// connect dials a client to addr, and checks its health if required,
+// reporting whether it is serving
+func (cc *ClusterClient) connect(addr string) (*Client, bool, error) {
+	ctx, cancel := context.WithTimeout(cc.ctx, dialTimeout)
+	defer cancel()
+	conn, err := cc.dialer(ctx, addr)
+	if err != nil {
+		return nil, false, err
+	}
+	// policies are handled by the cluster, breakers are keyed by backend
+	opts := append(cc.options[:len(cc.options):len(cc.options)], WithTarget(addr), func(o *options) {
+		o.retryPolicies, o.hedgePolicies = nil, nil
+	})
+	client := NewClient(conn, opts...)
+	if !cc.healthCheck {
+		return client, true, nil
+	}
+
+	ctx, cancel = context.WithTimeout(cc.ctx, checkTimeout)
+	defer cancel()
+	st, err := health.Check(ctx, client, cc.healthService)
+	if broken(err) || ctx.Err() != nil {
+		client.Close()
+		if err == nil {
+			err = ctx.Err()
+		}
+		return nil, false, err
+	}
+	if err == nil || status.CodeOf(err) == status.NotFound {
+		// unknown services are not served
+		return client, st == health.ServingStatus_SERVING, nil
+	}
+	// no health service, the backend stays in rotation
+	return client, true, nil
+}
+
+// watchHealth follows the status of service reported by the health service of client,
+// until client is closed
+func (b *backend) watchHealth(client *Client, service string) {
+	err := health.Watch(context.Background(), client, service, func(st health.ServingStatus) {
+		b.mutex.Lock()
+		defer b.mutex.Unlock()
+		if b.client == client {
+			b.serving = st == health.ServingStatus_SERVING
+		}
+	})
+	if broken(err) {
+		b.drop(client, err)
+		return
+	}
+	// no health service, the backend stays in rotation
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	if b.client == client {
+		b.serving = true
+	}
+}
+
+// attempt counts a call sent to client of the backend in flight, and returns the function
+// called with its error once answered, which drops client if the call broke it
+func (b *backend) attempt(client *Client) func(err error) {
+	atomic.AddInt64(&b.inflight, 1)
+	return func(err error) {
+		atomic.AddInt64(&b.inflight, -1)
+		b.drop(client, err)
+	}
+}
+
+// drop forgets client once its connection is broken, the next call dials again
+func (b *backend) drop(client *Client, err error) {
+	if !broken(err) {
+		return
+	}
+	b.mutex.Lock()
+	if b.client == client {
+		b.client = nil
+	}
+	b.mutex.Unlock()
+	client.Close()
+}
+
+func (b *backend) close() {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.removed = true
+	if b.client != nil {
+		b.client.Close()
+		b.client = nil
+	}
+}
+
+var (
+	errBackendRemoved    = errors.New("backend removed")
+	errBackendNotServing = errors.New("backend not serving")
+)
+
+// broken reports whether err stems from a broken or closed connection
+func broken(err error) bool {
+	var netErr net.Error
+	return errors.Is(err, rpc.ErrShutdown) || errors.Is(err, io.EOF) ||
+		errors.Is(err, io.ErrUnexpectedEOF) || errors.As(err, &netErr)
+}
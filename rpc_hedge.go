@@ -0,0 +1,217 @@
+package TRPcG
+
+import (
+	"context"
+	"net/rpc"
+	"reflect"
+	"time"
+
+	"github.com/mizumoto-cn/TRPcG/codec"
+	"github.com/mizumoto-cn/TRPcG/metadata"
+	"github.com/mizumoto-cn/TRPcG/status"
+	"google.golang.org/protobuf/proto"
+)
+
+// HedgePolicy describes how the calls of a read-only method are hedged:
+// a copy of the call is sent each time Delay passes without a response,
+// and the first response wins. The other attempts are cancelled, their servers
+// stopping them if the methods watch ContextOf.
+type HedgePolicy struct {
+	Delay       time.Duration // before sending the next copy
+	MaxAttempts int           // copies including the first one, 2 if not set
+
+	// Alternates receive the copies in turn, the first attempt going to the
+	// client itself. Without alternates every copy is sent over the client.
+	Alternates []*Client
+
+	// Responses failing with one of NonFatalCodes do not win, the next copy is
+	// sent right away instead. Any other response ends the call.
+	NonFatalCodes []status.Code
+}
+
+// WithHedging sets the hedge policy of serviceMethod, which is the full
+// "Service.Method" name, "Service.*" for all methods of a service, or "*" for all methods.
+// Only hedge methods without side effects, every copy may be processed before it is cancelled.
+func WithHedging(serviceMethod string, policy HedgePolicy) Option {
+	return func(o *options) {
+		if o.hedgePolicies == nil {
+			o.hedgePolicies = make(map[string]HedgePolicy)
+		}
+		o.hedgePolicies[serviceMethod] = policy
+	}
+}
+
+// HedgedCall is the result of a hedged call
+type HedgedCall struct {
+	*rpc.Call        // Reply is the reply given by the caller, which holds the winning response
+	Attempt   int    // index of the winning attempt, 0 being the first one, -1 if none won
+	Attempts  int    // number of copies sent
+	Target    string // of the client which answered
+}
+
+// AsyncHedgedCall asynchronously calls the rpc function, sending copies of the call
+// through AsyncCall according to the hedge policy of serviceMethod. Without a policy
+// a single attempt is made. Once a response wins, the pending attempts are cancelled:
+// their responses, if any, are read into replies of their own and discarded.
+func (c *Client) AsyncHedgedCall(serviceMethod string, args any, reply any) chan *HedgedCall {
+	policy, ok := lookup(c.hedgePolicies, serviceMethod)
+	if !ok {
+		policy = HedgePolicy{MaxAttempts: 1}
+	}
+	return c.hedge(context.Background(), policy, serviceMethod, &codec.Envelope{Args: args}, reply, nil)
+}
+
+// attemptHook is called as an attempt of a hedged call is sent to client, and returns
+// the function called with the error of the attempt once it is answered
+type attemptHook func(client *Client) func(err error)
+
+// hedgeContext waits for the result of a hedged call, or returns ctx.Err() once ctx is done.
+// hook, if not nil, follows every attempt.
+func (c *Client) hedgeContext(ctx context.Context, policy HedgePolicy, serviceMethod string,
+	e *codec.Envelope, reply any, hook attemptHook) error {
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case call := <-c.hedge(ctx, policy, serviceMethod, e, reply, hook):
+		return call.Error
+	}
+}
+
+type hedgeAttempt struct {
+	index  int
+	id     string // CallIDKey of the attempt
+	call   *rpc.Call
+	header *metadata.MD
+}
+
+// hedge sends the copies of the call wrapped in e, the copies pending once a response
+// wins or ctx is done being cancelled. hook, if not nil, follows every attempt.
+func (c *Client) hedge(ctx context.Context, policy HedgePolicy, serviceMethod string,
+	e *codec.Envelope, reply any, hook attemptHook) chan *HedgedCall {
+
+	clients := append([]*Client{c}, policy.Alternates...)
+	attempts := policy.MaxAttempts
+	if attempts <= 0 {
+		attempts = 2
+	}
+	done := make(chan *HedgedCall, 1)
+	results := make(chan hedgeAttempt, attempts)
+
+	pending := make(map[int]string) // CallIDKey of the attempts sent and not answered
+	send := func(i int) {
+		// every attempt gets a reply and a header of its own, losers may still write into them
+		a := hedgeAttempt{index: i, id: newCallID(), header: new(metadata.MD)}
+		attemptReply := newReply(reply)
+		md := metadata.Join(e.Metadata, metadata.Pairs(CallIDKey, a.id))
+		envelope := &codec.Envelope{Args: e.Args, Metadata: md, Header: a.header}
+		client := clients[i%len(clients)]
+		var answered func(error)
+		if hook != nil {
+			answered = hook(client)
+		}
+		ch := client.AsyncCall(serviceMethod, envelope, attemptReply)
+		pending[i] = a.id
+		go func() {
+			a.call = <-ch
+			if answered != nil {
+				answered(a.call.Error)
+			}
+			results <- a
+		}()
+	}
+	cancel := func() {
+		for i, id := range pending {
+			clients[i%len(clients)].cancelCall(id)
+		}
+	}
+
+	go func() {
+		send(0)
+		sent := 1
+		timer := time.NewTimer(policy.Delay)
+		defer timer.Stop()
+		next := func() {
+			if sent < attempts && ctx.Err() == nil {
+				send(sent)
+				sent++
+				timer.Reset(policy.Delay)
+			}
+		}
+		var last hedgeAttempt
+		for {
+			select {
+			case <-timer.C:
+				next()
+				continue
+			case <-ctx.Done():
+				cancel()
+				done <- &HedgedCall{
+					Call:     &rpc.Call{ServiceMethod: serviceMethod, Args: e.Args, Reply: reply, Error: ctx.Err()},
+					Attempt:  -1,
+					Attempts: sent,
+				}
+				return
+			case last = <-results:
+				delete(pending, last.index)
+			}
+			if last.call.Error != nil && policy.nonFatal(last.call.Error) {
+				next()
+				if len(pending) > 0 {
+					continue
+				}
+			}
+			cancel()
+			if last.call.Error == nil {
+				copyReply(reply, last.call.Reply)
+			}
+			if e.Header != nil {
+				*e.Header = *last.header
+			}
+			done <- &HedgedCall{
+				Call: &rpc.Call{
+					ServiceMethod: serviceMethod,
+					Args:          e.Args,
+					Reply:         reply,
+					Error:         last.call.Error,
+				},
+				Attempt:  last.index,
+				Attempts: sent,
+				Target:   clients[last.index%len(clients)].target,
+			}
+			return
+		}
+	}()
+	return done
+}
+
+func (p *HedgePolicy) nonFatal(err error) bool {
+	code := status.CodeOf(err)
+	for _, c := range p.NonFatalCodes {
+		if c == code {
+			return true
+		}
+	}
+	return false
+}
+
+// newReply returns a new value of the type reply points to
+func newReply(reply any) any {
+	if reply == nil {
+		return nil
+	}
+	return reflect.New(reflect.TypeOf(reply).Elem()).Interface()
+}
+
+// copyReply copies the reply of the winning attempt into the reply of the caller
+func copyReply(dst, src any) {
+	if dst == nil {
+		return
+	}
+	if m, ok := dst.(proto.Message); ok {
+		proto.Reset(m)
+		proto.Merge(m, src.(proto.Message))
+		return
+	}
+	reflect.ValueOf(dst).Elem().Set(reflect.ValueOf(src).Elem())
+}
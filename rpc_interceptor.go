@@ -0,0 +1,162 @@
+package TRPcG
+
+import (
+	"context"
+	"net"
+	"net/rpc"
+	"sync"
+
+	"github.com/mizumoto-cn/TRPcG/metadata"
+)
+
+// CallInfo describes an incoming call to the server interceptors.
+type CallInfo struct {
+	ServiceMethod string          // format service.method
+	Peer          net.Addr        // remote address of the connection, may be nil
+	Metadata      metadata.MD     // received with the request
+	Header        metadata.MD     // sent back with the response
+	Context       context.Context // done once the call is canceled, see ContextOf
+}
+
+// Interceptor runs for every incoming call after its args are decoded and
+// before the service method is invoked. A non-nil error rejects the call,
+// the error is sent back to the client in place of the reply.
+type Interceptor func(info *CallInfo, args any) error
+
+// WithInterceptor appends server interceptors, they run in the given order
+func WithInterceptor(interceptors ...Interceptor) Option {
+	return func(o *options) {
+		o.interceptors = append(o.interceptors, interceptors...)
+	}
+}
+
+// metadataCodec is implemented by server codecs carrying metadata in their headers
+type metadataCodec interface {
+	Metadata(seq uint64) metadata.MD
+	SetHeader(seq uint64, md metadata.MD)
+}
+
+// interceptCodec runs the interceptors on the requests read by a rpc.ServerCodec,
+// and keeps the contexts of the calls until they are answered.
+// rpc.Server reads the body of a request right after its header, and answers
+// with the error returned by ReadRequestBody without calling the method.
+type interceptCodec struct {
+	rpc.ServerCodec
+	peer         net.Addr
+	interceptors []Interceptor
+
+	seq  uint64 // of the request whose body is read next
+	info CallInfo
+
+	ctx    context.Context // of the connection
+	cancel context.CancelFunc
+	mutex  sync.Mutex
+	calls  map[uint64]*serverCall // by sequence number
+	ids    map[string]*serverCall // by CallIDKey
+}
+
+func newInterceptCodec(c rpc.ServerCodec, peer net.Addr, interceptors []Interceptor) rpc.ServerCodec {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &interceptCodec{
+		ServerCodec:  c,
+		peer:         peer,
+		interceptors: interceptors,
+		ctx:          ctx,
+		cancel:       cancel,
+		calls:        make(map[uint64]*serverCall),
+		ids:          make(map[string]*serverCall),
+	}
+}
+
+func (c *interceptCodec) ReadRequestHeader(r *rpc.Request) error {
+	if err := c.ServerCodec.ReadRequestHeader(r); err != nil {
+		return err
+	}
+	c.seq = r.Seq
+	c.info = CallInfo{ServiceMethod: r.ServiceMethod, Peer: c.peer, Header: metadata.MD{}}
+	if mc, ok := c.ServerCodec.(metadataCodec); ok {
+		c.info.Metadata = mc.Metadata(r.Seq)
+	}
+	if c.info.Metadata == nil {
+		c.info.Metadata = metadata.MD{}
+	}
+	id := c.info.Metadata.Get(CallIDKey)
+	if r.ServiceMethod == cancelMethod {
+		c.mutex.Lock()
+		if call, ok := c.ids[id]; ok {
+			call.cancel()
+		}
+		c.mutex.Unlock()
+		c.info.Context = c.ctx
+		return nil
+	}
+	ctx, cancel := context.WithCancel(c.ctx)
+	call := &serverCall{id: id, cancel: cancel}
+	c.info.Context = ctx
+	c.mutex.Lock()
+	c.calls[r.Seq] = call
+	if id != "" {
+		c.ids[id] = call
+	}
+	c.mutex.Unlock()
+	return nil
+}
+
+func (c *interceptCodec) ReadRequestBody(param any) error {
+	// param is nil when rpc.Server discards the body of a bad request
+	if err := c.ServerCodec.ReadRequestBody(param); err != nil || param == nil {
+		return err
+	}
+	if c.info.ServiceMethod == cancelMethod {
+		// applied with its header
+		return nil
+	}
+	c.mutex.Lock()
+	if call, ok := c.calls[c.seq]; ok {
+		call.args = param
+		contexts.Store(param, c.info.Context)
+	}
+	c.mutex.Unlock()
+	var err error
+	for _, interceptor := range c.interceptors {
+		if err = interceptor(&c.info, param); err != nil {
+			break
+		}
+	}
+	if mc, ok := c.ServerCodec.(metadataCodec); ok && len(c.info.Header) > 0 {
+		mc.SetHeader(c.seq, c.info.Header)
+	}
+	return err
+}
+
+func (c *interceptCodec) WriteResponse(r *rpc.Response, body any) error {
+	c.mutex.Lock()
+	if call, ok := c.calls[r.Seq]; ok {
+		c.done(r.Seq, call)
+	}
+	c.mutex.Unlock()
+	return c.ServerCodec.WriteResponse(r, body)
+}
+
+// Close cancels the calls still served, the connection being closed
+func (c *interceptCodec) Close() error {
+	c.mutex.Lock()
+	for seq, call := range c.calls {
+		c.done(seq, call)
+	}
+	c.mutex.Unlock()
+	c.cancel()
+	return c.ServerCodec.Close()
+}
+
+// done forgets the call of sequence number seq, c.mutex being held
+func (c *interceptCodec) done(seq uint64, call *serverCall) {
+	delete(c.calls, seq)
+	if call.id != "" && c.ids[call.id] == call {
+		delete(c.ids, call.id)
+	}
+	if call.args != nil {
+		contexts.Delete(call.args)
+	}
+	call.cancel()
+}
@@ -0,0 +1,76 @@
+package TRPcG
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"net/http"
+	"net/netip"
+
+	"github.com/mizumoto-cn/TRPcG/jsonrpc"
+	"github.com/mizumoto-cn/TRPcG/metadata"
+)
+
+// maxJSONRPCBody bounds the body of the JSON-RPC requests over HTTP
+const maxJSONRPCBody = 4 << 20
+
+// ServeJSONRPC is like Serve, but serves the services of the server over JSON-RPC 2.0,
+// see package jsonrpc, with the same interceptors. Its listener is closed by Close,
+// but not registered in the registry.
+func (server *Server) ServeJSONRPC(listener net.Listener) {
+	server.track(listener)
+	defer server.deregister(listener)
+	server.accept(listener, func(conn net.Conn) {
+		server.serveCodec(conn, jsonrpc.NewServerCodec(conn, nil))
+	})
+}
+
+// JSONRPCHandler returns an http.Handler serving the services of the server over
+// JSON-RPC 2.0, with the same interceptors, a request or a batch of them being
+// POSTed in each body. The HTTP headers named by headers are the metadata of the
+// requests, under their lower case names; the others, such as Authorization or
+// Cookie unless named, are not forwarded.
+func (server *Server) JSONRPCHandler(headers ...string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			http.Error(w, "method "+r.Method+" not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		md := metadata.MD{}
+		for _, name := range headers {
+			if v := r.Header.Get(name); v != "" {
+				md.Set(name, v)
+			}
+		}
+		conn := &httpConn{Reader: http.MaxBytesReader(w, r.Body, maxJSONRPCBody)}
+		var peer net.Addr
+		if addr, err := netip.ParseAddrPort(r.RemoteAddr); err == nil {
+			peer = net.TCPAddrFromAddrPort(addr)
+		}
+		// returns once every call is answered
+		server.Server.ServeCodec(newInterceptCodec(jsonrpc.NewServerCodec(conn, md), peer, server.interceptors))
+		if conn.out.Len() == 0 {
+			// notifications only
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(conn.out.Bytes())
+	})
+}
+
+// httpConn is the connection of a JSON-RPC request over HTTP: its body, and the
+// responses buffered until they are all written
+type httpConn struct {
+	io.Reader
+	out bytes.Buffer
+}
+
+func (c *httpConn) Write(p []byte) (int, error) {
+	return c.out.Write(p)
+}
+
+func (c *httpConn) Close() error {
+	return nil
+}
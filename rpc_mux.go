@@ -0,0 +1,101 @@
+package TRPcG
+
+import (
+	"bufio"
+	"net"
+	"net/http"
+	"sync"
+
+	"github.com/mizumoto-cn/TRPcG/codec"
+)
+
+// HealthPath is the path of the health probes served on the HTTP connections, see WithHTTP
+const HealthPath = "/healthz"
+
+// WithHTTP serves HTTP on the listeners of Serve as well as TRPcG: the connections
+// are told apart by their first bytes, the TRPcG clients sending codec.Magic first
+// and HTTP requests starting with the letters of their method. The connections of
+// older clients, starting with their first frame, are served as TRPcG too.
+// HTTP requests are served by h, the health probes of health.Handler on HealthPath
+// aside. h may be nil, to serve the probes only.
+func WithHTTP(h http.Handler) Option {
+	return func(o *options) {
+		o.http, o.httpHandler = true, h
+	}
+}
+
+// sniff returns a function serving the HTTP connections with server.http, and the
+// others with serve, until the HTTP server returned is shut down
+func (server *Server) sniff(addr net.Addr, serve func(net.Conn)) (func(net.Conn), *http.Server) {
+	l := &connListener{addr: addr, conns: make(chan net.Conn), done: make(chan struct{})}
+	httpServer := &http.Server{Handler: server.http}
+	go httpServer.Serve(l)
+	return func(conn net.Conn) {
+		r := bufio.NewReader(conn)
+		first, err := r.Peek(2)
+		if err != nil {
+			conn.Close()
+			return
+		}
+		conn = &sniffedConn{Conn: conn, r: r}
+		if string(first) == codec.Magic[:2] || !isHTTP(first) {
+			serve(conn)
+			return
+		}
+		select {
+		case l.conns <- conn:
+		case <-l.done:
+			conn.Close()
+		}
+	}, httpServer
+}
+
+// isHTTP reports whether a connection starting with the bytes first is HTTP, the
+// first letters of a method. The connections of the clients not sending codec.Magic
+// may start with a letter, the length of their first header as an uvarint, but the
+// next byte is then the low byte of the compression type of the header, far below
+// the letters.
+func isHTTP(first []byte) bool {
+	switch string(first) {
+	case "GE", "HE", "PO", "PU", "PA", "DE", "CO", "OP", "TR", "PR":
+		return true
+	}
+	return false
+}
+
+// sniffedConn is a connection whose first bytes were peeked by r
+type sniffedConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+func (c *sniffedConn) Read(p []byte) (int, error) {
+	return c.r.Read(p)
+}
+
+// connListener is the net.Listener of the HTTP connections sniffed, closed with
+// the HTTP server when Serve returns
+type connListener struct {
+	addr  net.Addr
+	conns chan net.Conn
+	once  sync.Once
+	done  chan struct{}
+}
+
+func (l *connListener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-l.conns:
+		return conn, nil
+	case <-l.done:
+		return nil, net.ErrClosed
+	}
+}
+
+func (l *connListener) Close() error {
+	l.once.Do(func() { close(l.done) })
+	return nil
+}
+
+func (l *connListener) Addr() net.Addr {
+	return l.addr
+}
@@ -0,0 +1,71 @@
+package TRPcG
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sync"
+)
+
+// PipeListener is an in-memory net.Listener, for Serve, whose connections are
+// the ones of its Dial: servers and clients in the same process, such as tests,
+// talk without binding ports
+type PipeListener struct {
+	conns chan net.Conn
+	once  sync.Once
+	done  chan struct{}
+}
+
+// ListenPipe returns a new PipeListener
+func ListenPipe() *PipeListener {
+	return &PipeListener{conns: make(chan net.Conn), done: make(chan struct{})}
+}
+
+// Accept waits for the next connection of Dial
+func (l *PipeListener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-l.conns:
+		return conn, nil
+	case <-l.done:
+		return nil, &net.OpError{Op: "accept", Net: pipeAddr{}.Network(), Addr: pipeAddr{}, Err: net.ErrClosed}
+	}
+}
+
+// Close stops the calls of Accept and Dial, the connections accepted stay open
+func (l *PipeListener) Close() error {
+	l.once.Do(func() { close(l.done) })
+	return nil
+}
+
+// Addr returns the address of the listener, "pipe"
+func (l *PipeListener) Addr() net.Addr {
+	return pipeAddr{}
+}
+
+// Dial connects to the listener, once its connection is accepted
+func (l *PipeListener) Dial() (net.Conn, error) {
+	return l.DialContext(context.Background(), "")
+}
+
+// DialContext is like Dial, bounded by ctx, and ignores addr. It is the Dialer of
+// Dial to the listener, with WithDialer.
+func (l *PipeListener) DialContext(ctx context.Context, _ string) (net.Conn, error) {
+	client, server := net.Pipe()
+	var err error
+	select {
+	case l.conns <- server:
+		return client, nil
+	case <-l.done:
+		err = fmt.Errorf("trpcg: dial pipe: %w", net.ErrClosed)
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+	client.Close()
+	server.Close()
+	return nil, err
+}
+
+type pipeAddr struct{}
+
+func (pipeAddr) Network() string { return "pipe" }
+func (pipeAddr) String() string  { return "pipe" }
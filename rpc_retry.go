@@ -0,0 +1,134 @@
+package TRPcG
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"net/rpc"
+	"time"
+
+	"github.com/mizumoto-cn/TRPcG/codec"
+	"github.com/mizumoto-cn/TRPcG/metadata"
+	"github.com/mizumoto-cn/TRPcG/ratelimit"
+	"github.com/mizumoto-cn/TRPcG/status"
+)
+
+// RetryPolicy describes how the calls of a method are retried
+type RetryPolicy struct {
+	MaxAttempts    int           // including the first attempt
+	InitialBackoff time.Duration // before the first retry
+	MaxBackoff     time.Duration // upper bound of the backoff, 0 for none
+	Multiplier     float64       // growth of the backoff after each retry, 1 if not set
+	RetryableCodes []status.Code // such as status.Unavailable, which covers broken connections
+
+	// Idempotent methods are retried even if the request may already have reached
+	// the server. Other methods are only retried when the request was known not
+	// to be processed: it was never sent, or the server rejected it with a
+	// retry-after hint.
+	Idempotent bool
+}
+
+// WithRetryPolicy sets the retry policy of serviceMethod, which is the full
+// "Service.Method" name, "Service.*" for all methods of a service, or "*" for all methods.
+func WithRetryPolicy(serviceMethod string, policy RetryPolicy) Option {
+	return func(o *options) {
+		if o.retryPolicies == nil {
+			o.retryPolicies = make(map[string]RetryPolicy)
+		}
+		o.retryPolicies[serviceMethod] = policy
+	}
+}
+
+// retryable reports whether err is worth another attempt, and the least time to wait before it.
+// The attempts failed by a broken connection are only worth another one if redial is set.
+func (p *RetryPolicy) retryable(err error, header metadata.MD, redial bool) (bool, time.Duration) {
+	code := status.CodeOf(err)
+	matched := false
+	for _, c := range p.RetryableCodes {
+		if c == code {
+			matched = true
+			break
+		}
+	}
+	if !matched || broken(err) && !redial {
+		return false, 0
+	}
+	// the server told us the call was rejected before it was processed
+	if wait, ok := ratelimit.RetryAfter(header); ok {
+		return true, wait
+	}
+	// rpc.Client fails with ErrShutdown before sending when its connection is gone
+	if p.Idempotent || errors.Is(err, rpc.ErrShutdown) {
+		return true, 0
+	}
+	return false, 0
+}
+
+// backoff returns the jittered time to wait before the retry-th retry
+func (p *RetryPolicy) backoff(retry int) time.Duration {
+	multiplier := p.Multiplier
+	if multiplier <= 0 {
+		multiplier = 1
+	}
+	d := float64(p.InitialBackoff)
+	for i := 1; i < retry; i++ {
+		d *= multiplier
+		if p.MaxBackoff > 0 && d > float64(p.MaxBackoff) {
+			break
+		}
+	}
+	if p.MaxBackoff > 0 && d > float64(p.MaxBackoff) {
+		d = float64(p.MaxBackoff)
+	}
+	if d < 1 {
+		return 0
+	}
+	// full jitter spreads the retries of concurrent callers
+	return time.Duration(rand.Int63n(int64(d)))
+}
+
+// withRetry makes attempts of call as long as policy allows it. The response
+// metadata of every attempt is collected to look for a retry-after hint, a
+// Header option among opts receives the one of the last attempt.
+// A rpc.Client is shut down for good once its connection is broken, so the attempts
+// failed by a broken connection are retried only if redial is set: ClusterClient
+// dials the backends again, Client cannot.
+func withRetry(ctx context.Context, policy RetryPolicy, redial bool, opts []CallOption,
+	call func(ctx context.Context, opts []CallOption) error) error {
+
+	var e codec.Envelope
+	for _, opt := range opts {
+		opt(&e)
+	}
+	var header metadata.MD
+	if e.Header != nil {
+		defer func(dst *metadata.MD) { *dst = header }(e.Header)
+	}
+	n := len(opts)
+
+	for attempt := 1; ; attempt++ {
+		h := new(metadata.MD)
+		err := call(ctx, append(opts[:n:n], Header(h)))
+		// an abandoned attempt may still write into h
+		if ctx.Err() == nil {
+			header = *h
+		}
+		if err == nil || attempt >= policy.MaxAttempts {
+			return err
+		}
+		ok, wait := policy.retryable(err, header, redial)
+		if !ok {
+			return err
+		}
+		if d := policy.backoff(attempt); d > wait {
+			wait = d
+		}
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		case <-timer.C:
+		}
+	}
+}
@@ -1,12 +1,25 @@
 package TRPcG
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
 	"log"
 	"net"
+	"net/http"
 	"net/rpc"
+	"reflect"
+	"sync"
+	"time"
 
 	"github.com/mizumoto-cn/TRPcG/codec"
+	"github.com/mizumoto-cn/TRPcG/health"
+	"github.com/mizumoto-cn/TRPcG/ratelimit"
+	"github.com/mizumoto-cn/TRPcG/reflection"
+	"github.com/mizumoto-cn/TRPcG/registry"
 	"github.com/mizumoto-cn/TRPcG/serializer"
+	"github.com/mizumoto-cn/TRPcG/status"
+	"github.com/mizumoto-cn/TRPcG/validate"
 )
 
 // wrap /net/rpc :: Server
@@ -15,22 +28,82 @@ import (
 type Server struct {
 	*rpc.Server
 	serializer.Serializer
+	interceptors []Interceptor
+	health       *health.Server
+	registry     registry.Registry
+	advertise    string       // address registered, the one of the listener if empty
+	http         http.Handler // of the HTTP connections Serve accepts, nil if none
+
+	mutex     sync.Mutex
+	services  []service
+	listeners map[net.Listener]*listening
+}
+
+// listening is a listener served by a server
+type listening struct {
+	addr      string              // the services are registered at, empty until they are
+	instances []registry.Instance // registered for the listener
+}
+
+// service is a service registered on a server
+type service struct {
+	name    string
+	rcvr    reflect.Type
+	builtin bool // health, reflection
 }
 
+// registryTimeout bounds the registration and deregistration of the services
+const registryTimeout = 5 * time.Second
+
 // Serve accepts incoming connections on the listener l, creating a new
-// ServerCodec to handle each connection. The Server will close the listener
-// when it receives a signal on the Done channel.
+// ServerCodec to handle each connection. Serve returns once the listener is
+// closed, by Close or otherwise. With WithRegistry, the services of the
+// server are registered once it accepts connections, until then. With WithHTTP,
+// the idle HTTP connections are closed then.
 func (server *Server) Serve(listener net.Listener) {
+	server.track(listener)
+	defer server.deregister(listener)
+	serve := func(conn net.Conn) {
+		server.serveCodec(conn, codec.NewServerCodec(conn, server.Serializer))
+	}
+	if server.http != nil {
+		var httpServer *http.Server
+		serve, httpServer = server.sniff(listener.Addr(), serve)
+		// idle connections are closed, the requests in flight served to the end
+		defer func() { go httpServer.Shutdown(context.Background()) }()
+	}
+	// the connections are accepted while the registry is slow
+	go server.register(listener)
+	server.accept(listener, serve)
+}
+
+// track records listener, closed by Close
+func (server *Server) track(listener net.Listener) {
+	server.mutex.Lock()
+	if server.listeners == nil {
+		server.listeners = make(map[net.Listener]*listening)
+	}
+	server.listeners[listener] = &listening{}
+	server.mutex.Unlock()
+}
+
+// accept serves the connections accepted on listener with serve, until it is closed
+func (server *Server) accept(listener net.Listener, serve func(net.Conn)) {
 	for {
 		conn, err := listener.Accept()
 		if err != nil {
 			log.Print("trpcg.Serve: accept:", err.Error())
 			return
 		}
-		go server.Server.ServeCodec(codec.NewServerCodec(conn, server.Serializer))
+		go serve(conn)
 	}
 }
 
+// serveCodec serves the calls read by c from conn, through the interceptors
+func (server *Server) serveCodec(conn net.Conn, c rpc.ServerCodec) {
+	server.Server.ServeCodec(newInterceptCodec(c, conn.RemoteAddr(), server.interceptors))
+}
+
 // NewServer returns a new Server.
 func NewServer(opts ...Option) *Server {
 	options := options{
@@ -39,18 +112,248 @@ func NewServer(opts ...Option) *Server {
 	for _, opt := range opts {
 		opt(&options)
 	}
-	return &Server{
-		&rpc.Server{},
-		options.serializer,
+	server := &Server{
+		Server:       &rpc.Server{},
+		Serializer:   options.serializer,
+		interceptors: options.interceptors,
+		health:       health.NewServer(),
+		registry:     options.registry,
+		advertise:    options.advertise,
+	}
+	err := server.registerBuiltin(health.ServiceName, server.health)
+	if err == nil && options.reflection {
+		err = server.registerBuiltin(reflection.ServiceName, reflection.NewServer(server.Services))
+	}
+	if err == nil {
+		// part of the protocol, not described by reflection
+		err = server.Server.RegisterName(callServiceName, callService{})
+	}
+	if err != nil {
+		// registered first under reserved names, they cannot clash
+		panic("trpcg: register the built-in services: " + err.Error())
+	}
+	if options.http {
+		mux := http.NewServeMux()
+		mux.Handle(HealthPath, health.Handler(server.health))
+		if options.httpHandler != nil {
+			mux.Handle("/", options.httpHandler)
+		}
+		server.http = mux
+	}
+	return server
+}
+
+// registerBuiltin registers a service provided by TRPcG, which is neither registered
+// in the registry nor reported by the health service
+func (server *Server) registerBuiltin(name string, rcvr any) error {
+	if err := server.Server.RegisterName(name, rcvr); err != nil {
+		return err
+	}
+	server.services = append(server.services, service{name: name, rcvr: reflect.TypeOf(rcvr), builtin: true})
+	return nil
+}
+
+// WithReflection registers the reflection service, which describes the services of the
+// server and the protobuf types of their methods
+func WithReflection() Option {
+	return func(o *options) {
+		o.reflection = true
+	}
+}
+
+// Services describes the services of the server, built-in ones included
+func (server *Server) Services() []*reflection.ServiceInfo {
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+	infos := make([]*reflection.ServiceInfo, 0, len(server.services))
+	for _, s := range server.services {
+		infos = append(infos, reflection.Describe(s.name, s.rcvr))
+	}
+	return infos
+}
+
+// Health returns the health service of the server, which reports the registered
+// services SERVING until Close
+func (server *Server) Health() *health.Server {
+	return server.health
+}
+
+// WithRegistry registers the services of the server in r while it serves, at addr,
+// or at the address of the listener if addr is empty.
+func WithRegistry(r registry.Registry, addr string) Option {
+	return func(o *options) {
+		o.registry, o.advertise = r, addr
+	}
+}
+
+// register registers the services of the server at the address of listener,
+// and the ones registered later on until listener is closed
+func (server *Server) register(listener net.Listener) {
+	if server.registry == nil {
+		return
+	}
+	addr := server.advertise
+	if addr == "" {
+		addr = listener.Addr().String()
+	}
+	server.mutex.Lock()
+	l, ok := server.listeners[listener]
+	if !ok {
+		// closed meanwhile
+		server.mutex.Unlock()
+		return
+	}
+	// services added from now on are registered by addService
+	l.addr = addr
+	var services []string
+	for _, s := range server.services {
+		if !s.builtin {
+			services = append(services, s.name)
+		}
+	}
+	server.mutex.Unlock()
+	server.registerServices(listener, addr, services)
+}
+
+// registerServices registers services at addr for listener
+func (server *Server) registerServices(listener net.Listener, addr string, services []string) {
+	ctx, cancel := context.WithTimeout(context.Background(), registryTimeout)
+	defer cancel()
+	var instances []registry.Instance
+	for _, name := range services {
+		inst := registry.Instance{Service: name, Addr: addr}
+		if err := server.registry.Register(ctx, inst); err != nil {
+			log.Print("trpcg.Serve: register ", name, ": ", err.Error())
+			continue
+		}
+		instances = append(instances, inst)
 	}
+	server.mutex.Lock()
+	if l, ok := server.listeners[listener]; ok {
+		l.instances = append(l.instances, instances...)
+	} else {
+		// closed meanwhile
+		defer server.deregisterInstances(instances)
+	}
+	server.mutex.Unlock()
 }
 
-// Register registers a rpc service with a given receiver.
+// deregister forgets listener and deregisters the instances registered for it
+func (server *Server) deregister(listener net.Listener) {
+	server.mutex.Lock()
+	l, ok := server.listeners[listener]
+	delete(server.listeners, listener)
+	server.mutex.Unlock()
+	if ok {
+		server.deregisterInstances(l.instances)
+	}
+}
+
+func (server *Server) deregisterInstances(instances []registry.Instance) {
+	if len(instances) == 0 {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), registryTimeout)
+	defer cancel()
+	for _, inst := range instances {
+		if err := server.registry.Deregister(ctx, inst); err != nil {
+			log.Print("trpcg.Serve: deregister ", inst.Service, ": ", err.Error())
+		}
+	}
+}
+
+// Close reports the services of the server NOT_SERVING, deregisters them and closes the
+// listeners it serves, so that the calls of Serve return. Connections already accepted
+// are not closed.
+func (server *Server) Close() error {
+	server.health.Shutdown()
+	server.mutex.Lock()
+	listeners := make([]net.Listener, 0, len(server.listeners))
+	for l := range server.listeners {
+		listeners = append(listeners, l)
+	}
+	server.mutex.Unlock()
+	var err error
+	for _, l := range listeners {
+		// deregistered before closing, clients stop picking the server first
+		server.deregister(l)
+		if e := l.Close(); e != nil && err == nil {
+			err = e
+		}
+	}
+	return err
+}
+
+// WithRateLimiter rejects the calls exceeding the rules of l with a ResourceExhausted
+// error, and a ratelimit.RetryAfterKey hint in the response metadata.
+func WithRateLimiter(l *ratelimit.Limiter) Option {
+	return WithInterceptor(func(info *CallInfo, _ any) error {
+		ok, wait := l.Allow(info.ServiceMethod, info.Peer, info.Metadata)
+		if ok {
+			return nil
+		}
+		info.Header.Set(ratelimit.RetryAfterKey, wait.String())
+		return status.Errorf(status.ResourceExhausted, "rate limit of %s exceeded", info.ServiceMethod)
+	})
+}
+
+// WithValidation rejects the calls whose args fail their Validate method, such as
+// the ones protoc-gen-trpcg generates from (trpcg.validate.rules), with an
+// InvalidArgument error and the violations in the validate.ViolationsKey response metadata.
+func WithValidation() Option {
+	return WithInterceptor(func(info *CallInfo, args any) error {
+		v, ok := args.(validate.Validator)
+		if !ok {
+			return nil
+		}
+		err := v.Validate()
+		if err == nil {
+			return nil
+		}
+		var e *validate.Error
+		if errors.As(err, &e) {
+			data, _ := json.Marshal(e.Violations)
+			info.Header.Set(validate.ViolationsKey, string(data))
+		}
+		return status.Error(status.InvalidArgument, err.Error())
+	})
+}
+
+// Register registers a rpc service with a given receiver. The names of the
+// built-in services are reserved: "Health", "Reflection" with WithReflection,
+// and "trpcg.Call", registering a service under one of them fails.
 func (server *Server) Register(rcvr interface{}) error {
-	return server.Server.Register(rcvr)
+	if err := server.Server.Register(rcvr); err != nil {
+		return err
+	}
+	server.addService(reflect.Indirect(reflect.ValueOf(rcvr)).Type().Name(), rcvr)
+	return nil
 }
 
-// RegisterName registers a rpc service with a given receiver and a given name.
+// RegisterName registers a rpc service with a given receiver and a given name,
+// which must not be the one of a built-in service, see Register.
 func (server *Server) RegisterName(name string, rcvr interface{}) error {
-	return server.Server.RegisterName(name, rcvr)
+	if err := server.Server.RegisterName(name, rcvr); err != nil {
+		return err
+	}
+	server.addService(name, rcvr)
+	return nil
+}
+
+// addService records a registered service, and registers it in the registry
+// at the addresses the server already serves at
+func (server *Server) addService(name string, rcvr any) {
+	server.mutex.Lock()
+	server.services = append(server.services, service{name: name, rcvr: reflect.TypeOf(rcvr)})
+	serving := make(map[net.Listener]string)
+	for listener, l := range server.listeners {
+		if l.addr != "" {
+			serving[listener] = l.addr
+		}
+	}
+	server.mutex.Unlock()
+	server.health.SetServingStatus(name, health.ServingStatus_SERVING)
+	for listener, addr := range serving {
+		server.registerServices(listener, addr, []string{name})
+	}
 }
@@ -0,0 +1,138 @@
+package TRPcG
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sync"
+)
+
+// UnixOption configures ListenUnix and DialUnix
+type UnixOption func(o *unixOptions)
+
+type unixOptions struct {
+	abstract bool
+	mode     os.FileMode
+}
+
+// WithAbstractNamespace names the socket in the abstract namespace of Linux instead
+// of the file system: it has no file, and disappears with its listener
+func WithAbstractNamespace() UnixOption {
+	return func(o *unixOptions) {
+		o.abstract = true
+	}
+}
+
+// WithFileMode sets the permissions of the socket file of ListenUnix, such as 0660
+// to let the processes of its group only connect
+func WithFileMode(mode os.FileMode) UnixOption {
+	return func(o *unixOptions) {
+		o.mode = mode
+	}
+}
+
+// unixAddr returns the address of the socket name
+func unixAddr(name string, opts []UnixOption) (*net.UnixAddr, unixOptions, error) {
+	var o unixOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if o.abstract {
+		if runtime.GOOS != "linux" && runtime.GOOS != "android" {
+			return nil, o, errors.New("trpcg: abstract unix sockets need Linux")
+		}
+		// net names abstract sockets with a leading @
+		name = "@" + name
+	}
+	return &net.UnixAddr{Name: name, Net: "unix"}, o, nil
+}
+
+// ListenUnix listens on the unix socket path, for Serve. A stale socket file left
+// at path by a listener which did not close, such as a killed process, is replaced.
+func ListenUnix(path string, opts ...UnixOption) (net.Listener, error) {
+	addr, o, err := unixAddr(path, opts)
+	if err != nil {
+		return nil, err
+	}
+	if !o.abstract {
+		removeStale(path)
+	}
+	if o.mode != 0 && !o.abstract {
+		return listenUnixMode(path, o.mode)
+	}
+	return net.ListenUnix("unix", addr)
+}
+
+// listenUnixMode listens on the unix socket path with the permissions mode. The
+// socket is created in a directory of its own, which only its owner may enter, and
+// linked at path once it has mode, so that no other process connects before.
+func listenUnixMode(path string, mode os.FileMode) (net.Listener, error) {
+	dir, err := os.MkdirTemp(filepath.Dir(path), ".trpcg")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+	private := filepath.Join(dir, "s")
+	l, err := net.ListenUnix("unix", &net.UnixAddr{Name: private, Net: "unix"})
+	if err != nil {
+		return nil, err
+	}
+	// unlinked with the directory, path is removed by Close
+	l.SetUnlinkOnClose(false)
+	if err = os.Chmod(private, mode); err == nil {
+		// fails like a listen if path is in use
+		err = os.Link(private, path)
+	}
+	if err != nil {
+		l.Close()
+		return nil, err
+	}
+	return &unixListener{UnixListener: l, path: path}, nil
+}
+
+// unixListener is a listener whose socket file at path is removed by Close
+type unixListener struct {
+	*net.UnixListener
+	path string
+	once sync.Once
+}
+
+func (l *unixListener) Close() error {
+	l.once.Do(func() { os.Remove(l.path) })
+	return l.UnixListener.Close()
+}
+
+// removeStale removes the socket file at path if nothing listens on it
+func removeStale(path string) {
+	info, err := os.Lstat(path)
+	if err != nil || info.Mode()&os.ModeSocket == 0 {
+		return
+	}
+	conn, err := net.Dial("unix", path)
+	if err == nil {
+		conn.Close()
+		return
+	}
+	os.Remove(path)
+}
+
+// DialUnix connects to the unix socket path, for NewClient
+func DialUnix(ctx context.Context, path string, opts ...UnixOption) (net.Conn, error) {
+	addr, _, err := unixAddr(path, opts)
+	if err != nil {
+		return nil, err
+	}
+	var d net.Dialer
+	return d.DialContext(ctx, "unix", addr.Name)
+}
+
+// UnixDialer returns a Dialer of the unix sockets at the addresses of Dial,
+// such as "/run/arith-a.sock,/run/arith-b.sock"
+func UnixDialer(opts ...UnixOption) Dialer {
+	return func(ctx context.Context, addr string) (net.Conn, error) {
+		return DialUnix(ctx, addr, opts...)
+	}
+}
@@ -0,0 +1,18 @@
+package TRPcG
+
+import (
+	"net/http"
+
+	"github.com/mizumoto-cn/TRPcG/codec"
+	"github.com/mizumoto-cn/TRPcG/websocket"
+)
+
+// WebSocketHandler returns an http.Handler upgrading its requests to WebSocket
+// connections, which serve the services of the server as the ones of Serve do.
+// Clients connect with websocket.Dial, or websocket.Dialer for Dial. The handshakes
+// from the pages of other hosts are rejected, unless accepted by websocket.WithCheckOrigin.
+func (server *Server) WebSocketHandler(opts ...websocket.Option) http.Handler {
+	return websocket.Handler(func(conn *websocket.Conn) {
+		server.serveCodec(conn, codec.NewServerCodec(conn, server.Serializer))
+	}, opts...)
+}
@@ -0,0 +1,26 @@
+package serializer
+
+import "encoding/json"
+
+// implements Serializer interface with encoding/json,
+// for services whose messages are plain Go structs
+type JsonSerializer struct {
+}
+
+var Json = JsonSerializer{}
+
+// Marshal
+func (_ JsonSerializer) Marshal(message any) ([]byte, error) {
+	if message == nil {
+		return []byte{}, nil
+	}
+	return json.Marshal(message)
+}
+
+// Unmarshal
+func (_ JsonSerializer) Unmarshal(data []byte, message any) error {
+	if message == nil || len(data) == 0 {
+		return nil
+	}
+	return json.Unmarshal(data, message)
+}
@@ -0,0 +1,178 @@
+package status
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/rpc"
+	"strings"
+	"syscall"
+)
+
+// Code is the status code of a rpc call, carried in the error string of the response header
+type Code uint32
+
+const (
+	OK Code = iota
+	Canceled
+	Unknown
+	InvalidArgument
+	DeadlineExceeded
+	NotFound
+	AlreadyExists
+	PermissionDenied
+	ResourceExhausted
+	FailedPrecondition
+	Aborted
+	OutOfRange
+	Unimplemented
+	Internal
+	Unavailable
+	DataLoss
+	Unauthenticated
+)
+
+var codeNames = [...]string{
+	OK:                 "OK",
+	Canceled:           "Canceled",
+	Unknown:            "Unknown",
+	InvalidArgument:    "InvalidArgument",
+	DeadlineExceeded:   "DeadlineExceeded",
+	NotFound:           "NotFound",
+	AlreadyExists:      "AlreadyExists",
+	PermissionDenied:   "PermissionDenied",
+	ResourceExhausted:  "ResourceExhausted",
+	FailedPrecondition: "FailedPrecondition",
+	Aborted:            "Aborted",
+	OutOfRange:         "OutOfRange",
+	Unimplemented:      "Unimplemented",
+	Internal:           "Internal",
+	Unavailable:        "Unavailable",
+	DataLoss:           "DataLoss",
+	Unauthenticated:    "Unauthenticated",
+}
+
+func (c Code) String() string {
+	if int(c) < len(codeNames) {
+		return codeNames[c]
+	}
+	return fmt.Sprintf("Code(%d)", uint32(c))
+}
+
+// ParseCode returns the Code named s
+func ParseCode(s string) (Code, bool) {
+	for c, name := range codeNames {
+		if name == s {
+			return Code(c), true
+		}
+	}
+	return Unknown, false
+}
+
+// | "code = " | Code name | " desc = " | message |
+const (
+	codePrefix = "code = "
+	descPrefix = " desc = "
+)
+
+// Status is an error with a Code, it survives the trip through the
+// response header as it is encoded into the error string.
+type Status struct {
+	code    Code
+	message string
+}
+
+// New returns a Status with the given code and message
+func New(c Code, msg string) *Status {
+	return &Status{code: c, message: msg}
+}
+
+// Error returns an error with the given code and message, or nil if c is OK
+func Error(c Code, msg string) error {
+	if c == OK {
+		return nil
+	}
+	return New(c, msg)
+}
+
+// Errorf is like Error but formats the message
+func Errorf(c Code, format string, a ...any) error {
+	return Error(c, fmt.Sprintf(format, a...))
+}
+
+// Code returns the status code
+func (s *Status) Code() Code {
+	if s == nil {
+		return OK
+	}
+	return s.code
+}
+
+// Message returns the status message
+func (s *Status) Message() string {
+	if s == nil {
+		return ""
+	}
+	return s.message
+}
+
+// Error implements the error interface
+func (s *Status) Error() string {
+	return codePrefix + s.code.String() + descPrefix + s.message
+}
+
+// FromError returns the Status carried by err.
+// err may be a *Status, or the rpc.ServerError a client receives for it.
+// ok is false if err carries no status, the returned Status is then Unknown.
+func FromError(err error) (s *Status, ok bool) {
+	if err == nil {
+		return nil, true
+	}
+	if errors.As(err, &s) {
+		return s, true
+	}
+	if s, ok := parse(err.Error()); ok {
+		return s, true
+	}
+	return New(Unknown, err.Error()), false
+}
+
+// Convert is like FromError but also maps context errors to their codes,
+// and the errors of a broken or closed connection to Unavailable.
+func Convert(err error) *Status {
+	switch {
+	case errors.Is(err, context.Canceled):
+		return New(Canceled, err.Error())
+	case errors.Is(err, context.DeadlineExceeded):
+		return New(DeadlineExceeded, err.Error())
+	case errors.Is(err, rpc.ErrShutdown), errors.Is(err, io.EOF), errors.Is(err, io.ErrUnexpectedEOF),
+		errors.Is(err, net.ErrClosed), errors.Is(err, syscall.ECONNRESET),
+		errors.Is(err, syscall.ECONNREFUSED), errors.Is(err, syscall.EPIPE):
+		return New(Unavailable, err.Error())
+	}
+	s, _ := FromError(err)
+	return s
+}
+
+// CodeOf returns the Code of err, OK if err is nil
+func CodeOf(err error) Code {
+	return Convert(err).Code()
+}
+
+func parse(str string) (*Status, bool) {
+	if !strings.HasPrefix(str, codePrefix) {
+		return nil, false
+	}
+	str = str[len(codePrefix):]
+	i := strings.Index(str, descPrefix)
+	if i < 0 {
+		return nil, false
+	}
+	c, ok := ParseCode(str[:i])
+	if !ok {
+		return nil, false
+	}
+	return New(c, str[i+len(descPrefix):]), true
+}
@@ -0,0 +1,143 @@
+// Code generated by protoc-gen-trpcg. DO NOT EDIT.
+// source: shop.proto
+
+package shop
+
+import (
+	context "context"
+	TRPcG "github.com/mizumoto-cn/TRPcG"
+	status "github.com/mizumoto-cn/TRPcG/status"
+	validate "github.com/mizumoto-cn/TRPcG/validate"
+	rpc "net/rpc"
+	regexp "regexp"
+	utf8 "unicode/utf8"
+)
+
+// OrdersServer is implemented by the servers of Orders
+//
+// Orders takes the orders of the shop
+type OrdersServer interface {
+	// Place places an order
+	// It fails with FailedPrecondition when an item is out of stock.
+	Place(args *Order, reply *Receipt) error
+}
+
+// RegisterOrdersServer registers impl as the Orders service of s
+func RegisterOrdersServer(s *TRPcG.Server, impl OrdersServer) error {
+	return s.RegisterName("Orders", impl)
+}
+
+// UnimplementedOrdersServer fails every method with Unimplemented. Embedded in
+// the implementations of OrdersServer, it keeps them building when methods are added.
+type UnimplementedOrdersServer struct{}
+
+func (UnimplementedOrdersServer) Place(args *Order, reply *Receipt) error {
+	return status.Error(status.Unimplemented, "method Place not implemented")
+}
+
+// OrdersClient is the client of Orders
+type OrdersClient interface {
+	// Place places an order
+	// It fails with FailedPrecondition when an item is out of stock.
+	Place(ctx context.Context, args *Order, opts ...TRPcG.CallOption) (*Receipt, error)
+	// PlaceAsync calls Place asynchronously, sending the call on the channel returned once done
+	PlaceAsync(args *Order, reply *Receipt) chan *rpc.Call
+}
+
+type ordersClient struct {
+	c TRPcG.Invoker
+}
+
+// NewOrdersClient returns a client of Orders calling through c,
+// a *TRPcG.Client or a *TRPcG.ClusterClient
+func NewOrdersClient(c TRPcG.Invoker) OrdersClient {
+	return &ordersClient{c}
+}
+
+func (c *ordersClient) Place(ctx context.Context, args *Order, opts ...TRPcG.CallOption) (*Receipt, error) {
+	reply := new(Receipt)
+	if err := c.c.CallContext(ctx, "Orders.Place", args, reply, opts...); err != nil {
+		return nil, err
+	}
+	return reply, nil
+}
+
+func (c *ordersClient) PlaceAsync(args *Order, reply *Receipt) chan *rpc.Call {
+	return c.c.AsyncCall("Orders.Place", args, reply)
+}
+
+var _Order_Tags_pattern = regexp.MustCompile("^[a-z]+$")
+
+// Validate checks x against the rules of the fields of Order, and of the
+// messages of its fields, returning a *validate.Error of the rules broken
+func (x *Order) Validate() error {
+	if x == nil {
+		return nil
+	}
+	var v validate.Violations
+	if len(x.GetItems()) < 1 {
+		v.Add("items", "must have at least 1 items")
+	}
+	if len(x.GetItems()) > 10 {
+		v.Add("items", "must have at most 10 items")
+	}
+	for i, e := range x.GetItems() {
+		v.Nested(validate.Index("items", i), e)
+	}
+	if x.GetCustomerId() == 0 {
+		v.Add("customer_id", "is required")
+	}
+	if len(x.GetNotes()) > 5 {
+		v.Add("notes", "must have at most 5 items")
+	}
+	if _, ok := Priority_name[int32(x.GetPriority())]; !ok {
+		v.Add("priority", "must be a defined Priority")
+	}
+	if utf8.RuneCountInString(x.GetCoupon()) < 4 {
+		v.Add("coupon", "must be at least 4 characters long")
+	}
+	if utf8.RuneCountInString(x.GetCoupon()) > 12 {
+		v.Add("coupon", "must be at most 12 characters long")
+	}
+	for i, e := range x.GetTags() {
+		if !_Order_Tags_pattern.MatchString(e) {
+			v.Add(validate.Index("tags", i), "must match the pattern ^[a-z]+$")
+		}
+	}
+	return v.Err()
+}
+
+var _Order_Item_Sku_pattern = regexp.MustCompile("^[A-Z]{3}-[0-9]+$")
+
+// Validate checks x against the rules of the fields of Order_Item, and of the
+// messages of its fields, returning a *validate.Error of the rules broken
+func (x *Order_Item) Validate() error {
+	if x == nil {
+		return nil
+	}
+	var v validate.Violations
+	if x.GetSku() == "" {
+		v.Add("sku", "is required")
+	}
+	if !_Order_Item_Sku_pattern.MatchString(x.GetSku()) {
+		v.Add("sku", "must match the pattern ^[A-Z]{3}-[0-9]+$")
+	}
+	if x.GetQuantity() < 1 {
+		v.Add("quantity", "must be at least 1")
+	}
+	if x.GetQuantity() > 99 {
+		v.Add("quantity", "must be at most 99")
+	}
+	return v.Err()
+}
+
+// Validate checks x against the rules of the fields of Receipt, and of the
+// messages of its fields, returning a *validate.Error of the rules broken
+func (x *Receipt) Validate() error {
+	if x == nil {
+		return nil
+	}
+	var v validate.Violations
+	v.Nested("order", x.GetOrder())
+	return v.Err()
+}
@@ -0,0 +1,48 @@
+// trpcg-registry serves a registry of TRPcG services with TTL leases.
+//
+//	trpcg-registry -addr :8500 -ttl 10s
+//
+// Servers register with TRPcG.WithRegistry(registry.NewRemote(client, ttl), addr),
+// clients resolve the services with registry.NewResolver(registry.NewRemote(client, 0)),
+// client being a TRPcG.Client of the registry using serializer.Json.
+package main
+
+import (
+	"flag"
+	"log"
+	"net"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/mizumoto-cn/TRPcG"
+	"github.com/mizumoto-cn/TRPcG/registry"
+	"github.com/mizumoto-cn/TRPcG/serializer"
+)
+
+func main() {
+	addr := flag.String("addr", ":8500", "address to listen on")
+	ttl := flag.Duration("ttl", 0, "default TTL of the leases, 10s if not set")
+	flag.Parse()
+
+	listener, err := net.Listen("tcp", *addr)
+	if err != nil {
+		log.Fatal("listen error:", err)
+	}
+	service := registry.NewService(*ttl)
+	defer service.Close()
+
+	server := TRPcG.NewServer(TRPcG.WithSerializer(serializer.Json))
+	if err = server.RegisterName(registry.ServiceName, service); err != nil {
+		log.Fatal("register error:", err)
+	}
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-signals
+		server.Close()
+	}()
+	log.Print("trpcg-registry: serving on ", listener.Addr())
+	server.Serve(listener)
+}
@@ -0,0 +1,104 @@
+// Package validate checks messages against the rules of their proto files.
+// protoc-gen-trpcg generates a Validate method for the messages whose fields
+// have (trpcg.validate.rules) options, see validate.proto, and servers created
+// with TRPcG.WithValidation reject the requests failing it before calling their
+// methods.
+package validate
+
+import (
+	"encoding/json"
+	"errors"
+	"strconv"
+	"strings"
+
+	"github.com/mizumoto-cn/TRPcG/metadata"
+)
+
+// ViolationsKey is the response metadata key of the violations of an invalid
+// request, as a JSON array of FieldViolation
+const ViolationsKey = "field-violations"
+
+// Validator is implemented by the messages with a Validate method
+type Validator interface {
+	Validate() error
+}
+
+// FieldViolation is a rule broken by a field
+type FieldViolation struct {
+	Field       string `json:"field"`       // path of the field, such as "items[0].sku"
+	Description string `json:"description"` // of the rule, such as "is required"
+}
+
+// Error is the error of the generated Validate methods, listing the rules
+// broken by a message
+type Error struct {
+	Violations []FieldViolation
+}
+
+func (e *Error) Error() string {
+	s := make([]string, len(e.Violations))
+	for i, v := range e.Violations {
+		s[i] = v.Field + " " + v.Description
+	}
+	return strings.Join(s, "; ")
+}
+
+// Violations collects the rules broken by a message, used by the generated
+// Validate methods
+type Violations []FieldViolation
+
+// Add adds the rule described broken by field
+func (v *Violations) Add(field, description string) {
+	*v = append(*v, FieldViolation{Field: field, Description: description})
+}
+
+// Nested adds the rules broken by the message m of field, if it is a Validator
+func (v *Violations) Nested(field string, m any) {
+	validator, ok := m.(Validator)
+	if !ok {
+		return
+	}
+	err := validator.Validate()
+	var e *Error
+	switch {
+	case err == nil:
+	case errors.As(err, &e):
+		for _, nested := range e.Violations {
+			v.Add(field+"."+nested.Field, nested.Description)
+		}
+	default:
+		v.Add(field, err.Error())
+	}
+}
+
+// Err returns an *Error of the violations, nil if none
+func (v Violations) Err() error {
+	if len(v) == 0 {
+		return nil
+	}
+	return &Error{Violations: v}
+}
+
+// Index returns the path of the element i of the repeated field
+func Index(field string, i int) string {
+	return field + "[" + strconv.Itoa(i) + "]"
+}
+
+// Key returns the path of the value of key in the map field
+func Key[K comparable](field string, key K) string {
+	if s, ok := any(key).(string); ok {
+		return field + "[" + strconv.Quote(s) + "]"
+	}
+	data, _ := json.Marshal(key)
+	return field + "[" + string(data) + "]"
+}
+
+// FromHeader returns the violations sent in the response metadata md of a call
+// failed by TRPcG.WithValidation, nil if none
+func FromHeader(md metadata.MD) []FieldViolation {
+	var violations []FieldViolation
+	if err := json.Unmarshal([]byte(md.Get(ViolationsKey)), &violations); err != nil {
+		return nil
+	}
+	return violations
+}
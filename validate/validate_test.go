@@ -0,0 +1,92 @@
+package validate_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/mizumoto-cn/TRPcG/metadata"
+	"github.com/mizumoto-cn/TRPcG/testing/shop"
+	"github.com/mizumoto-cn/TRPcG/validate"
+	"github.com/stretchr/testify/assert"
+)
+
+type failing struct{}
+
+func (failing) Validate() error { return errors.New("is broken") }
+
+// TestViolations tests Violations::Add, Violations::Nested and Violations::Err
+func TestViolations(t *testing.T) {
+	var v validate.Violations
+	assert.Equal(t, nil, v.Err())
+
+	v.Add("name", "is required")
+	v.Nested("item", &shop.Order_Item{Sku: "ABC-1"})
+	v.Nested("other", failing{})
+	v.Nested("plain", "not a validator")
+	v.Nested("valid", &shop.Order_Item{Sku: "ABC-1", Quantity: 1})
+	err := v.Err()
+	var e *validate.Error
+	assert.Equal(t, true, errors.As(err, &e))
+	assert.Equal(t, []validate.FieldViolation{
+		{Field: "name", Description: "is required"},
+		{Field: "item.quantity", Description: "must be at least 1"},
+		{Field: "other", Description: "is broken"},
+	}, e.Violations)
+	assert.Equal(t, "name is required; item.quantity must be at least 1; other is broken", err.Error())
+}
+
+// TestPaths tests Index and Key
+func TestPaths(t *testing.T) {
+	assert.Equal(t, "items[3]", validate.Index("items", 3))
+	assert.Equal(t, `notes["a b"]`, validate.Key("notes", "a b"))
+	assert.Equal(t, "counts[42]", validate.Key("counts", int64(42)))
+	assert.Equal(t, "flags[true]", validate.Key("flags", true))
+}
+
+// TestFromHeader tests FromHeader
+func TestFromHeader(t *testing.T) {
+	assert.Equal(t, []validate.FieldViolation(nil), validate.FromHeader(metadata.MD{}))
+	md := metadata.Pairs(validate.ViolationsKey, `[{"field":"sku","description":"is required"}]`)
+	assert.Equal(t, []validate.FieldViolation{{Field: "sku", Description: "is required"}}, validate.FromHeader(md))
+	md = metadata.Pairs(validate.ViolationsKey, "not json")
+	assert.Equal(t, []validate.FieldViolation(nil), validate.FromHeader(md))
+}
+
+// TestGenerated tests the Validate methods protoc-gen-trpcg generates
+func TestGenerated(t *testing.T) {
+	valid := func() *shop.Order {
+		return &shop.Order{
+			Items:      []*shop.Order_Item{{Sku: "ABC-1", Quantity: 1}},
+			CustomerId: 1,
+			Coupon:     "SPRING",
+			Tags:       []string{"gift"},
+		}
+	}
+	assert.Equal(t, nil, valid().Validate())
+	assert.Equal(t, nil, (*shop.Order)(nil).Validate())
+
+	for want, change := range map[string]func(o *shop.Order){
+		"items must have at least 1 items":                                                func(o *shop.Order) { o.Items = nil },
+		"items must have at most 10 items":                                                func(o *shop.Order) { o.Items = make([]*shop.Order_Item, 11) },
+		"items[0].sku is required; items[0].sku must match the pattern ^[A-Z]{3}-[0-9]+$": func(o *shop.Order) { o.Items[0].Sku = "" },
+		"items[0].sku must match the pattern ^[A-Z]{3}-[0-9]+$":                           func(o *shop.Order) { o.Items[0].Sku = "AB-1" },
+		"customer_id is required":                                                         func(o *shop.Order) { o.CustomerId = 0 },
+		"notes must have at most 5 items": func(o *shop.Order) {
+			o.Notes = map[string]string{"a": "", "b": "", "c": "", "d": "", "e": "", "f": ""}
+		},
+		"priority must be a defined Priority":       func(o *shop.Order) { o.Priority = 5 },
+		"coupon must be at least 4 characters long": func(o *shop.Order) { o.Coupon = "ÉTÉ" },
+		"coupon must be at most 12 characters long": func(o *shop.Order) { o.Coupon = "WINTERSPRING2" },
+		`tags[1] must match the pattern ^[a-z]+$`:   func(o *shop.Order) { o.Tags = append(o.Tags, "Gift") },
+	} {
+		o := valid()
+		change(o)
+		err := o.Validate()
+		if assert.NotEqual(t, nil, err, want) {
+			assert.Equal(t, want, err.Error())
+		}
+	}
+
+	receipt := &shop.Receipt{Order: &shop.Order{CustomerId: 1, Coupon: "SPRING"}}
+	assert.Equal(t, "order.items must have at least 1 items", receipt.Validate().Error())
+}
@@ -0,0 +1,488 @@
+// Package websocket carries TRPcG over WebSocket (RFC 6455) connections, for the
+// browser-side and proxy-constrained clients which cannot open TCP connections.
+// Conn adapts a connection to the byte stream of the codecs: each Write is sent as
+// a binary message, so that the requests and responses, which the codecs write at
+// once, are messages of their own, and Read reads the data of the messages received
+// one after the other.
+// See TRPcG.Server::WebSocketHandler for the server side.
+package websocket
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Protocol is the subprotocol of TRPcG, sent back by the servers to the clients offering it
+const Protocol = "trpcg"
+
+// acceptGUID is appended to the key of the client to compute the accept key
+const acceptGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// opcodes of the frames
+const (
+	opContinuation = 0x0
+	opText         = 0x1
+	opBinary       = 0x2
+	opClose        = 0x8
+	opPing         = 0x9
+	opPong         = 0xa
+)
+
+// status codes of the close frames
+const (
+	closeNormal      = 1000
+	closeProtocol    = 1002
+	closeUnsupported = 1003
+)
+
+// ErrProtocol is returned by Read when the peer breaks the protocol, the connection is then closed
+var ErrProtocol = errors.New("websocket: protocol error")
+
+// Conn is a WebSocket connection, the net.Conn of the stream of its binary messages
+type Conn struct {
+	conn   net.Conn
+	br     *bufio.Reader
+	client bool // masks the frames it sends, and expects them unmasked
+
+	rmutex     sync.Mutex // guards the reading state
+	remaining  int64      // bytes left in the data frame being read
+	mask       [4]byte
+	masked     bool
+	pos        int  // in the mask
+	fragmented bool // reading a message of several frames
+
+	wmutex sync.Mutex // guards the writes
+	closed bool       // a close frame was sent
+}
+
+// Read reads the data of the binary messages received. It returns io.EOF once the
+// peer closed the connection.
+func (c *Conn) Read(p []byte) (int, error) {
+	c.rmutex.Lock()
+	defer c.rmutex.Unlock()
+	for c.remaining == 0 {
+		if err := c.nextFrame(); err != nil {
+			return 0, err
+		}
+	}
+	if int64(len(p)) > c.remaining {
+		p = p[:c.remaining]
+	}
+	n, err := c.br.Read(p)
+	c.unmask(p[:n])
+	c.remaining -= int64(n)
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	return n, err
+}
+
+// nextFrame reads the header of the next data frame, answering the control frames before it
+func (c *Conn) nextFrame() error {
+	var head [2]byte
+	if _, err := io.ReadFull(c.br, head[:]); err != nil {
+		return err
+	}
+	fin, opcode := head[0]&0x80 != 0, head[0]&0x0f
+	masked, length := head[1]&0x80 != 0, int64(head[1]&0x7f)
+	switch length {
+	case 126:
+		var ext [2]byte
+		if _, err := io.ReadFull(c.br, ext[:]); err != nil {
+			return err
+		}
+		length = int64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		if _, err := io.ReadFull(c.br, ext[:]); err != nil {
+			return err
+		}
+		length = int64(binary.BigEndian.Uint64(ext[:]))
+	}
+	if masked {
+		if _, err := io.ReadFull(c.br, c.mask[:]); err != nil {
+			return err
+		}
+	}
+	c.masked, c.pos = masked, 0
+
+	switch {
+	case head[0]&0x70 != 0, length < 0:
+		return c.fail(closeProtocol, "reserved bits or length set")
+	case masked == c.client:
+		return c.fail(closeProtocol, "frame masked wrongly")
+	case opcode >= opClose && (!fin || length > 125):
+		return c.fail(closeProtocol, "control frame fragmented or too long")
+	case opcode == opText:
+		return c.fail(closeUnsupported, "text messages not supported")
+	case opcode == opContinuation && !c.fragmented, opcode == opBinary && c.fragmented:
+		return c.fail(closeProtocol, "unexpected continuation")
+	case opcode == opBinary || opcode == opContinuation:
+		c.remaining, c.fragmented = length, !fin
+		return nil
+	case opcode < opClose || opcode > opPong:
+		return c.fail(closeProtocol, fmt.Sprintf("unknown opcode %d", opcode))
+	}
+
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(c.br, payload); err != nil {
+		return err
+	}
+	c.unmask(payload)
+	switch opcode {
+	case opPing:
+		if err := c.writeFrame(opPong, payload); err != nil {
+			return err
+		}
+	case opClose:
+		code := closeNormal
+		if len(payload) >= 2 {
+			code = int(binary.BigEndian.Uint16(payload))
+		}
+		c.closeWith(code)
+		return io.EOF
+	}
+	// pongs are ignored
+	return nil
+}
+
+func (c *Conn) unmask(p []byte) {
+	if !c.masked {
+		return
+	}
+	for i := range p {
+		p[i] ^= c.mask[c.pos&3]
+		c.pos++
+	}
+}
+
+// fail closes the connection broken by the peer with code
+func (c *Conn) fail(code int, reason string) error {
+	c.closeWith(code)
+	return fmt.Errorf("%w: %s", ErrProtocol, reason)
+}
+
+// Write sends p as a binary message
+func (c *Conn) Write(p []byte) (int, error) {
+	if err := c.writeFrame(opBinary, p); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// writeFrame sends a frame with the final bit set
+func (c *Conn) writeFrame(opcode byte, payload []byte) error {
+	c.wmutex.Lock()
+	defer c.wmutex.Unlock()
+	if c.closed {
+		return net.ErrClosed
+	}
+	if opcode == opClose {
+		c.closed = true
+	}
+	frame := make([]byte, 0, 14+len(payload))
+	frame = append(frame, 0x80|opcode)
+	var maskBit byte
+	if c.client {
+		maskBit = 0x80
+	}
+	switch n := len(payload); {
+	case n <= 125:
+		frame = append(frame, maskBit|byte(n))
+	case n <= 0xffff:
+		frame = append(frame, maskBit|126, byte(n>>8), byte(n))
+	default:
+		var ext [8]byte
+		binary.BigEndian.PutUint64(ext[:], uint64(n))
+		frame = append(append(frame, maskBit|127), ext[:]...)
+	}
+	if !c.client {
+		frame = append(frame, payload...)
+	} else {
+		var mask [4]byte
+		if _, err := rand.Read(mask[:]); err != nil {
+			return err
+		}
+		frame = append(frame, mask[:]...)
+		for i, b := range payload {
+			frame = append(frame, b^mask[i&3])
+		}
+	}
+	_, err := c.conn.Write(frame)
+	return err
+}
+
+// closeWith sends a close frame with code, if none was sent, and closes the connection
+func (c *Conn) closeWith(code int) error {
+	var payload [2]byte
+	binary.BigEndian.PutUint16(payload[:], uint16(code))
+	c.conn.SetWriteDeadline(time.Now().Add(time.Second))
+	c.writeFrame(opClose, payload[:])
+	return c.conn.Close()
+}
+
+// Close sends a close frame and closes the connection, without waiting for the close frame of the peer
+func (c *Conn) Close() error {
+	return c.closeWith(closeNormal)
+}
+
+// LocalAddr returns the local address of the connection
+func (c *Conn) LocalAddr() net.Addr { return c.conn.LocalAddr() }
+
+// RemoteAddr returns the address of the peer
+func (c *Conn) RemoteAddr() net.Addr { return c.conn.RemoteAddr() }
+
+// SetDeadline sets the read and write deadlines of the connection
+func (c *Conn) SetDeadline(t time.Time) error { return c.conn.SetDeadline(t) }
+
+// SetReadDeadline sets the read deadline of the connection
+func (c *Conn) SetReadDeadline(t time.Time) error { return c.conn.SetReadDeadline(t) }
+
+// SetWriteDeadline sets the write deadline of the connection
+func (c *Conn) SetWriteDeadline(t time.Time) error { return c.conn.SetWriteDeadline(t) }
+
+// acceptKey returns the Sec-WebSocket-Accept of the Sec-WebSocket-Key key
+func acceptKey(key string) string {
+	h := sha1.Sum([]byte(key + acceptGUID))
+	return base64.StdEncoding.EncodeToString(h[:])
+}
+
+// hasToken reports whether the comma-separated header name of h has token
+func hasToken(h http.Header, name, token string) bool {
+	for _, v := range h.Values(name) {
+		for _, t := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(t), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Option configures Upgrade and Handler
+type Option func(o *options)
+
+type options struct {
+	checkOrigin func(r *http.Request) bool
+}
+
+// WithCheckOrigin accepts the handshakes for which check returns true, instead of
+// the ones without an Origin header or from an origin of the host of the request.
+// The browsers send the handshakes of any page with the cookies of the host: the
+// origins of other hosts should only be accepted if the connections need none.
+func WithCheckOrigin(check func(r *http.Request) bool) Option {
+	return func(o *options) {
+		o.checkOrigin = check
+	}
+}
+
+// sameOrigin reports whether r has no Origin header, as sent by the clients which
+// are not browsers, or an origin of the host of r
+func sameOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	u, err := url.Parse(origin)
+	return err == nil && strings.EqualFold(u.Host, r.Host)
+}
+
+// Upgrade upgrades the HTTP request r to a WebSocket connection. It answers the
+// requests which are not WebSocket handshakes, or come from another origin than
+// the host of r, with an HTTP error, and returns it.
+func Upgrade(w http.ResponseWriter, r *http.Request, opts ...Option) (*Conn, error) {
+	options := options{checkOrigin: sameOrigin}
+	for _, opt := range opts {
+		opt(&options)
+	}
+	fail := func(code int, msg string) (*Conn, error) {
+		http.Error(w, msg, code)
+		return nil, errors.New("websocket: " + msg)
+	}
+	switch {
+	case r.Method != http.MethodGet:
+		w.Header().Set("Allow", http.MethodGet)
+		return fail(http.StatusMethodNotAllowed, "method "+r.Method+" not allowed")
+	case !hasToken(r.Header, "Connection", "upgrade") || !hasToken(r.Header, "Upgrade", "websocket"):
+		w.Header().Set("Upgrade", "websocket")
+		return fail(http.StatusUpgradeRequired, "not a websocket handshake")
+	case r.Header.Get("Sec-WebSocket-Version") != "13":
+		w.Header().Set("Sec-WebSocket-Version", "13")
+		return fail(http.StatusUpgradeRequired, "unsupported websocket version")
+	case !options.checkOrigin(r):
+		return fail(http.StatusForbidden, "origin "+r.Header.Get("Origin")+" not allowed")
+	}
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if decoded, err := base64.StdEncoding.DecodeString(key); err != nil || len(decoded) != 16 {
+		return fail(http.StatusBadRequest, "invalid Sec-WebSocket-Key")
+	}
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		return fail(http.StatusInternalServerError, "connection cannot be hijacked")
+	}
+	conn, rw, err := hijacker.Hijack()
+	if err != nil {
+		return nil, err
+	}
+	response := "HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n" +
+		"Sec-WebSocket-Accept: " + acceptKey(key) + "\r\n"
+	if hasToken(r.Header, "Sec-WebSocket-Protocol", Protocol) {
+		response += "Sec-WebSocket-Protocol: " + Protocol + "\r\n"
+	}
+	if _, err = rw.WriteString(response + "\r\n"); err == nil {
+		err = rw.Flush()
+	}
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return &Conn{conn: conn, br: rw.Reader}, nil
+}
+
+// Handler returns an http.Handler upgrading the requests to WebSocket connections
+// served by serve, which should return once done with them
+func Handler(serve func(conn *Conn), opts ...Option) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := Upgrade(w, r, opts...)
+		if err != nil {
+			return
+		}
+		serve(conn)
+	})
+}
+
+// Dial opens a WebSocket connection to rawURL, ws://host[:port]/path or wss:// for TLS,
+// offering Protocol. ctx bounds the handshake.
+func Dial(ctx context.Context, rawURL string) (*Conn, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	addr := u.Host
+	switch u.Scheme {
+	case "ws":
+		if u.Port() == "" {
+			addr = net.JoinHostPort(u.Hostname(), "80")
+		}
+	case "wss":
+		if u.Port() == "" {
+			addr = net.JoinHostPort(u.Hostname(), "443")
+		}
+	default:
+		return nil, fmt.Errorf("websocket: unsupported scheme %q of %s", u.Scheme, rawURL)
+	}
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme == "wss" {
+		tlsConn := tls.Client(conn, &tls.Config{ServerName: u.Hostname()})
+		if err = tlsConn.HandshakeContext(ctx); err != nil {
+			conn.Close()
+			return nil, err
+		}
+		conn = tlsConn
+	}
+	c, err := handshake(ctx, conn, u)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+// handshake sends the opening handshake of the client to conn, and reads the one of the server
+func handshake(ctx context.Context, conn net.Conn, u *url.URL) (*Conn, error) {
+	// ctx interrupts the handshake through the deadlines of conn
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		select {
+		case <-ctx.Done():
+			conn.SetDeadline(time.Unix(1, 0))
+		case <-done:
+		}
+	}()
+	defer func() {
+		close(done)
+		<-stopped
+		conn.SetDeadline(time.Time{})
+	}()
+
+	var nonce [16]byte
+	if _, err := rand.Read(nonce[:]); err != nil {
+		return nil, err
+	}
+	key := base64.StdEncoding.EncodeToString(nonce[:])
+	httpURL := *u
+	httpURL.Scheme = strings.Replace(u.Scheme, "ws", "http", 1)
+	req := &http.Request{
+		Method:     http.MethodGet,
+		URL:        &httpURL,
+		Proto:      "HTTP/1.1",
+		ProtoMajor: 1,
+		ProtoMinor: 1,
+		Header: http.Header{
+			"Upgrade":                {"websocket"},
+			"Connection":             {"Upgrade"},
+			"Sec-WebSocket-Key":      {key},
+			"Sec-WebSocket-Version":  {"13"},
+			"Sec-WebSocket-Protocol": {Protocol},
+		},
+		Host: u.Host,
+	}
+	if err := req.Write(conn); err != nil {
+		return nil, ctxErr(ctx, err)
+	}
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, req)
+	if err != nil {
+		return nil, ctxErr(ctx, err)
+	}
+	resp.Body.Close()
+	switch {
+	case resp.StatusCode != http.StatusSwitchingProtocols:
+		return nil, fmt.Errorf("websocket: handshake of %s failed with %s", u, resp.Status)
+	case !hasToken(resp.Header, "Upgrade", "websocket") || !hasToken(resp.Header, "Connection", "upgrade"):
+		return nil, fmt.Errorf("websocket: handshake of %s not upgraded", u)
+	case resp.Header.Get("Sec-WebSocket-Accept") != acceptKey(key):
+		return nil, fmt.Errorf("websocket: handshake of %s with a wrong Sec-WebSocket-Accept", u)
+	}
+	return &Conn{conn: conn, br: br, client: true}, nil
+}
+
+func ctxErr(ctx context.Context, err error) error {
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	return err
+}
+
+// Dialer returns a dialer of the WebSocket connections to the addresses host:port
+// at path, with scheme "ws" or "wss", such as TRPcG.WithDialer expects
+func Dialer(scheme, path string) func(ctx context.Context, addr string) (net.Conn, error) {
+	return func(ctx context.Context, addr string) (net.Conn, error) {
+		u := url.URL{Scheme: scheme, Host: addr, Path: path}
+		conn, err := Dial(ctx, u.String())
+		if err != nil {
+			return nil, err
+		}
+		return conn, nil
+	}
+}
@@ -0,0 +1,239 @@
+package websocket
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/rpc"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mizumoto-cn/TRPcG/codec"
+	"github.com/mizumoto-cn/TRPcG/compressor"
+	"github.com/mizumoto-cn/TRPcG/serializer"
+	"github.com/stretchr/testify/assert"
+)
+
+// echo serves a handler echoing the data received, and returns its ws:// URL
+func echo(t *testing.T) string {
+	s := httptest.NewServer(Handler(func(conn *Conn) {
+		defer conn.Close()
+		io.Copy(conn, conn)
+	}))
+	t.Cleanup(s.Close)
+	return "ws" + strings.TrimPrefix(s.URL, "http") + "/echo"
+}
+
+func TestAcceptKey(t *testing.T) {
+	// the example of RFC 6455
+	assert.Equal(t, "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=", acceptKey("dGhlIHNhbXBsZSBub25jZQ=="))
+}
+
+func TestConn(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	conn, err := Dial(ctx, echo(t))
+	if err != nil {
+		t.Fatal("dial error:", err)
+	}
+	defer conn.Close()
+
+	for _, size := range []int{0, 1, 125, 126, 0xffff, 0x10000, 1 << 20} {
+		data := bytes.Repeat([]byte{byte(size)}, size)
+		data = append(data, "end"...)
+		n, err := conn.Write(data)
+		assert.Equal(t, nil, err)
+		assert.Equal(t, len(data), n)
+		got := make([]byte, len(data))
+		_, err = io.ReadFull(conn, got)
+		assert.Equal(t, nil, err)
+		assert.Equal(t, true, bytes.Equal(data, got), size)
+	}
+
+	assert.Equal(t, nil, conn.Close())
+	_, err = conn.Write([]byte("closed"))
+	assert.Equal(t, net.ErrClosed, err)
+}
+
+// echoService answers the calls with their args
+type echoService struct{}
+
+func (echoService) Echo(args *string, reply *string) error {
+	*reply = *args
+	return nil
+}
+
+// countingConn counts the writes of the connection
+type countingConn struct {
+	net.Conn
+	writes int
+}
+
+func (c *countingConn) Write(p []byte) (int, error) {
+	c.writes++
+	return c.Conn.Write(p)
+}
+
+// TestConn_Codec tests that the requests of the codecs are sent as a message each, whatever their size
+func TestConn_Codec(t *testing.T) {
+	server := rpc.NewServer()
+	server.RegisterName("Echo", echoService{})
+	s := httptest.NewServer(Handler(func(conn *Conn) {
+		server.ServeCodec(codec.NewServerCodec(conn, serializer.Json))
+	}))
+	t.Cleanup(s.Close)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	conn, err := Dial(ctx, "ws"+strings.TrimPrefix(s.URL, "http"))
+	if err != nil {
+		t.Fatal("dial error:", err)
+	}
+	counter := &countingConn{Conn: conn.conn}
+	conn.conn = counter
+	client := rpc.NewClientWithCodec(codec.NewClientCodec(conn, compressor.Raw, serializer.Json))
+	defer client.Close()
+
+	for _, size := range []int{10, 5000, 1 << 20} {
+		args, reply := strings.Repeat("x", size), ""
+		writes := counter.writes
+		assert.Equal(t, nil, client.Call("Echo.Echo", &args, &reply))
+		assert.Equal(t, args, reply)
+		assert.Equal(t, writes+1, counter.writes, size)
+	}
+}
+
+// raw opens a WebSocket connection to url with frames written by hand
+func raw(t *testing.T, url string) (net.Conn, *bufio.Reader) {
+	conn, err := net.Dial("tcp", strings.TrimPrefix(strings.TrimSuffix(url, "/echo"), "ws://"))
+	if err != nil {
+		t.Fatal("dial error:", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	conn.Write([]byte("GET /echo HTTP/1.1\r\nHost: x\r\nUpgrade: websocket\r\nConnection: keep-alive, Upgrade\r\n" +
+		"Sec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\nSec-WebSocket-Version: 13\r\n\r\n"))
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, nil)
+	if err != nil {
+		t.Fatal("handshake error:", err)
+	}
+	assert.Equal(t, http.StatusSwitchingProtocols, resp.StatusCode)
+	assert.Equal(t, "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=", resp.Header.Get("Sec-WebSocket-Accept"))
+	assert.Equal(t, "", resp.Header.Get("Sec-WebSocket-Protocol"))
+	return conn, br
+}
+
+// masked returns a frame of the client with a zero mask
+func masked(first byte, payload string) []byte {
+	return append([]byte{first, 0x80 | byte(len(payload)), 0, 0, 0, 0}, payload...)
+}
+
+func TestConn_Frames(t *testing.T) {
+	conn, br := raw(t, echo(t))
+
+	// a fragmented message with a ping in between
+	conn.Write(masked(opBinary, "ab"))
+	conn.Write(masked(0x80|opPing, "hi"))
+	conn.Write(masked(0x80|opContinuation, "c"))
+	// answered with a pong, and echoed in binary frames, in any order
+	var pong, data []byte
+	for pong == nil || len(data) < 3 {
+		head := make([]byte, 2)
+		if _, err := io.ReadFull(br, head); err != nil {
+			t.Fatal("read error:", err)
+		}
+		payload := make([]byte, head[1])
+		io.ReadFull(br, payload)
+		switch head[0] {
+		case 0x80 | opPong:
+			pong = payload
+		case 0x80 | opBinary:
+			data = append(data, payload...)
+		default:
+			t.Fatalf("unexpected frame %x", head)
+		}
+	}
+	assert.Equal(t, "hi", string(pong))
+	assert.Equal(t, "abc", string(data))
+
+	// text messages are rejected
+	conn.Write(masked(0x80|opText, "text"))
+	frame, _ := io.ReadAll(br)
+	assert.Equal(t, []byte{0x80 | opClose, 2, 0x03, 0xeb}, frame)
+
+	// so are unmasked frames
+	conn, br = raw(t, echo(t))
+	conn.Write([]byte{0x80 | opBinary, 1, 'x'})
+	frame, _ = io.ReadAll(br)
+	assert.Equal(t, []byte{0x80 | opClose, 2, 0x03, 0xea}, frame)
+
+	// and closes are answered
+	conn, br = raw(t, echo(t))
+	conn.Write(masked(0x80|opClose, "\x03\xe8"))
+	frame, _ = io.ReadAll(br)
+	assert.Equal(t, []byte{0x80 | opClose, 2, 0x03, 0xe8}, frame)
+}
+
+// handshakeStatus returns the status of a handshake to url sent from a page of origin
+func handshakeStatus(t *testing.T, url string, origin string) int {
+	req, _ := http.NewRequest(http.MethodGet, url, nil)
+	req.Header = http.Header{
+		"Upgrade":               {"websocket"},
+		"Connection":            {"Upgrade"},
+		"Sec-WebSocket-Key":     {"dGhlIHNhbXBsZSBub25jZQ=="},
+		"Sec-WebSocket-Version": {"13"},
+		"Origin":                {origin},
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal("handshake error:", err)
+	}
+	resp.Body.Close()
+	return resp.StatusCode
+}
+
+func TestUpgrade_Origin(t *testing.T) {
+	s := httptest.NewServer(Handler(func(conn *Conn) { conn.Close() }))
+	t.Cleanup(s.Close)
+	assert.Equal(t, http.StatusSwitchingProtocols, handshakeStatus(t, s.URL, ""))
+	assert.Equal(t, http.StatusSwitchingProtocols, handshakeStatus(t, s.URL, s.URL))
+	assert.Equal(t, http.StatusForbidden, handshakeStatus(t, s.URL, "https://evil.example"))
+	assert.Equal(t, http.StatusForbidden, handshakeStatus(t, s.URL, "null"))
+
+	s = httptest.NewServer(Handler(func(conn *Conn) { conn.Close() }, WithCheckOrigin(func(r *http.Request) bool {
+		return r.Header.Get("Origin") == "https://app.example"
+	})))
+	t.Cleanup(s.Close)
+	assert.Equal(t, http.StatusSwitchingProtocols, handshakeStatus(t, s.URL, "https://app.example"))
+	assert.Equal(t, http.StatusForbidden, handshakeStatus(t, s.URL, "https://evil.example"))
+}
+
+func TestUpgrade_Errors(t *testing.T) {
+	url := "http" + strings.TrimPrefix(echo(t), "ws")
+	resp, err := http.Post(url, "text/plain", nil)
+	if err != nil {
+		t.Fatal("post error:", err)
+	}
+	resp.Body.Close()
+	assert.Equal(t, http.StatusMethodNotAllowed, resp.StatusCode)
+
+	resp, err = http.Get(url)
+	if err != nil {
+		t.Fatal("get error:", err)
+	}
+	resp.Body.Close()
+	assert.Equal(t, http.StatusUpgradeRequired, resp.StatusCode)
+
+	_, err = Dial(context.Background(), "http://localhost/")
+	assert.Contains(t, err.Error(), `unsupported scheme "http"`)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = Dial(ctx, echo(t))
+	assert.NotEqual(t, nil, err)
+}